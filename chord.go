@@ -0,0 +1,95 @@
+package main
+
+import "slices"
+
+// chord tracks which of a set of key codes are currently held, possibly
+// across several devices, and collapses them into a single trigger. The
+// trigger goes down when the last missing code is pressed and up as
+// soon as any of the codes is released.
+//
+// A code counts as held while at least one device reports it as held,
+// so two keyboards sharing a modifier do not break the chord when only
+// one of them lets go.
+type chord struct {
+	codes  []uint16
+	held   map[chordKey]struct{}
+	active bool
+}
+
+type chordKey struct {
+	device string
+	code   uint16
+}
+
+func newChord(codes []uint16) *chord {
+	return &chord{
+		codes: codes,
+		held:  make(map[chordKey]struct{}),
+	}
+}
+
+// Update records a per-code event and reports the resulting trigger
+// event, if the chord changed state because of it.
+func (c *chord) Update(ev event) (event, bool) {
+	key := chordKey{device: ev.Device, code: ev.Code}
+	switch ev.Type {
+	case eventDown:
+		c.held[key] = struct{}{}
+	case eventUp:
+		delete(c.held, key)
+	default:
+		return ev, true
+	}
+
+	complete := c.complete()
+	if complete == c.active {
+		return event{}, false
+	}
+	c.active = complete
+
+	// A press can only complete the chord and a release can only break
+	// it, so the event that changed the state doubles as the trigger.
+	return ev, true
+}
+
+func (c *chord) complete() bool {
+	for _, code := range c.codes {
+		if !c.isHeld(code) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *chord) isHeld(code uint16) bool {
+	for key := range c.held {
+		if key.code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Devices returns the devices that are currently holding any of the
+// chord's codes.
+func (c *chord) Devices() []string {
+	var devices []string
+	for key := range c.held {
+		if !slices.Contains(devices, key.device) {
+			devices = append(devices, key.device)
+		}
+	}
+	slices.Sort(devices)
+	return devices
+}
+
+// Forget drops everything that dev is holding as if it had been
+// released, without producing a trigger event.
+func (c *chord) Forget(dev string) {
+	for key := range c.held {
+		if key.device == dev {
+			delete(c.held, key)
+		}
+	}
+	c.active = c.complete()
+}
@@ -0,0 +1,81 @@
+package main
+
+import "testing"
+
+func TestChord(t *testing.T) {
+	type step struct {
+		ev   event
+		want eventType // eventInvalid means no trigger event
+	}
+	down := func(dev string, code uint16) event { return event{Type: eventDown, Device: dev, Code: code} }
+	up := func(dev string, code uint16) event { return event{Type: eventUp, Device: dev, Code: code} }
+
+	cases := []struct {
+		name  string
+		codes []uint16
+		steps []step
+	}{
+		{
+			name:  "single key",
+			codes: []uint16{56},
+			steps: []step{
+				{down("kbd", 56), eventDown},
+				{up("kbd", 56), eventUp},
+			},
+		},
+		{
+			name:  "modifier then button across devices",
+			codes: []uint16{29, 0x113},
+			steps: []step{
+				{down("mouse", 0x113), eventInvalid},
+				{up("mouse", 0x113), eventInvalid},
+				{down("kbd", 29), eventInvalid},
+				{down("mouse", 0x113), eventDown},
+				{up("kbd", 29), eventUp},
+				{up("mouse", 0x113), eventInvalid},
+			},
+		},
+		{
+			name:  "shared code held on two devices",
+			codes: []uint16{29, 0x113},
+			steps: []step{
+				{down("kbd0", 29), eventInvalid},
+				{down("kbd1", 29), eventInvalid},
+				{down("mouse", 0x113), eventDown},
+				{up("kbd0", 29), eventInvalid},
+				{up("kbd1", 29), eventUp},
+			},
+		},
+		{
+			name:  "rechord after partial release",
+			codes: []uint16{29, 0x113},
+			steps: []step{
+				{down("kbd", 29), eventInvalid},
+				{down("mouse", 0x113), eventDown},
+				{up("mouse", 0x113), eventUp},
+				{down("mouse", 0x113), eventDown},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newChord(tc.codes)
+			for i, s := range tc.steps {
+				got, ok := c.Update(s.ev)
+				if !ok {
+					if s.want != eventInvalid {
+						t.Fatalf("step %d (%+v): no event, want %v", i, s.ev, s.want)
+					}
+					continue
+				}
+				if got.Type != s.want {
+					t.Fatalf("step %d (%+v): got %v, want %v", i, s.ev, got.Type, s.want)
+				}
+				if got.Device != s.ev.Device {
+					t.Fatalf("step %d: device = %q, want %q", i, got.Device, s.ev.Device)
+				}
+			}
+		})
+	}
+}
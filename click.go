@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"math"
+	"time"
+
+	"deedles.dev/ptt-fix/internal/pulse"
+)
+
+const (
+	// clickRate is the sample rate of the clicks.
+	clickRate = 48000
+
+	// clickLength is how long each click lasts.
+	clickLength = 40 * time.Millisecond
+
+	// clickOnFreq and clickOffFreq are the pitches of the clicks for
+	// starting and stopping transmission, in Hz.
+	clickOnFreq  = 1320
+	clickOffFreq = 880
+
+	// clickVolume is the peak amplitude of the clicks, out of 1.
+	clickVolume = 0.25
+
+	// clickQueue is how many clicks may wait to be played before more
+	// are dropped.
+	clickQueue = 4
+
+	// clickCloseTimeout limits how long Close waits for queued clicks.
+	clickCloseTimeout = 5 * time.Second
+)
+
+// Names of the clicks in the pulse server's sample cache.
+const (
+	clickOnSample  = "ptt-fix-on"
+	clickOffSample = "ptt-fix-off"
+)
+
+// clickConn is the part of *pulse.Client that clickSender uses.
+type clickConn interface {
+	UploadSample(name string, rate uint32, pcm []int16) error
+	PlaySample(name string) error
+	RemoveSample(name string) error
+	Close() error
+}
+
+// clickSender plays a short click through the pulse server whenever
+// transmission starts or stops. The clicks are uploaded to the server's
+// sample cache once and then played from there by name. As with mqttSender,
+// this happens on a goroutine of its own, so that the server never holds up
+// the other outputs.
+type clickSender struct {
+	logger *slog.Logger
+	dial   func() (clickConn, error)
+
+	q    chan string
+	done chan struct{}
+}
+
+// newClickSender returns a clickSender for the default pulse server and
+// starts its worker. The sender must be closed to stop the worker.
+func newClickSender(logger *slog.Logger) *clickSender {
+	dial := func() (clickConn, error) {
+		c, err := pulse.Dial("")
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	return startClickSender(logger, dial)
+}
+
+func startClickSender(logger *slog.Logger, dial func() (clickConn, error)) *clickSender {
+	s := clickSender{
+		logger: logger,
+		dial:   dial,
+		q:      make(chan string, clickQueue),
+		done:   make(chan struct{}),
+	}
+	go s.work()
+	return &s
+}
+
+func (s *clickSender) Up() error {
+	s.play(clickOffSample)
+	return nil
+}
+
+func (s *clickSender) Down() error {
+	s.play(clickOnSample)
+	return nil
+}
+
+func (s *clickSender) play(sample string) {
+	select {
+	case s.q <- sample:
+	default:
+		s.logger.Debug("click queue full, dropping", "sample", sample)
+	}
+}
+
+// Close plays the clicks that are still queued, removes the clicks from the
+// server and stops the worker.
+func (s *clickSender) Close() error {
+	close(s.q)
+
+	select {
+	case <-s.done:
+		return nil
+	case <-time.After(clickCloseTimeout):
+		return errors.New("timed out playing clicks")
+	}
+}
+
+func (s *clickSender) work() {
+	defer close(s.done)
+
+	var c clickConn
+	defer func() {
+		if c != nil {
+			c.RemoveSample(clickOnSample)
+			c.RemoveSample(clickOffSample)
+			c.Close()
+		}
+	}()
+
+	var failing bool
+	for sample := range s.q {
+		// A late click is worse than none, so clicks that fail are
+		// not retried.
+		if c == nil {
+			var err error
+			c, err = s.connect()
+			if err != nil {
+				if !failing {
+					s.logger.Warn("cannot connect to pulse server for clicks", errKey, err)
+				}
+				failing = true
+				continue
+			}
+		}
+
+		err := c.PlaySample(sample)
+		if err != nil {
+			if !failing {
+				s.logger.Warn("cannot play click", errKey, err)
+			}
+			failing = true
+
+			// Reconnecting uploads the clicks again, in case the
+			// server was restarted or they were removed from it.
+			c.Close()
+			c = nil
+			continue
+		}
+		failing = false
+	}
+}
+
+// connect connects to the server and uploads the clicks.
+func (s *clickSender) connect() (clickConn, error) {
+	c, err := s.dial()
+	if err != nil {
+		return nil, err
+	}
+	for name, freq := range map[string]float64{clickOnSample: clickOnFreq, clickOffSample: clickOffFreq} {
+		if err := c.UploadSample(name, clickRate, clickTone(freq)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// clickTone returns a short sine tone at freq that fades in and out so that
+// it doesn't pop.
+func clickTone(freq float64) []int16 {
+	n := int(clickRate * clickLength / time.Second)
+	fade := n / 4
+	pcm := make([]int16, n)
+	for i := range pcm {
+		amp := clickVolume
+		switch {
+		case i < fade:
+			amp *= float64(i) / float64(fade)
+		case i >= n-fade:
+			amp *= float64(n-1-i) / float64(fade)
+		}
+		pcm[i] = int16(amp * math.MaxInt16 * math.Sin(2*math.Pi*freq*float64(i)/clickRate))
+	}
+	return pcm
+}
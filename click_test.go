@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+)
+
+// fakeClicks records what clickSender does. The play after failPlay plays
+// fails, if it is positive.
+type fakeClicks struct {
+	failPlay int
+	dials    int
+	log      []string
+}
+
+func (f *fakeClicks) dial() (clickConn, error) {
+	f.dials++
+	return &fakeClickConn{f: f, samples: make(map[string]bool)}, nil
+}
+
+type fakeClickConn struct {
+	f       *fakeClicks
+	samples map[string]bool
+}
+
+func (c *fakeClickConn) UploadSample(name string, rate uint32, pcm []int16) error {
+	if (rate != clickRate) || (len(pcm) == 0) {
+		return errors.New("bad sample")
+	}
+	c.samples[name] = true
+	return nil
+}
+
+func (c *fakeClickConn) PlaySample(name string) error {
+	if c.f.failPlay > 0 {
+		c.f.failPlay--
+		if c.f.failPlay == 0 {
+			return errors.New("connection reset")
+		}
+	}
+	if !c.samples[name] {
+		return errors.New("no such entity")
+	}
+	c.f.log = append(c.f.log, "play "+name)
+	return nil
+}
+
+func (c *fakeClickConn) RemoveSample(name string) error {
+	delete(c.samples, name)
+	c.f.log = append(c.f.log, "remove "+name)
+	return nil
+}
+
+func (c *fakeClickConn) Close() error { return nil }
+
+func TestClickSender(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	f := fakeClicks{failPlay: 2}
+	s := startClickSender(logger, f.dial)
+
+	// The second click fails, and the sender reconnects for the third.
+	s.Down()
+	s.Up()
+	s.Down()
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"play " + clickOnSample,
+		"play " + clickOnSample,
+		"remove " + clickOnSample,
+		"remove " + clickOffSample,
+	}
+	if !slices.Equal(f.log, want) {
+		t.Errorf("log = %q, want %q", f.log, want)
+	}
+	if f.dials != 2 {
+		t.Errorf("dialed %v times, want 2", f.dials)
+	}
+}
+
+func TestClickTone(t *testing.T) {
+	pcm := clickTone(clickOnFreq)
+	if len(pcm) != 1920 {
+		t.Fatalf("len = %v, want 1920", len(pcm))
+	}
+	// It fades in and out so that it doesn't pop.
+	if (pcm[0] != 0) || (pcm[len(pcm)-1] != 0) {
+		t.Errorf("tone starts at %v and ends at %v, want 0", pcm[0], pcm[len(pcm)-1])
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+	"time"
+)
+
+// debouncer merges key transitions from a single device that follow
+// each other more closely than that device's debounce duration, as
+// produced by chattering switches.
+//
+// The first transition after a quiet period is passed through right
+// away. Anything that happens within the debounce duration after it is
+// absorbed, and once the duration has passed the key's latest state is
+// reported if it differs from what was last reported. Pending states
+// are reported by Flush, which should be called at Deadline.
+type debouncer struct {
+	durations map[string]time.Duration
+
+	keys map[chordKey]*debounceState
+}
+
+type debounceState struct {
+	reported eventType
+	raw      eventType
+	changed  time.Time
+	pending  bool
+}
+
+func newDebouncer(durations map[string]time.Duration) *debouncer {
+	return &debouncer{
+		durations: durations,
+		keys:      make(map[chordKey]*debounceState),
+	}
+}
+
+// Update records ev and reports whether it should be passed on.
+func (d *debouncer) Update(ev event) (event, bool) {
+	dur := d.durations[ev.Device]
+	if (dur <= 0) || ((ev.Type != eventDown) && (ev.Type != eventUp)) {
+		return ev, true
+	}
+
+	key := chordKey{device: ev.Device, code: ev.Code}
+	s, ok := d.keys[key]
+	if !ok {
+		s = &debounceState{reported: eventUp}
+		d.keys[key] = s
+	}
+	s.raw = ev.Type
+
+	if s.changed.IsZero() || (ev.Time.Sub(s.changed) >= dur) {
+		s.pending = false
+		if s.raw == s.reported {
+			return event{}, false
+		}
+		s.reported = s.raw
+		s.changed = ev.Time
+		return ev, true
+	}
+
+	s.pending = s.raw != s.reported
+	return event{}, false
+}
+
+// Deadline returns the earliest time at which a pending state needs to
+// be flushed.
+func (d *debouncer) Deadline() (time.Time, bool) {
+	var deadline time.Time
+	for key, s := range d.keys {
+		if !s.pending {
+			continue
+		}
+		t := s.changed.Add(d.durations[key.device])
+		if deadline.IsZero() || t.Before(deadline) {
+			deadline = t
+		}
+	}
+	return deadline, !deadline.IsZero()
+}
+
+// Flush reports the pending states whose debounce duration has run out
+// by now.
+func (d *debouncer) Flush(now time.Time) []event {
+	var events []event
+	for key, s := range d.keys {
+		if !s.pending {
+			continue
+		}
+		t := s.changed.Add(d.durations[key.device])
+		if now.Before(t) {
+			continue
+		}
+
+		s.pending = false
+		s.reported = s.raw
+		s.changed = t
+		events = append(events, event{Type: s.raw, Device: key.device, Code: key.code, Time: t})
+	}
+	slices.SortFunc(events, func(e1, e2 event) int {
+		return cmp.Or(
+			e1.Time.Compare(e2.Time),
+			cmp.Compare(e1.Device, e2.Device),
+			cmp.Compare(e1.Code, e2.Code),
+		)
+	})
+	return events
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDebouncer(t *testing.T) {
+	const dur = 20 * time.Millisecond
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(ms int) time.Time { return base.Add(time.Duration(ms) * time.Millisecond) }
+
+	type input struct {
+		ms  int
+		typ eventType
+		dev string // defaults to "pedal"
+	}
+	type output struct {
+		ms  int
+		typ eventType
+	}
+
+	cases := []struct {
+		name string
+		in   []input
+		// flush is the time to flush at after all inputs, or -1.
+		flush int
+		want  []output
+	}{
+		{
+			name:  "clean press and release",
+			in:    []input{{0, eventDown, ""}, {100, eventUp, ""}},
+			flush: -1,
+			want:  []output{{0, eventDown}, {100, eventUp}},
+		},
+		{
+			name: "bouncing press",
+			in: []input{
+				{0, eventDown, ""}, {2, eventUp, ""}, {4, eventDown, ""}, {5, eventUp, ""}, {7, eventDown, ""},
+				{200, eventUp, ""},
+			},
+			flush: -1,
+			want:  []output{{0, eventDown}, {200, eventUp}},
+		},
+		{
+			name: "bouncing release",
+			in: []input{
+				{0, eventDown, ""},
+				{200, eventUp, ""}, {201, eventDown, ""}, {203, eventUp, ""},
+			},
+			flush: 300,
+			want:  []output{{0, eventDown}, {200, eventUp}},
+		},
+		{
+			name:  "tap shorter than debounce is released on flush",
+			in:    []input{{0, eventDown, ""}, {10, eventUp, ""}},
+			flush: 20,
+			want:  []output{{0, eventDown}, {20, eventUp}},
+		},
+		{
+			name:  "flush before deadline does nothing",
+			in:    []input{{0, eventDown, ""}, {10, eventUp, ""}},
+			flush: 19,
+			want:  []output{{0, eventDown}},
+		},
+		{
+			name: "transition exactly at the threshold passes",
+			in:   []input{{0, eventDown, ""}, {20, eventUp, ""}},
+			want: []output{{0, eventDown}, {20, eventUp}},
+		},
+		{
+			name: "press after a bounce keeps the key held and a later press passes",
+			in: []input{
+				{0, eventDown, ""}, {5, eventUp, ""},
+				{30, eventDown, ""},
+				{100, eventUp, ""}, {200, eventDown, ""},
+			},
+			flush: -1,
+			want:  []output{{0, eventDown}, {100, eventUp}, {200, eventDown}},
+		},
+		{
+			name:  "duplicate events are dropped",
+			in:    []input{{0, eventDown, ""}, {100, eventDown, ""}, {200, eventUp, ""}, {300, eventUp, ""}},
+			flush: -1,
+			want:  []output{{0, eventDown}, {200, eventUp}},
+		},
+		{
+			name:  "devices without debounce pass through",
+			in:    []input{{0, eventDown, "kbd"}, {1, eventUp, "kbd"}, {2, eventDown, "kbd"}},
+			flush: -1,
+			want:  []output{{0, eventDown}, {1, eventUp}, {2, eventDown}},
+		},
+		{
+			name: "devices are debounced independently",
+			in: []input{
+				{0, eventDown, ""}, {1, eventDown, "pedal2"},
+				{5, eventUp, "pedal2"}, {10, eventUp, ""},
+			},
+			flush: 40,
+			want:  []output{{0, eventDown}, {1, eventDown}, {20, eventUp}, {21, eventUp}},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newDebouncer(map[string]time.Duration{"pedal": dur, "pedal2": dur})
+
+			var got []event
+			for _, in := range tc.in {
+				dev := in.dev
+				if dev == "" {
+					dev = "pedal"
+				}
+				ev, ok := d.Update(event{Type: in.typ, Device: dev, Code: 0x113, Time: at(in.ms)})
+				if ok {
+					got = append(got, ev)
+				}
+			}
+			if tc.flush >= 0 {
+				got = append(got, d.Flush(at(tc.flush))...)
+			}
+
+			if len(got) != len(tc.want) {
+				t.Fatalf("got %d events %+v, want %+v", len(got), got, tc.want)
+			}
+			for i, w := range tc.want {
+				if got[i].Type != w.typ || !got[i].Time.Equal(at(w.ms)) {
+					t.Errorf("event %d = %v at %v, want %v at %dms", i, got[i].Type, got[i].Time.Sub(base), w.typ, w.ms)
+				}
+			}
+		})
+	}
+}
+
+func TestDebouncer_deadline(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	d := newDebouncer(map[string]time.Duration{"a": 20 * time.Millisecond, "b": 5 * time.Millisecond})
+
+	if _, ok := d.Deadline(); ok {
+		t.Fatal("no deadline expected without pending keys")
+	}
+
+	d.Update(event{Type: eventDown, Device: "a", Time: base})
+	d.Update(event{Type: eventUp, Device: "a", Time: base.Add(time.Millisecond)})
+	deadline, ok := d.Deadline()
+	if !ok || !deadline.Equal(base.Add(20*time.Millisecond)) {
+		t.Fatalf("Deadline() = %v, %v, want +20ms", deadline.Sub(base), ok)
+	}
+
+	d.Update(event{Type: eventDown, Device: "b", Time: base.Add(2 * time.Millisecond)})
+	d.Update(event{Type: eventUp, Device: "b", Time: base.Add(3 * time.Millisecond)})
+	deadline, ok = d.Deadline()
+	if !ok || !deadline.Equal(base.Add(7*time.Millisecond)) {
+		t.Fatalf("Deadline() = %v, %v, want +7ms", deadline.Sub(base), ok)
+	}
+
+	// Bouncing back to the reported state cancels the pending flush.
+	d.Update(event{Type: eventDown, Device: "b", Time: base.Add(4 * time.Millisecond)})
+	deadline, ok = d.Deadline()
+	if !ok || !deadline.Equal(base.Add(20*time.Millisecond)) {
+		t.Fatalf("Deadline() = %v, %v, want +20ms", deadline.Sub(base), ok)
+	}
+}
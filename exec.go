@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"deedles.dev/ptt-fix/internal/config"
+)
+
+const (
+	// defaultExecTimeout is used if the config does not set exec-timeout.
+	defaultExecTimeout = 5 * time.Second
+
+	// execQueueSize limits how many commands may wait behind one that is
+	// still running before more are dropped.
+	execQueueSize = 16
+
+	// execWaitDelay is how long a timed out command's output is waited
+	// for after it has been killed, in case something it started keeps
+	// the output open.
+	execWaitDelay = time.Second
+)
+
+// execRunner runs shell commands one at a time, in order, on a goroutine of
+// its own, so that a slow command does not hold up input handling and a
+// release can never overtake the press before it. Every exec sym of an output
+// shares one execRunner, which keeps that true even when the press and the
+// release run different commands.
+//
+// Commands are run with sh -c and are told what happened through the
+// environment:
+//
+//	PTT_EVENT   "down" or "up"
+//	PTT_ACTIVE  "1" for a press and "0" for a release
+//	PTT_DEVICE  the device that caused it
+type execRunner struct {
+	logger  *slog.Logger
+	timeout time.Duration
+
+	// users is the number of execSenders that have yet to be closed.
+	users int
+
+	queue chan execJob
+	done  chan struct{}
+}
+
+type execJob struct {
+	command string
+	down    bool
+	ev      event
+}
+
+// newExecRunner returns an execRunner and starts its worker. A timeout of
+// zero means defaultExecTimeout. The worker stops once every sender made
+// from it has been closed.
+func newExecRunner(logger *slog.Logger, timeout time.Duration) *execRunner {
+	if timeout <= 0 {
+		timeout = defaultExecTimeout
+	}
+
+	r := execRunner{
+		logger:  logger,
+		timeout: timeout,
+		queue:   make(chan execJob, execQueueSize),
+		done:    make(chan struct{}),
+	}
+	go r.work()
+	return &r
+}
+
+// sender returns an execSender that runs commands for sym on r. An exec sym
+// runs its command on both press and release, while exec-down and exec-up
+// syms only run theirs on the one event.
+func (r *execRunner) sender(sym config.Sym) *execSender {
+	r.users++
+
+	s := execSender{r: r}
+	switch sym.Type {
+	case "exec":
+		s.down, s.up = sym.Val, sym.Val
+	case "exec-down":
+		s.down = sym.Val
+	case "exec-up":
+		s.up = sym.Val
+	}
+	return &s
+}
+
+// enqueue queues job. If too many commands are already waiting, such as
+// behind one that hangs, job is dropped and logged instead, so that a slow
+// command never stops push-to-talk itself.
+func (r *execRunner) enqueue(job execJob) {
+	select {
+	case r.queue <- job:
+	default:
+		r.logger.Warn(
+			"too many exec commands waiting, dropping",
+			"command", job.command,
+			"event", execEventName(job.down),
+			"device", job.ev.Device,
+			"waiting", execQueueSize,
+		)
+	}
+}
+
+// release is called when a sender made from r is closed. Once the last one
+// is, it waits for the commands that are already queued to finish and then
+// stops the worker.
+func (r *execRunner) release() {
+	r.users--
+	if r.users > 0 {
+		return
+	}
+	close(r.queue)
+	<-r.done
+}
+
+func (r *execRunner) work() {
+	defer close(r.done)
+
+	for job := range r.queue {
+		start := time.Now()
+		out, err := r.run(job)
+		logger := r.logger.With(
+			"command", job.command,
+			"event", execEventName(job.down),
+			"device", job.ev.Device,
+			"took", time.Since(start),
+		)
+		if out = bytes.TrimSpace(out); len(out) > 0 {
+			logger = logger.With("output", string(out))
+		}
+
+		if err != nil {
+			logger.Warn("exec command failed", errKey, err)
+			continue
+		}
+		logger.Debug("exec command finished")
+	}
+}
+
+// run runs the command for job and returns its combined output.
+func (r *execRunner) run(job execJob) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", job.command)
+	cmd.Env = append(os.Environ(), execEnv(job)...)
+	// Run the command in a process group of its own so that a timeout
+	// kills everything it started, not just the shell.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = execWaitDelay
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return out.Bytes(), fmt.Errorf("timed out after %v", r.timeout)
+	}
+	return out.Bytes(), err
+}
+
+// execSender queues the commands of one exec sym on an execRunner.
+type execSender struct {
+	r *execRunner
+
+	// down and up are the commands to run on a press and on a release.
+	// Either may be empty, in which case nothing is run for that event.
+	down, up string
+
+	// ev is the event that caused the next press or release. See
+	// NoteEvent.
+	ev event
+}
+
+// NoteEvent implements eventNoter.
+func (s *execSender) NoteEvent(ev event) {
+	s.ev = ev
+}
+
+func (s *execSender) Up() error {
+	return s.enqueue(s.up, false)
+}
+
+func (s *execSender) Down() error {
+	return s.enqueue(s.down, true)
+}
+
+func (s *execSender) enqueue(command string, down bool) error {
+	if command == "" {
+		return nil
+	}
+	s.r.enqueue(execJob{command: command, down: down, ev: s.ev})
+	return nil
+}
+
+// Close releases the sender's runner. See execRunner.release.
+func (s *execSender) Close() error {
+	s.r.release()
+	return nil
+}
+
+func execEnv(job execJob) []string {
+	active := "0"
+	if job.down {
+		active = "1"
+	}
+	return []string{
+		"PTT_EVENT=" + execEventName(job.down),
+		"PTT_ACTIVE=" + active,
+		"PTT_DEVICE=" + job.ev.Device,
+	}
+}
+
+func execEventName(down bool) string {
+	if down {
+		return "down"
+	}
+	return "up"
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"deedles.dev/ptt-fix/internal/config"
+)
+
+func TestExecSender_order(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	t.Setenv("PTT_TEST_OUT", out)
+
+	// The press is much slower than the release, which must still wait
+	// for it.
+	cmd := `if [ "$PTT_EVENT" = down ]; then sleep 0.2; fi; echo "$PTT_EVENT $PTT_ACTIVE $PTT_DEVICE" >> "$PTT_TEST_OUT"`
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	// Wrapped, to check that the event reaches it.
+	s := multiSender{newExecRunner(logger, time.Second).sender(config.Sym{Type: "exec", Val: cmd})}
+
+	if err := applyEvent(logger, s, event{Type: eventDown, Device: "pedal"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyEvent(logger, s, event{Type: eventUp, Device: "pedal"}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "down 1 pedal\nup 0 pedal\n"
+	if string(data) != want {
+		t.Fatalf("output = %q, want %q", data, want)
+	}
+}
+
+func TestExecSender_downUp(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	t.Setenv("PTT_TEST_OUT", out)
+
+	// The press is much slower than the release, which runs a different
+	// command on a different sender but must still wait for it.
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	r := newExecRunner(logger, time.Second)
+	s := multiSender{
+		r.sender(config.Sym{Type: "exec-down", Val: `sleep 0.2; echo "1 $PTT_DEVICE" >> "$PTT_TEST_OUT"`}),
+		r.sender(config.Sym{Type: "exec-up", Val: `echo "0 $PTT_DEVICE" >> "$PTT_TEST_OUT"`}),
+	}
+
+	if err := applyEvent(logger, s, event{Type: eventDown, Device: "pedal"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyEvent(logger, s, event{Type: eventUp, Device: "pedal"}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "1 pedal\n0 pedal\n"
+	if string(data) != want {
+		t.Fatalf("output = %q, want %q", data, want)
+	}
+}
+
+func TestExecSender_timeout(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+
+	s := newExecRunner(logger, 100*time.Millisecond).sender(config.Sym{Type: "exec", Val: "sleep 10 & wait"})
+	start := time.Now()
+	if err := s.Down(); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	if took := time.Since(start); took > 5*time.Second {
+		t.Fatalf("command ran for %v despite timeout", took)
+	}
+	if !strings.Contains(buf.String(), "timed out") {
+		t.Fatalf("expected timeout to be logged, got %q", buf.String())
+	}
+}
+
+func TestExecSender_failureLogged(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+
+	s := newExecRunner(logger, time.Second).sender(config.Sym{Type: "exec", Val: "echo oops; exit 3"})
+	if err := s.Down(); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	out := buf.String()
+	if !strings.Contains(out, "exec command failed") || !strings.Contains(out, "oops") {
+		t.Fatalf("expected failure with output to be logged, got %q", out)
+	}
+}
+
+func TestExecSender_queueFull(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	s := newExecRunner(logger, 50*time.Millisecond).sender(config.Sym{Type: "exec", Val: "sleep 10"})
+
+	for range execQueueSize + 2 {
+		if err := s.Down(); err != nil {
+			t.Fatalf("Down with a full queue: %v", err)
+		}
+	}
+	s.Close()
+
+	if !strings.Contains(buf.String(), "too many exec commands waiting, dropping") {
+		t.Fatalf("expected the dropped command to be logged, got %q", buf.String())
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"deedles.dev/ptt-fix/internal/config"
+	"deedles.dev/ptt-fix/internal/xdo"
+)
+
+// processScanInterval is how often the processes are listed for process
+// conditions. Presses use the last list, so that checking them never delays
+// the press.
+const processScanInterval = 2 * time.Second
+
+// windowQuerier answers the window questions that conditions ask. It is
+// implemented by *xdo.Xdo.
+type windowQuerier interface {
+	ActiveWindowMatches(m xdo.WindowMatch) (bool, error)
+	WindowExists(m xdo.WindowMatch) (bool, error)
+}
+
+// gate decides whether a press may be sent, based on the conditions given
+// with when directives. At least one of them must be met.
+type gate struct {
+	conds   []condition
+	windows windowQuerier
+	procs   *processScanner
+}
+
+type condition struct {
+	config.Condition
+	match xdo.WindowMatch
+}
+
+// newGate returns a gate for conds. If any of them are process conditions,
+// the processes in proc are scanned in the background until the gate is
+// closed.
+func newGate(conds []config.Condition, windows windowQuerier, proc fs.FS) (*gate, error) {
+	g := gate{windows: windows}
+	var procs []string
+	for _, c := range conds {
+		cond := condition{Condition: c}
+		switch c.Type {
+		case "focused", "running":
+			m, err := windowMatch(c.Window)
+			if err != nil {
+				return nil, err
+			}
+			cond.match = m
+		case "process":
+			procs = append(procs, c.Process)
+		default:
+			return nil, fmt.Errorf("invalid condition: %q", c.Type)
+		}
+		g.conds = append(g.conds, cond)
+	}
+	if len(procs) > 0 {
+		g.procs = startProcessScanner(proc, procs, processScanInterval)
+	}
+	return &g, nil
+}
+
+// Close stops scanning processes.
+func (g *gate) Close() error {
+	if g.procs != nil {
+		g.procs.Close()
+	}
+	return nil
+}
+
+// Allow reports whether any condition is met. Conditions are checked in
+// order, and checking stops at the first one that is met. An error checking
+// one condition does not stop the others from being checked, but is returned
+// if none of them is met.
+func (g *gate) Allow() (bool, error) {
+	var firstErr error
+	for _, c := range g.conds {
+		ok, err := g.check(c)
+		if ok {
+			return true, nil
+		}
+		if (err != nil) && (firstErr == nil) {
+			firstErr = fmt.Errorf("check %v %v: %w", c.Type, c, err)
+		}
+	}
+	return false, firstErr
+}
+
+func (g *gate) check(c condition) (bool, error) {
+	switch c.Type {
+	case "focused":
+		return g.windows.ActiveWindowMatches(c.match)
+	case "running":
+		return g.windows.WindowExists(c.match)
+	case "process":
+		return g.procs.Running(c.Process)
+	default:
+		panic(fmt.Errorf("invalid condition: %q", c.Type))
+	}
+}
+
+func (c condition) String() string {
+	if c.Type == "process" {
+		return strconv.Quote(c.Process)
+	}
+	return c.match.String()
+}
+
+// processScanner keeps track of which of a set of processes are running by
+// scanning the proc filesystem on a goroutine of its own.
+type processScanner struct {
+	proc  fs.FS
+	names []string
+
+	m       sync.Mutex
+	running map[string]bool
+	err     error
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// startProcessScanner scans proc for names once, so that the result is
+// ready right away, and then again every interval until it is closed.
+func startProcessScanner(proc fs.FS, names []string, interval time.Duration) *processScanner {
+	s := processScanner{
+		proc:  proc,
+		names: names,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	s.scan()
+	go s.work(interval)
+	return &s
+}
+
+func (s *processScanner) work(interval time.Duration) {
+	defer close(s.done)
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-t.C:
+			s.scan()
+		}
+	}
+}
+
+func (s *processScanner) scan() {
+	running, err := runningProcesses(s.proc, s.names)
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.running, s.err = running, err
+}
+
+// Running reports whether a process named name was running as of the last
+// scan. name must be one of the names that the scanner was started with.
+func (s *processScanner) Running(name string) (bool, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.running[name], s.err
+}
+
+// Close stops scanning.
+func (s *processScanner) Close() {
+	close(s.stop)
+	<-s.done
+}
+
+// runningProcesses reports which of names are running, according to the
+// proc filesystem in proc. A process matches a name if its command name
+// (comm, which the kernel truncates to 15 bytes) or the base name of its
+// first argument is the name.
+func runningProcesses(proc fs.FS, names []string) (map[string]bool, error) {
+	entries, err := fs.ReadDir(proc, ".")
+	if err != nil {
+		return nil, fmt.Errorf("list processes: %w", err)
+	}
+
+	running := make(map[string]bool, len(names))
+	for _, entry := range entries {
+		if _, err := strconv.ParseUint(entry.Name(), 10, 0); err != nil {
+			continue
+		}
+
+		// Processes can exit at any time, so read errors are skipped.
+		var comm, arg0 string
+		if data, err := fs.ReadFile(proc, path.Join(entry.Name(), "comm")); err == nil {
+			comm = string(bytes.TrimSuffix(data, []byte("\n")))
+		}
+		if data, err := fs.ReadFile(proc, path.Join(entry.Name(), "cmdline")); err == nil {
+			if first, _, _ := bytes.Cut(data, []byte{0}); len(first) > 0 {
+				arg0 = path.Base(string(first))
+			}
+		}
+
+		for _, name := range names {
+			truncated := name
+			if len(truncated) > 15 {
+				truncated = truncated[:15]
+			}
+			if ((comm != "") && (comm == truncated)) || (arg0 == name) {
+				running[name] = true
+			}
+		}
+	}
+	return running, nil
+}
+
+// gatedSender only sends presses that the gate allows. Releases are always
+// sent, so that nothing stays held if a condition stops being met.
+type gatedSender struct {
+	logger *slog.Logger
+	s      sender
+	gate   *gate
+}
+
+// NoteEvent implements eventNoter by passing ev on to the gated sender.
+func (s gatedSender) NoteEvent(ev event) {
+	if n, ok := s.s.(eventNoter); ok {
+		n.NoteEvent(ev)
+	}
+}
+
+func (s gatedSender) Close() error {
+	return errors.Join(closeSender(s.s), s.gate.Close())
+}
+
+func (s gatedSender) Up() error {
+	return s.s.Up()
+}
+
+// Down sends the press if the gate allows it and otherwise returns
+// errSuppressed.
+func (s gatedSender) Down() error {
+	ok, err := s.gate.Allow()
+	if err != nil {
+		s.logger.Warn("cannot check conditions, not sending", errKey, err)
+		return fmt.Errorf("%w: cannot check conditions", errSuppressed)
+	}
+	if !ok {
+		return fmt.Errorf("%w: conditions not met", errSuppressed)
+	}
+	return s.s.Down()
+}
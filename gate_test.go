@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"deedles.dev/ptt-fix/internal/config"
+	"deedles.dev/ptt-fix/internal/xdo"
+)
+
+type fakeWindows struct {
+	active  string // class of the active window
+	running []string
+	err     error
+}
+
+func (w *fakeWindows) ActiveWindowMatches(m xdo.WindowMatch) (bool, error) {
+	return m.Class == w.active, w.err
+}
+
+func (w *fakeWindows) WindowExists(m xdo.WindowMatch) (bool, error) {
+	for _, class := range w.running {
+		if m.Class == class {
+			return true, nil
+		}
+	}
+	return false, w.err
+}
+
+var testProc = fstest.MapFS{
+	"1/comm":       {Data: []byte("systemd\n")},
+	"1/cmdline":    {Data: []byte("/sbin/init\x00splash\x00")},
+	"42/comm":      {Data: []byte("Discord\n")},
+	"42/cmdline":   {Data: []byte("/opt/discord/Discord\x00--start-minimized\x00")},
+	"77/comm":      {Data: []byte("a-very-long-nam\n")},
+	"77/cmdline":   {Data: []byte{}},
+	"self/comm":    {Data: []byte("mumble\n")},
+	"cpuinfo":      {Data: []byte("not a process")},
+	"sys/kernel/x": {Data: []byte("")},
+}
+
+func TestProcessRunning(t *testing.T) {
+	cases := []struct {
+		name string
+		want bool
+	}{
+		{"systemd", true},
+		{"init", true},
+		{"Discord", true},
+		{"discord", false},
+		{"a-very-long-name-indeed", true}, // comm is truncated
+		{"mumble", false},                 // only numeric entries are processes
+	}
+	var names []string
+	for _, tc := range cases {
+		names = append(names, tc.name)
+	}
+	running, err := runningProcesses(testProc, names)
+	if err != nil {
+		t.Fatalf("runningProcesses: %v", err)
+	}
+	for _, tc := range cases {
+		if running[tc.name] != tc.want {
+			t.Errorf("running[%q] = %v, want %v", tc.name, running[tc.name], tc.want)
+		}
+	}
+}
+
+func TestProcessScanner(t *testing.T) {
+	proc := fstest.MapFS{
+		"1/comm": {Data: []byte("systemd\n")},
+	}
+	// The interval is long enough that only the explicit scans happen.
+	s := startProcessScanner(proc, []string{"Discord"}, time.Hour)
+	defer s.Close()
+
+	if ok, err := s.Running("Discord"); ok || (err != nil) {
+		t.Fatalf("Running(Discord) = %v, %v; want false, nil", ok, err)
+	}
+
+	proc["42/comm"] = &fstest.MapFile{Data: []byte("Discord\n")}
+	if ok, _ := s.Running("Discord"); ok {
+		t.Fatal("Running(Discord) = true before the next scan")
+	}
+	s.scan()
+	if ok, err := s.Running("Discord"); !ok || (err != nil) {
+		t.Fatalf("Running(Discord) = %v, %v after scanning; want true, nil", ok, err)
+	}
+}
+
+func TestGate(t *testing.T) {
+	conds := []config.Condition{
+		{Type: "focused", Window: config.Target{Type: "class", Val: "Mumble"}},
+		{Type: "running", Window: config.Target{Type: "class", Val: "TeamSpeak"}},
+		{Type: "process", Process: "Discord"},
+	}
+
+	cases := []struct {
+		name    string
+		windows fakeWindows
+		proc    fstest.MapFS
+		want    bool
+		err     bool
+	}{
+		{"focused", fakeWindows{active: "Mumble"}, fstest.MapFS{}, true, false},
+		{"running", fakeWindows{active: "Game", running: []string{"TeamSpeak"}}, fstest.MapFS{}, true, false},
+		{"process", fakeWindows{active: "Game"}, testProc, true, false},
+		{"none", fakeWindows{active: "Game"}, fstest.MapFS{}, false, false},
+		{"error but met", fakeWindows{err: errors.New("boom")}, testProc, true, false},
+		{"error", fakeWindows{err: errors.New("boom")}, fstest.MapFS{}, false, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			g, err := newGate(conds, &tc.windows, tc.proc)
+			if err != nil {
+				t.Fatalf("newGate: %v", err)
+			}
+			defer g.Close()
+			ok, err := g.Allow()
+			if (ok != tc.want) || ((err != nil) != tc.err) {
+				t.Errorf("Allow() = %v, %v; want %v, error %v", ok, err, tc.want, tc.err)
+			}
+		})
+	}
+
+	_, err := newGate([]config.Condition{{Type: "sometimes"}}, &fakeWindows{}, fstest.MapFS{})
+	if err == nil {
+		t.Error("newGate with invalid condition: expected error")
+	}
+}
+
+func TestGatedSender_alwaysReleases(t *testing.T) {
+	windows := fakeWindows{active: "Game"}
+	g, err := newGate([]config.Condition{
+		{Type: "focused", Window: config.Target{Type: "class", Val: "Mumble"}},
+	}, &windows, fstest.MapFS{})
+	if err != nil {
+		t.Fatalf("newGate: %v", err)
+	}
+
+	var stub stubSender
+	s := gatedSender{logger: slog.New(slog.NewTextHandler(io.Discard, nil)), s: &stub, gate: g}
+	if err := s.Down(); !errors.Is(err, errSuppressed) {
+		t.Fatalf("Down = %v, want errSuppressed", err)
+	}
+	if err := s.Up(); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	if (stub.downs != 0) || (stub.ups != 1) {
+		t.Errorf("downs, ups = %v, %v; want 0, 1", stub.downs, stub.ups)
+	}
+
+	windows.active = "Mumble"
+	if err := s.Down(); err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+	if stub.downs != 1 {
+		t.Errorf("downs = %v after focusing Mumble, want 1", stub.downs)
+	}
+}
+
+func TestApplyEvent_suppressed(t *testing.T) {
+	g, err := newGate([]config.Condition{
+		{Type: "focused", Window: config.Target{Type: "class", Val: "Mumble"}},
+	}, &fakeWindows{active: "Game"}, fstest.MapFS{})
+	if err != nil {
+		t.Fatalf("newGate: %v", err)
+	}
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	var stub stubSender
+	s := gatedSender{logger: logger, s: &stub, gate: g}
+	if err := applyEvent(logger, s, event{Type: eventDown, Device: "pedal"}); err != nil {
+		t.Fatalf("applyEvent: %v", err)
+	}
+	if out := buf.String(); !strings.Contains(out, "not activated") || strings.Contains(out, "msg=activated") {
+		t.Errorf("log = %q, want the press to be reported as not activated", out)
+	}
+}
@@ -2,44 +2,128 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"os"
+	"slices"
 	"strconv"
+	"time"
 
 	"deedles.dev/ptt-fix/internal/config"
 	"deedles.dev/ptt-fix/internal/xdo"
 )
 
-func handle(ctx context.Context, key config.Sym, ev <-chan event) error {
+func handle(ctx context.Context, c config.Config, ev <-chan event) error {
 	logger := Logger(ctx)
 
-	do, err := xdo.Open()
+	m, err := newMode(c.Mode)
 	if err != nil {
-		return fmt.Errorf("xdo initialization failed: %w", err)
+		return err
 	}
-	defer do.Close()
 
-	sender, err := newSender(do, key)
+	var do *xdo.Xdo
+	if needsDisplay(c) {
+		do, err = openDisplay(ctx, logger, c)
+		if err != nil {
+			return err
+		}
+		defer do.Close()
+	}
+
+	sender, err := newOutput(ctx, logger, do, c)
 	if err != nil {
 		return err
 	}
+	defer closeSender(sender)
+
+	debounce := make(map[string]time.Duration, len(c.Devices))
+	for _, dev := range c.Devices {
+		debounce[dev] = c.DebounceFor(dev)
+	}
+
+	t := trigger{
+		logger:   logger,
+		sender:   sender,
+		debounce: newDebouncer(debounce),
+		chord:    newChord(keycodes(c.Keys)),
+		mode:     m,
+		maxHold:  c.MaxHold,
+		notify:   Notifier(ctx),
+	}
+
+	timer := time.NewTimer(0)
+	timer.Stop()
+	defer timer.Stop()
 
 	for {
+		if deadline, ok := t.Deadline(); ok {
+			timer.Reset(time.Until(deadline))
+		} else {
+			timer.Stop()
+		}
+
 		select {
 		case <-ctx.Done():
 			return context.Cause(ctx)
 
+		case now := <-timer.C:
+			if err := t.Expire(now); err != nil {
+				return err
+			}
+
 		case ev := <-ev:
-			if err := applyEvent(logger, sender, ev); err != nil {
+			if err := t.Handle(ev); err != nil {
 				return err
 			}
 		}
 	}
 }
 
+// needsDisplay reports whether anything in c needs an X connection. Outputs
+// such as exec and pulse work without one.
+func needsDisplay(c config.Config) bool {
+	for _, sym := range c.Syms {
+		switch sym.Type {
+		case "key", "mouse":
+			return true
+		}
+	}
+	for _, cond := range c.When {
+		switch cond.Type {
+		case "focused", "running":
+			return true
+		}
+	}
+	return false
+}
+
+// openDisplay waits for the configured X display to become available and
+// connects to it.
+func openDisplay(ctx context.Context, logger *slog.Logger, c config.Config) (*xdo.Xdo, error) {
+	if ok, _ := xdo.DisplayAvailable(c.Display); !ok {
+		logger.Info("waiting for X display", "display", c.Display)
+	}
+	if err := xdo.WaitDisplay(ctx, c.Display); err != nil {
+		return nil, err
+	}
+
+	do, err := xdo.OpenDisplay(c.Display, c.Xauthority)
+	if err != nil {
+		return nil, fmt.Errorf("xdo initialization failed: %w", err)
+	}
+	do.SetModifierSynthesis(c.SynthesizeModifiers)
+	return do, nil
+}
+
 // applyEvent dispatches a single up/down event through the sender.
 // Injection errors are returned so the process can exit (and be restarted).
 func applyEvent(logger *slog.Logger, s sender, ev event) error {
+	if n, ok := s.(eventNoter); ok {
+		n.NoteEvent(ev)
+	}
+
 	switch ev.Type {
 	case eventUp:
 		if err := s.Up(); err != nil {
@@ -48,7 +132,12 @@ func applyEvent(logger *slog.Logger, s sender, ev event) error {
 		logger.Info("deactivated", "device", ev.Device)
 		return nil
 	case eventDown:
-		if err := s.Down(); err != nil {
+		err := s.Down()
+		if errors.Is(err, errSuppressed) {
+			logger.Info("not activated", "device", ev.Device, "reason", err)
+			return nil
+		}
+		if err != nil {
 			return fmt.Errorf("activate (%s): %w", ev.Device, err)
 		}
 		logger.Info("activated", "device", ev.Device)
@@ -58,11 +147,115 @@ func applyEvent(logger *slog.Logger, s sender, ev event) error {
 	}
 }
 
+// errSuppressed is returned by a sender's Down if it deliberately sent
+// nothing, such as because of a when condition. It is not a failure.
+var errSuppressed = errors.New("press suppressed")
+
 type sender interface {
 	Up() error
 	Down() error
 }
 
+// eventNoter is implemented by senders that want to know which event caused
+// the press or release that follows.
+type eventNoter interface {
+	NoteEvent(ev event)
+}
+
+// closeSender stops anything that s keeps running in the background, if it
+// is an io.Closer.
+func closeSender(s sender) error {
+	if c, ok := s.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// newOutput builds the sender for all of the syms in c, applying its target
+// to key syms and gating the result on its conditions.
+func newOutput(ctx context.Context, logger *slog.Logger, do *xdo.Xdo, c config.Config) (sender, error) {
+	if len(c.Syms) == 0 {
+		return nil, errors.New("no sym configured")
+	}
+
+	target := c.Target != (config.Target{})
+	if target {
+		logger.Warn(
+			"sending to a target window uses synthetic events, which many applications ignore",
+			"target", c.Target.Type,
+			"value", c.Target.Val,
+		)
+	}
+
+	senders := make(multiSender, 0, len(c.Syms))
+	var session *portalSession
+	var runner *execRunner
+	for _, sym := range c.Syms {
+		var s sender
+		var err error
+		switch {
+		case (sym.Type == "exec") || (sym.Type == "exec-down") || (sym.Type == "exec-up"):
+			if runner == nil {
+				runner = newExecRunner(logger, c.ExecTimeout)
+			}
+			s = runner.sender(sym)
+		case sym.Type == "pulse":
+			s, err = newPulseSender(logger, sym.Val)
+		case sym.Type == "obs":
+			s = newOBSSender(logger, c.OBSAddress, c.OBSPassword, sym.Val)
+		case sym.Type == "mumble":
+			s, err = newMumbleSender(logger)
+		case sym.Type == "mqtt":
+			s, err = newMQTTSender(logger, c.MQTTBroker, sym.Val)
+		case sym.Type == "portal":
+			if session == nil {
+				session, err = startPortal(ctx, logger, c.Syms)
+			}
+			if err == nil {
+				s, err = newPortalSender(session, sym.Val)
+			}
+		case target && (sym.Type == "key"):
+			s, err = newTargetSender(logger, do, sym, c.Target)
+		default:
+			s, err = newSender(do, sym)
+		}
+		if err != nil {
+			senders.Close()
+			return nil, err
+		}
+		senders = append(senders, s)
+	}
+	for _, led := range c.LEDs {
+		s, err := newLED(logger, led, c.Devices)
+		if err != nil {
+			senders.Close()
+			return nil, err
+		}
+		senders = append(senders, feedbackSender{logger: logger, s: s})
+	}
+	if c.Rumble > 0 {
+		senders = append(senders, feedbackSender{logger: logger, s: newRumbleSender(logger, c.Rumble)})
+	}
+	if c.Click {
+		senders = append(senders, feedbackSender{logger: logger, s: newClickSender(logger)})
+	}
+
+	var s sender = senders
+	if len(senders) == 1 {
+		s = senders[0]
+	}
+
+	if len(c.When) > 0 {
+		g, err := newGate(c.When, do, os.DirFS("/proc"))
+		if err != nil {
+			closeSender(s)
+			return nil, err
+		}
+		s = gatedSender{logger: logger, s: s, gate: g}
+	}
+	return s, nil
+}
+
 func newSender(do *xdo.Xdo, sym config.Sym) (sender, error) {
 	switch sym.Type {
 	case "key":
@@ -82,6 +275,9 @@ func newSender(do *xdo.Xdo, sym config.Sym) (sender, error) {
 		}
 		return mouseSender{do: do, button: int(v)}, nil
 
+	case "wayland":
+		return newWaylandSender(sym.Val)
+
 	default:
 		return nil, fmt.Errorf("invalid sym type: %q", sym.Type)
 	}
@@ -99,3 +295,105 @@ func (s mouseSender) Up() error {
 func (s mouseSender) Down() error {
 	return s.do.ButtonDown(s.button)
 }
+
+// multiSender fans presses out to several senders. Down presses them in
+// order and Up releases them in reverse, so that, for example, a modifier
+// given first wraps the outputs after it.
+type multiSender []sender
+
+// Down presses each sender in turn. If one fails, the ones already pressed
+// are released again, in reverse, and the error is returned.
+func (s multiSender) Down() error {
+	for i, v := range s {
+		if err := v.Down(); err != nil {
+			// Best-effort; preserve the original press error.
+			s[:i].Up()
+			return err
+		}
+	}
+	return nil
+}
+
+// Up releases every sender in reverse order, even if some of them fail, and
+// returns all of the errors.
+func (s multiSender) Up() error {
+	var errs []error
+	for _, v := range slices.Backward(s) {
+		errs = append(errs, v.Up())
+	}
+	return errors.Join(errs...)
+}
+
+// NoteEvent implements eventNoter by passing ev on to every sender that
+// wants it.
+func (s multiSender) NoteEvent(ev event) {
+	for _, v := range s {
+		if n, ok := v.(eventNoter); ok {
+			n.NoteEvent(ev)
+		}
+	}
+}
+
+// Close closes every sender that needs it and returns all of the errors.
+func (s multiSender) Close() error {
+	var errs []error
+	for _, v := range s {
+		errs = append(errs, closeSender(v))
+	}
+	return errors.Join(errs...)
+}
+
+func newTargetSender(logger *slog.Logger, do *xdo.Xdo, sym config.Sym, target config.Target) (sender, error) {
+	if sym.Type != "key" {
+		return nil, fmt.Errorf("target only supports key syms, not %q", sym.Type)
+	}
+
+	m, err := windowMatch(target)
+	if err != nil {
+		return nil, err
+	}
+	b, err := do.BindWindowKeys(sym.Val, m)
+	if err != nil {
+		return nil, fmt.Errorf("resolve keysym %q: %w", sym.Val, err)
+	}
+	return targetSender{logger: logger, b: b}, nil
+}
+
+func windowMatch(target config.Target) (xdo.WindowMatch, error) {
+	var m xdo.WindowMatch
+	switch target.Type {
+	case "class":
+		m.Class = target.Val
+	case "title":
+		m.Title = target.Val
+	case "pid":
+		pid, err := strconv.ParseUint(target.Val, 10, 32)
+		if err != nil {
+			return m, fmt.Errorf("invalid target pid: %w", err)
+		}
+		m.PID = uint32(pid)
+	default:
+		return m, fmt.Errorf("invalid target type: %q", target.Type)
+	}
+	return m, nil
+}
+
+// targetSender sends to a target window. A missing window is not fatal, as
+// the application may simply not be running yet.
+type targetSender struct {
+	logger *slog.Logger
+	b      *xdo.WindowBinding
+}
+
+func (s targetSender) Up() error {
+	return s.b.Up()
+}
+
+func (s targetSender) Down() error {
+	err := s.b.Down()
+	if errors.Is(err, xdo.ErrWindowNotFound) {
+		s.logger.Warn("target window not found, not sending", errKey, err)
+		return nil
+	}
+	return err
+}
@@ -2,13 +2,18 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"io"
 	"log/slog"
+	"slices"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"deedles.dev/ptt-fix/internal/config"
+	"deedles.dev/ptt-fix/internal/keysym"
 	"deedles.dev/ptt-fix/internal/xdo"
 )
 
@@ -47,21 +52,25 @@ func TestConfigSymsResolveForSenders(t *testing.T) {
 			if err != nil {
 				t.Fatalf("Parse: %v", err)
 			}
-			if c.Sym.Type != tc.typ || c.Sym.Val != tc.val {
-				t.Fatalf("Sym = %+v, want %s/%s", c.Sym, tc.typ, tc.val)
+			if len(c.Syms) != 1 {
+				t.Fatalf("Syms = %+v, want one", c.Syms)
 			}
-			switch c.Sym.Type {
+			sym := c.Syms[0]
+			if sym.Type != tc.typ || sym.Val != tc.val {
+				t.Fatalf("Sym = %+v, want %s/%s", sym, tc.typ, tc.val)
+			}
+			switch sym.Type {
 			case "key":
-				if _, ok := xdo.KeysymByName(c.Sym.Val); !ok {
-					t.Fatalf("keysym %q not in shipped table", c.Sym.Val)
+				if _, ok := keysym.Lookup(sym.Val); !ok {
+					t.Fatalf("keysym %q not in shipped table", sym.Val)
 				}
 			case "mouse":
-				button, err := strconv.ParseInt(c.Sym.Val, 0, 0)
+				button, err := strconv.ParseInt(sym.Val, 0, 0)
 				if err != nil {
-					t.Fatalf("parse button %q: %v", c.Sym.Val, err)
+					t.Fatalf("parse button %q: %v", sym.Val, err)
 				}
 				if err := xdo.ValidButton(int(button)); err != nil {
-					t.Fatalf("mouse validation for %q: %v", c.Sym.Val, err)
+					t.Fatalf("mouse validation for %q: %v", sym.Val, err)
 				}
 			}
 		})
@@ -90,6 +99,14 @@ func TestNewSender_invalidMouseButton(t *testing.T) {
 	}
 }
 
+func TestNewTargetSender_keyOnly(t *testing.T) {
+	target := config.Target{Type: "class", Val: "Mumble"}
+	_, err := newTargetSender(slog.Default(), nil, config.Sym{Type: "mouse", Val: "2"}, target)
+	if err == nil {
+		t.Fatal("expected error for mouse sym with target")
+	}
+}
+
 type stubSender struct {
 	upErr, downErr error
 	ups, downs     int
@@ -105,6 +122,102 @@ func (s *stubSender) Down() error {
 	return s.downErr
 }
 
+// orderSender records its presses in a log shared with other senders.
+type orderSender struct {
+	name    string
+	log     *[]string
+	downErr error
+}
+
+func (s orderSender) Up() error {
+	*s.log = append(*s.log, s.name+" up")
+	return nil
+}
+
+func (s orderSender) Down() error {
+	if s.downErr != nil {
+		return s.downErr
+	}
+	*s.log = append(*s.log, s.name+" down")
+	return nil
+}
+
+func TestMultiSender(t *testing.T) {
+	var log []string
+	s := multiSender{
+		orderSender{name: "a", log: &log},
+		orderSender{name: "b", log: &log},
+		orderSender{name: "c", log: &log},
+	}
+	if err := s.Down(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Up(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a down", "b down", "c down", "c up", "b up", "a up"}
+	if !slices.Equal(log, want) {
+		t.Fatalf("log = %q, want %q", log, want)
+	}
+
+	log = nil
+	s[2] = orderSender{name: "c", log: &log, downErr: errors.New("c failed")}
+	if err := s.Down(); (err == nil) || (err.Error() != "c failed") {
+		t.Fatalf("Down error = %v, want c failed", err)
+	}
+	want = []string{"a down", "b down", "b up", "a up"}
+	if !slices.Equal(log, want) {
+		t.Fatalf("log after rollback = %q, want %q", log, want)
+	}
+}
+
+func TestMultiSender_upContinues(t *testing.T) {
+	a := &stubSender{upErr: errors.New("a failed")}
+	b := &stubSender{upErr: errors.New("b failed")}
+	err := multiSender{a, b}.Up()
+	if (a.ups != 1) || (b.ups != 1) {
+		t.Fatalf("ups = %d, %d, want 1, 1", a.ups, b.ups)
+	}
+	if (err == nil) || !strings.Contains(err.Error(), "a failed") || !strings.Contains(err.Error(), "b failed") {
+		t.Fatalf("Up error = %v, want both failures", err)
+	}
+}
+
+func TestNewOutput(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	if _, err := newOutput(context.Background(), logger, nil, config.Config{}); err == nil {
+		t.Fatal("expected error without syms")
+	}
+
+	s, err := newOutput(context.Background(), logger, nil, config.Config{Syms: []config.Sym{{Type: "mouse", Val: "2"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.(mouseSender); !ok {
+		t.Fatalf("single sym gave %T, want mouseSender", s)
+	}
+
+	s, err = newOutput(context.Background(), logger, nil, config.Config{Syms: []config.Sym{
+		{Type: "mouse", Val: "2"},
+		{Type: "mouse", Val: "9"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := s.(multiSender); !ok || (len(m) != 2) {
+		t.Fatalf("two syms gave %#v, want multiSender of 2", s)
+	}
+
+	_, err = newOutput(context.Background(), logger, nil, config.Config{Syms: []config.Sym{
+		{Type: "mouse", Val: "2"},
+		{Type: "mouse", Val: "0"},
+	}})
+	if err == nil {
+		t.Fatal("expected error for invalid second sym")
+	}
+}
+
 func TestApplyEvent_propagatesInjectionErrors(t *testing.T) {
 	var buf bytes.Buffer
 	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
@@ -159,3 +272,289 @@ func TestApplyEvent_invalidType(t *testing.T) {
 		t.Fatal("expected invalid event error")
 	}
 }
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Advance(d time.Duration) time.Time {
+	c.now = c.now.Add(d)
+	return c.now
+}
+
+func newTestTrigger(logger *slog.Logger, s sender, m mode) *trigger {
+	return &trigger{
+		logger: logger,
+		sender: s,
+		chord:  newChord([]uint16{0}),
+		mode:   m,
+	}
+}
+
+func TestTrigger_hybridMode(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	m, err := newMode(config.Mode{Type: "hybrid", Threshold: 250 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("newMode: %v", err)
+	}
+
+	type step struct {
+		after      time.Duration
+		typ        eventType
+		downs, ups int
+	}
+	steps := []step{
+		// Long hold: plain push-to-talk.
+		{0, eventDown, 1, 0},
+		{time.Second, eventUp, 1, 1},
+		// Quick tap: latches on.
+		{time.Second, eventDown, 2, 1},
+		{100 * time.Millisecond, eventUp, 2, 1},
+		// Next tap releases the latch.
+		{time.Second, eventDown, 2, 1},
+		{100 * time.Millisecond, eventUp, 2, 2},
+		// Latch again, then end it with a long hold.
+		{time.Second, eventDown, 3, 2},
+		{249 * time.Millisecond, eventUp, 3, 2},
+		{time.Second, eventDown, 3, 2},
+		{time.Second, eventUp, 3, 3},
+		// Exactly the threshold counts as a hold.
+		{time.Second, eventDown, 4, 3},
+		{250 * time.Millisecond, eventUp, 4, 4},
+	}
+
+	var clock fakeClock
+	s := &stubSender{}
+	tr := newTestTrigger(logger, s, m)
+	for i, st := range steps {
+		ev := event{Type: st.typ, Device: "kbd", Time: clock.Advance(st.after)}
+		if err := tr.Handle(ev); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if s.downs != st.downs || s.ups != st.ups {
+			t.Fatalf("step %d: downs=%d ups=%d, want %d/%d", i, s.downs, s.ups, st.downs, st.ups)
+		}
+	}
+}
+
+func TestTrigger_doubleTapMode(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	m, err := newMode(config.Mode{Type: "doubletap", Threshold: 400 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("newMode: %v", err)
+	}
+
+	type step struct {
+		after      time.Duration
+		typ        eventType
+		downs, ups int
+	}
+	steps := []step{
+		// Single hold: plain push-to-talk.
+		{0, eventDown, 1, 0},
+		{time.Second, eventUp, 1, 1},
+		// Second press too late to latch.
+		{time.Second, eventDown, 2, 1},
+		{100 * time.Millisecond, eventUp, 2, 2},
+		// Double tap latches on; its release is ignored.
+		{time.Second, eventDown, 3, 2},
+		{100 * time.Millisecond, eventUp, 3, 3},
+		{100 * time.Millisecond, eventDown, 4, 3},
+		{100 * time.Millisecond, eventUp, 4, 3},
+		// Any later press turns it off, even a long time later.
+		{time.Minute, eventDown, 4, 4},
+		{100 * time.Millisecond, eventUp, 4, 4},
+		// The unlatching press does not start a new double tap.
+		{100 * time.Millisecond, eventDown, 5, 4},
+		{100 * time.Millisecond, eventUp, 5, 5},
+	}
+
+	var clock fakeClock
+	s := &stubSender{}
+	tr := newTestTrigger(logger, s, m)
+	for i, st := range steps {
+		ev := event{Type: st.typ, Device: "pedal", Time: clock.Advance(st.after)}
+		if err := tr.Handle(ev); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if s.downs != st.downs || s.ups != st.ups {
+			t.Fatalf("step %d: downs=%d ups=%d, want %d/%d", i, s.downs, s.ups, st.downs, st.ups)
+		}
+	}
+}
+
+func TestNewMode(t *testing.T) {
+	for _, typ := range []string{"", "hold", "hybrid", "doubletap"} {
+		if _, err := newMode(config.Mode{Type: typ}); err != nil {
+			t.Errorf("newMode(%q): %v", typ, err)
+		}
+	}
+	if _, err := newMode(config.Mode{Type: "toggle"}); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}
+
+func TestTrigger_maxHold(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+
+	var clock fakeClock
+	s := &stubSender{}
+	tr := &trigger{
+		logger:  logger,
+		sender:  s,
+		chord:   newChord([]uint16{29, 0x113}),
+		mode:    holdMode{},
+		maxHold: time.Minute,
+	}
+
+	send := func(typ eventType, dev string, code uint16) {
+		t.Helper()
+		err := tr.Handle(event{Type: typ, Device: dev, Code: code, Time: clock.Advance(time.Second)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(downs, ups int) {
+		t.Helper()
+		if s.downs != downs || s.ups != ups {
+			t.Fatalf("downs=%d ups=%d, want %d/%d", s.downs, s.ups, downs, ups)
+		}
+	}
+
+	send(eventDown, "kbd", 29)
+	send(eventDown, "pedal", 0x113)
+	check(1, 0)
+	deadline, ok := tr.Deadline()
+	if !ok || !deadline.Equal(clock.now.Add(time.Minute)) {
+		t.Fatalf("Deadline() = %v, %v", deadline, ok)
+	}
+
+	// Not expired yet.
+	if err := tr.Expire(clock.Advance(30 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	check(1, 0)
+
+	if err := tr.Expire(clock.Advance(30 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	check(1, 1)
+	if _, ok := tr.Deadline(); ok {
+		t.Fatal("deadline should be cleared after forced release")
+	}
+	if !strings.Contains(buf.String(), "max hold exceeded") || !strings.Contains(buf.String(), "device=pedal") {
+		t.Fatalf("expected warning naming the device, got %q", buf.String())
+	}
+
+	// Both devices were holding, so both are ignored, including the
+	// keyboard pressing again and bounces from the pedal.
+	send(eventDown, "pedal", 0x113)
+	check(1, 1)
+
+	// Real releases clear them without a duplicate Up.
+	send(eventUp, "kbd", 29)
+	send(eventUp, "pedal", 0x113)
+	check(1, 1)
+
+	// The pedal alone is not the whole chord anymore.
+	send(eventDown, "pedal", 0x113)
+	check(1, 1)
+	send(eventDown, "kbd", 29)
+	check(2, 1)
+	send(eventUp, "pedal", 0x113)
+	check(2, 2)
+}
+
+func TestTrigger_maxHoldResetsLatch(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	m, err := newMode(config.Mode{Type: "hybrid", Threshold: 250 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var clock fakeClock
+	s := &stubSender{}
+	tr := newTestTrigger(logger, s, m)
+	tr.maxHold = time.Hour
+
+	// Tap to latch, then forget about it.
+	tr.Handle(event{Type: eventDown, Device: "kbd", Time: clock.Advance(0)})
+	tr.Handle(event{Type: eventUp, Device: "kbd", Time: clock.Advance(100 * time.Millisecond)})
+	if err := tr.Expire(clock.Advance(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if s.downs != 1 || s.ups != 1 {
+		t.Fatalf("downs=%d ups=%d, want 1/1", s.downs, s.ups)
+	}
+
+	// The latch is gone, so the next press is a fresh one.
+	tr.Handle(event{Type: eventDown, Device: "kbd", Time: clock.Advance(time.Second)})
+	if s.downs != 2 {
+		t.Fatalf("downs=%d, want 2", s.downs)
+	}
+}
+
+func TestTrigger_debounce(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s := &stubSender{}
+	tr := newTestTrigger(logger, s, holdMode{})
+	tr.debounce = newDebouncer(map[string]time.Duration{"pedal": 20 * time.Millisecond})
+
+	var clock fakeClock
+	for _, typ := range []eventType{eventDown, eventUp, eventDown, eventUp} {
+		if err := tr.Handle(event{Type: typ, Device: "pedal", Time: clock.Advance(2 * time.Millisecond)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s.downs != 1 || s.ups != 0 {
+		t.Fatalf("downs=%d ups=%d, want 1/0 while bouncing", s.downs, s.ups)
+	}
+
+	deadline, ok := tr.Deadline()
+	if !ok {
+		t.Fatal("expected a deadline for the pending release")
+	}
+	if err := tr.Expire(deadline); err != nil {
+		t.Fatal(err)
+	}
+	if s.downs != 1 || s.ups != 1 {
+		t.Fatalf("downs=%d ups=%d, want 1/1 after flush", s.downs, s.ups)
+	}
+}
+
+func TestNeedsDisplay(t *testing.T) {
+	tests := []struct {
+		name string
+		c    config.Config
+		want bool
+	}{
+		{"key", config.Config{Syms: []config.Sym{{Type: "key", Val: "Alt_L"}}}, true},
+		{"mouse", config.Config{Syms: []config.Sym{{Type: "mouse", Val: "2"}}}, true},
+		{"pulse", config.Config{Syms: []config.Sym{{Type: "pulse", Val: "@DEFAULT_SOURCE@"}}}, false},
+		{"mumble", config.Config{Syms: []config.Sym{{Type: "mumble"}}}, false},
+		{"portal", config.Config{Syms: []config.Sym{{Type: "portal", Val: "key 56"}}}, false},
+		{"exec and key", config.Config{Syms: []config.Sym{{Type: "exec", Val: "true"}, {Type: "key", Val: "a"}}}, true},
+		{
+			"exec when focused",
+			config.Config{
+				Syms: []config.Sym{{Type: "exec", Val: "true"}},
+				When: []config.Condition{{Type: "focused", Window: config.Target{Type: "class", Val: "Mumble"}}},
+			},
+			true,
+		},
+		{
+			"exec when process",
+			config.Config{
+				Syms: []config.Sym{{Type: "exec", Val: "true"}},
+				When: []config.Condition{{Type: "process", Process: "mumble"}},
+			},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		if got := needsDisplay(tt.c); got != tt.want {
+			t.Errorf("%v: needsDisplay = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
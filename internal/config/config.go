@@ -6,21 +6,77 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+
+	"deedles.dev/ptt-fix/internal/evdev"
+	"deedles.dev/ptt-fix/internal/keysym"
+	"deedles.dev/ptt-fix/internal/mqtt"
 )
 
 //go:embed default
 var defaultFile string
 
 type Config struct {
-	Key     uint
-	Sym     Sym
-	Retry   time.Duration
-	Devices []string
+	// Keys holds the evdev codes that must all be held at once to
+	// trigger. A single code behaves like a plain key.
+	Keys []uint
+	// Syms holds the outputs to press while triggered, in the order
+	// they were given.
+	Syms []Sym
+	// LEDs holds the LEDs to light while triggered.
+	LEDs []LED
+	// Notify enables desktop notifications about devices coming and
+	// going and about failures.
+	Notify bool
+	// Click enables a short sound when transmission starts and stops.
+	Click bool
+	// Rumble is how long to rumble the device that triggered when
+	// transmission starts and stops. Zero disables it.
+	Rumble time.Duration
+	// SynthesizeModifiers enables pressing the modifiers needed for a
+	// sym that is only reachable with Shift, AltGr, or similar.
+	SynthesizeModifiers bool
+	Mode                Mode
+	MaxHold             time.Duration
+	Retry               time.Duration
+	Devices             []string
+	// Target, if set, selects a window to send the sym to directly
+	// instead of injecting it into whatever has focus.
+	Target Target
+	// When holds conditions of which at least one must be met for the
+	// sym to be pressed. Releases are always sent.
+	When []Condition
+	// Display and Xauthority select the X display to connect to and
+	// the file to read its cookie from. Empty means the default.
+	Display    string
+	Xauthority string
+	// ExecTimeout limits how long each exec sym's command may run. Zero
+	// means the default.
+	ExecTimeout time.Duration
+	// OBSAddress and OBSPassword select the OBS WebSocket server that obs
+	// syms connect to. Empty means the default.
+	OBSAddress  string
+	OBSPassword string
+	// MQTTBroker is the broker that mqtt syms publish to. Empty means the
+	// default.
+	MQTTBroker string
+
+	// Debounce holds the debounce durations in the order they were
+	// given. See DebounceFor.
+	Debounce []Debounce
+
+	// modifiersSet, notifySet and clickSet record whether their
+	// directives were given, as their zero values are also valid
+	// settings.
+	modifiersSet bool
+	notifySet    bool
+	clickSet     bool
 }
 
 func DefaultFile() string {
@@ -59,10 +115,42 @@ func Parse(r io.Reader) (c Config, err error) {
 			err = c.key(rem)
 		case "sym":
 			err = c.sym(rem)
+		case "led":
+			err = c.led(rem)
+		case "rumble":
+			err = c.rumble(rem)
+		case "notify":
+			err = onOff(&c.Notify, &c.notifySet, "notify", rem)
+		case "click":
+			err = onOff(&c.Click, &c.clickSet, "click", rem)
+		case "modifiers":
+			err = c.modifiers(rem)
+		case "mode":
+			err = c.mode(rem)
+		case "max-hold":
+			err = c.maxHold(rem)
+		case "exec-timeout":
+			err = c.execTimeout(rem)
+		case "obs-address":
+			err = c.obsAddress(rem)
+		case "obs-password":
+			err = c.obsPassword(rem)
+		case "mqtt-broker":
+			err = c.mqttBroker(rem)
 		case "retry":
 			err = c.retry(rem)
 		case "device":
 			err = c.device(rem)
+		case "debounce":
+			err = c.debounce(rem)
+		case "target":
+			err = c.target(rem)
+		case "when":
+			err = c.when(rem)
+		case "display":
+			err = c.display(rem)
+		case "xauthority":
+			err = c.xauthority(rem)
 		default:
 			return c, fmt.Errorf("unknown directive %q on line %v", directive, line)
 		}
@@ -78,29 +166,242 @@ func Parse(r io.Reader) (c Config, err error) {
 }
 
 func (c *Config) key(str string) error {
-	if c.Key != 0 {
+	if c.Keys != nil {
 		return errors.New("attempted to set key twice")
 	}
 
-	v, err := strconv.ParseUint(str, 0, 0)
-	if err != nil {
-		return fmt.Errorf("parse key: %w", err)
+	var keys []uint
+	for part := range strings.SplitSeq(str, "+") {
+		v, err := strconv.ParseUint(strings.TrimSpace(part), 0, 16)
+		if err != nil {
+			return fmt.Errorf("parse key: %w", err)
+		}
+		if slices.Contains(keys, uint(v)) {
+			return fmt.Errorf("key %v listed twice", v)
+		}
+		keys = append(keys, uint(v))
 	}
-	c.Key = uint(v)
+	c.Keys = keys
 	return nil
 }
 
 func (c *Config) sym(str string) error {
-	if c.Sym != (Sym{}) {
-		return errors.New("attempted to set sym twice")
+	t, v, ok := strings.Cut(str, " ")
+	if !ok {
+		switch t {
+		case "exec", "exec-down", "exec-up":
+			return fmt.Errorf("missing %v command", t)
+		case "pulse":
+			return errors.New("missing pulse source")
+		case "portal":
+			return errors.New("missing portal key or button")
+		case "wayland":
+			return errors.New("missing wayland keys")
+		case "obs":
+			return errors.New("missing obs input")
+		case "mqtt":
+			return errors.New("missing mqtt topic")
+		}
+		if t != "mumble" {
+			v = t
+			t = "key"
+		}
+	}
+	if (t == "mumble") && (v != "") {
+		return fmt.Errorf("unexpected mumble argument %q", v)
+	}
+	if (t == "mqtt") && strings.ContainsAny(v, "+#") {
+		return fmt.Errorf("mqtt topic %q must not contain wildcards", v)
+	}
+	if (t == "key") || (t == "wayland") {
+		if err := keysym.Valid(v); err != nil {
+			return fmt.Errorf("invalid sym: %w", err)
+		}
+	}
+	sym := Sym{Type: t, Val: v}
+	if slices.Contains(c.Syms, sym) {
+		return fmt.Errorf("sym %v listed twice", str)
+	}
+	c.Syms = append(c.Syms, sym)
+	return nil
+}
+
+func (c *Config) led(str string) error {
+	t, rem, _ := strings.Cut(str, " ")
+	name, pattern, _ := strings.Cut(strings.TrimSpace(rem), " ")
+	pattern = strings.TrimSpace(pattern)
+	if name == "" {
+		return errors.New("missing led name")
 	}
 
-	t, v, ok := strings.Cut(str, " ")
+	switch t {
+	case "evdev":
+		if _, ok := evdev.LEDByName(name); !ok {
+			return fmt.Errorf("unknown led %q", name)
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("parse led pattern: %w", err)
+		}
+	case "sysfs":
+		if pattern != "" {
+			return fmt.Errorf("unexpected pattern %q for sysfs led", pattern)
+		}
+		if strings.Contains(name, "/") {
+			return fmt.Errorf("invalid sysfs led name %q", name)
+		}
+	default:
+		return fmt.Errorf("invalid led type: %q", t)
+	}
+
+	led := LED{Type: t, Name: name, Pattern: pattern}
+	if slices.Contains(c.LEDs, led) {
+		return fmt.Errorf("led %v listed twice", str)
+	}
+	c.LEDs = append(c.LEDs, led)
+	return nil
+}
+
+func (c *Config) modifiers(str string) error {
+	if c.modifiersSet {
+		return errors.New("attempted to set modifiers twice")
+	}
+	c.modifiersSet = true
+
+	switch str {
+	case "base":
+		c.SynthesizeModifiers = false
+	case "synthesize":
+		c.SynthesizeModifiers = true
+	default:
+		return fmt.Errorf("invalid modifiers: %q", str)
+	}
+	return nil
+}
+
+func (c *Config) mode(str string) error {
+	if c.Mode != (Mode{}) {
+		return errors.New("attempted to set mode twice")
+	}
+
+	t, d, ok := strings.Cut(str, " ")
+	switch t {
+	case "":
+		return errors.New("missing mode type")
+	case "hold", "hybrid", "doubletap":
+	default:
+		return fmt.Errorf("unknown mode %q (want hold, hybrid or doubletap)", t)
+	}
+	c.Mode = Mode{Type: t}
 	if !ok {
-		v = t
-		t = "key"
+		return nil
+	}
+
+	v, err := time.ParseDuration(strings.TrimSpace(d))
+	if err != nil {
+		return fmt.Errorf("parse mode threshold: %w", err)
+	}
+	c.Mode.Threshold = v
+	return nil
+}
+
+func (c *Config) maxHold(str string) error {
+	if c.MaxHold != 0 {
+		return errors.New("attempted to set max-hold twice")
+	}
+
+	d, err := time.ParseDuration(str)
+	if err != nil {
+		return fmt.Errorf("parse max-hold: %w", err)
+	}
+	c.MaxHold = d
+	return nil
+}
+
+// onOff sets v from an "on" or "off" argument of directive. set records
+// whether the directive was already given.
+func onOff(v, set *bool, directive, str string) error {
+	if *set {
+		return fmt.Errorf("attempted to set %v twice", directive)
+	}
+	*set = true
+
+	switch str {
+	case "on":
+		*v = true
+	case "off":
+		*v = false
+	default:
+		return fmt.Errorf("invalid %v: %q (want on or off)", directive, str)
+	}
+	return nil
+}
+
+func (c *Config) rumble(str string) error {
+	if c.Rumble != 0 {
+		return errors.New("attempted to set rumble twice")
+	}
+
+	d, err := time.ParseDuration(str)
+	if err != nil {
+		return fmt.Errorf("parse rumble: %w", err)
+	}
+	// The kernel takes the length in milliseconds as a uint16.
+	if (d < time.Millisecond) || (d > math.MaxUint16*time.Millisecond) {
+		return fmt.Errorf("rumble must be between 1ms and %v, not %v", math.MaxUint16*time.Millisecond, d)
+	}
+	c.Rumble = d
+	return nil
+}
+
+func (c *Config) execTimeout(str string) error {
+	if c.ExecTimeout != 0 {
+		return errors.New("attempted to set exec-timeout twice")
+	}
+
+	d, err := time.ParseDuration(str)
+	if err != nil {
+		return fmt.Errorf("parse exec-timeout: %w", err)
+	}
+	if d <= 0 {
+		return fmt.Errorf("exec-timeout must be positive, not %v", d)
 	}
-	c.Sym = Sym{Type: t, Val: v}
+	c.ExecTimeout = d
+	return nil
+}
+
+func (c *Config) obsAddress(str string) error {
+	if c.OBSAddress != "" {
+		return errors.New("attempted to set obs-address twice")
+	}
+	if str == "" {
+		return errors.New("missing obs address")
+	}
+	c.OBSAddress = str
+	return nil
+}
+
+func (c *Config) obsPassword(str string) error {
+	if c.OBSPassword != "" {
+		return errors.New("attempted to set obs-password twice")
+	}
+	if str == "" {
+		return errors.New("missing obs password")
+	}
+	c.OBSPassword = str
+	return nil
+}
+
+func (c *Config) mqttBroker(str string) error {
+	if c.MQTTBroker != "" {
+		return errors.New("attempted to set mqtt-broker twice")
+	}
+	if str == "" {
+		return errors.New("missing mqtt broker")
+	}
+	if _, _, _, err := mqtt.ParseBroker(str); err != nil {
+		return fmt.Errorf("parse mqtt-broker: %w", err)
+	}
+	c.MQTTBroker = str
 	return nil
 }
 
@@ -126,7 +427,153 @@ func (c *Config) device(str string) error {
 	return nil
 }
 
+func (c *Config) target(str string) error {
+	if c.Target != (Target{}) {
+		return errors.New("attempted to set target twice")
+	}
+
+	t, err := parseTarget(str)
+	if err != nil {
+		return err
+	}
+	c.Target = t
+	return nil
+}
+
+func parseTarget(str string) (Target, error) {
+	t, v, _ := strings.Cut(str, " ")
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return Target{}, errors.New("missing target value")
+	}
+	switch t {
+	case "class", "title":
+	case "pid":
+		pid, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return Target{}, fmt.Errorf("parse target pid: %w", err)
+		}
+		if pid == 0 {
+			return Target{}, errors.New("target pid must not be 0")
+		}
+	default:
+		return Target{}, fmt.Errorf("invalid target type: %q", t)
+	}
+	return Target{Type: t, Val: v}, nil
+}
+
+func (c *Config) when(str string) error {
+	t, rem, _ := strings.Cut(str, " ")
+	rem = strings.TrimSpace(rem)
+	switch t {
+	case "focused", "running":
+		w, err := parseTarget(rem)
+		if err != nil {
+			return err
+		}
+		c.When = append(c.When, Condition{Type: t, Window: w})
+	case "process":
+		if rem == "" {
+			return errors.New("missing process name")
+		}
+		c.When = append(c.When, Condition{Type: t, Process: rem})
+	default:
+		return fmt.Errorf("invalid condition: %q", t)
+	}
+	return nil
+}
+
+func (c *Config) display(str string) error {
+	if c.Display != "" {
+		return errors.New("attempted to set display twice")
+	}
+	if str == "" {
+		return errors.New("missing display name")
+	}
+	c.Display = str
+	return nil
+}
+
+func (c *Config) xauthority(str string) error {
+	if c.Xauthority != "" {
+		return errors.New("attempted to set xauthority twice")
+	}
+	if str == "" {
+		return errors.New("missing xauthority path")
+	}
+	c.Xauthority = str
+	return nil
+}
+
+func (c *Config) debounce(str string) error {
+	d, pattern, _ := strings.Cut(str, " ")
+	v, err := time.ParseDuration(d)
+	if err != nil {
+		return fmt.Errorf("parse debounce: %w", err)
+	}
+
+	pattern = strings.TrimSpace(pattern)
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return fmt.Errorf("parse debounce pattern: %w", err)
+	}
+
+	c.Debounce = append(c.Debounce, Debounce{Duration: v, Pattern: pattern})
+	return nil
+}
+
+// DebounceFor returns the debounce duration for the device at path.
+// The last debounce directive whose pattern matches the path wins. A
+// directive without a pattern matches every device.
+func (c Config) DebounceFor(path string) time.Duration {
+	for _, d := range slices.Backward(c.Debounce) {
+		if d.Pattern == "" {
+			return d.Duration
+		}
+		if ok, _ := filepath.Match(d.Pattern, path); ok {
+			return d.Duration
+		}
+	}
+	return 0
+}
+
+type Debounce struct {
+	Duration time.Duration
+	Pattern  string
+}
+
 type Sym struct {
 	Type string
 	Val  string
 }
+
+// LED is an LED to light while triggered. Type is "evdev", with Name
+// being an LED such as "scrolllock" on the devices that match Pattern,
+// or the configured devices if it is empty, or "sysfs", with Name being
+// a directory in /sys/class/leds.
+type LED struct {
+	Type    string
+	Name    string
+	Pattern string
+}
+
+// Target selects a window by one of its properties. Type is "class",
+// "title", or "pid".
+type Target struct {
+	Type string
+	Val  string
+}
+
+// Condition is a requirement for sending the sym. Type is "focused"
+// or "running", with Window selecting the window that must be focused
+// or exist, or "process", with the name of a process that must be
+// running.
+type Condition struct {
+	Type    string
+	Window  Target
+	Process string
+}
+
+type Mode struct {
+	Type      string
+	Threshold time.Duration
+}
@@ -0,0 +1,480 @@
+// Package dbus is a small D-Bus client. It implements only what ptt-fix
+// needs: method calls, signals and answering method calls, over Unix
+// sockets with EXTERNAL authentication and without file descriptor passing.
+package dbus
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	busName      = "org.freedesktop.DBus"
+	busPath      = "/org/freedesktop/DBus"
+	busInterface = "org.freedesktop.DBus"
+)
+
+// ErrClosed is returned by operations on a connection that has been closed
+// or lost.
+var ErrClosed = errors.New("dbus connection closed")
+
+// Error is an error reply to a method call.
+type Error struct {
+	Name    string
+	Message string
+}
+
+func (err *Error) Error() string {
+	if err.Message == "" {
+		return err.Name
+	}
+	return fmt.Sprintf("%v: %v", err.Name, err.Message)
+}
+
+// Conn is a connection to a message bus.
+type Conn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	name string
+
+	wm     sync.Mutex // held while writing
+	m      sync.Mutex
+	serial uint32
+	calls  map[uint32]chan *Message
+	subs   map[*subscription]struct{}
+	served chan *Message
+
+	done chan struct{}
+	err  error
+}
+
+type subscription struct {
+	match Match
+	c     chan *Message
+	done  chan struct{}
+}
+
+// SessionBus connects to the session bus in $DBUS_SESSION_BUS_ADDRESS, or
+// to the usual socket in $XDG_RUNTIME_DIR.
+func SessionBus() (*Conn, error) {
+	addr := os.Getenv("DBUS_SESSION_BUS_ADDRESS")
+	if addr == "" {
+		dir := os.Getenv("XDG_RUNTIME_DIR")
+		if dir == "" {
+			dir = fmt.Sprintf("/run/user/%v", os.Getuid())
+		}
+		addr = "unix:path=" + dir + "/bus"
+	}
+	return Dial(addr)
+}
+
+// Dial connects to the bus at addr, such as "unix:path=/run/user/1000/bus",
+// authenticates and registers with it. If addr lists several addresses,
+// they are tried in order.
+func Dial(addr string) (*Conn, error) {
+	var errs []error
+	for a := range strings.SplitSeq(addr, ";") {
+		network, address, err := parseAddress(a)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		nc, err := net.Dial(network, address)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		c, err := newConn(nc)
+		if err != nil {
+			nc.Close()
+			errs = append(errs, err)
+			continue
+		}
+		return c, nil
+	}
+	return nil, fmt.Errorf("connect to dbus: %w", errors.Join(errs...))
+}
+
+// parseAddress returns what to dial for a single unix: address.
+func parseAddress(addr string) (network, address string, err error) {
+	transport, params, ok := strings.Cut(addr, ":")
+	if !ok || (transport != "unix") {
+		return "", "", fmt.Errorf("unsupported dbus address %q", addr)
+	}
+	for kv := range strings.SplitSeq(params, ",") {
+		k, v, _ := strings.Cut(kv, "=")
+		v, err := unescapeAddress(v)
+		if err != nil {
+			return "", "", fmt.Errorf("bad dbus address %q: %w", addr, err)
+		}
+		switch k {
+		case "path":
+			return "unix", v, nil
+		case "abstract":
+			return "unix", "@" + v, nil
+		}
+	}
+	return "", "", fmt.Errorf("unsupported dbus address %q", addr)
+}
+
+// unescapeAddress undoes the %-escaping of address values.
+func unescapeAddress(v string) (string, error) {
+	if !strings.Contains(v, "%") {
+		return v, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		if v[i] != '%' {
+			b.WriteByte(v[i])
+			continue
+		}
+		if i+2 >= len(v) {
+			return "", fmt.Errorf("truncated escape")
+		}
+		c, err := strconv.ParseUint(v[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("bad escape: %w", err)
+		}
+		b.WriteByte(byte(c))
+		i += 2
+	}
+	return b.String(), nil
+}
+
+func newConn(nc net.Conn) (*Conn, error) {
+	c := Conn{
+		conn:  nc,
+		r:     bufio.NewReader(nc),
+		calls: make(map[uint32]chan *Message),
+		subs:  make(map[*subscription]struct{}),
+		done:  make(chan struct{}),
+	}
+	if err := c.auth(); err != nil {
+		return nil, fmt.Errorf("authenticate: %w", err)
+	}
+	go c.read()
+
+	reply, err := c.Call(context.Background(), busName, busPath, busInterface, "Hello", "")
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("register with bus: %w", err)
+	}
+	var name string
+	if len(reply) == 1 {
+		name, _ = reply[0].(string)
+	}
+	if name == "" {
+		c.Close()
+		return nil, fmt.Errorf("register with bus: unexpected reply %v", reply)
+	}
+	c.name = name
+	return &c, nil
+}
+
+// auth performs EXTERNAL authentication with the caller's user ID.
+func (c *Conn) auth() error {
+	uid := hex.EncodeToString([]byte(strconv.Itoa(os.Getuid())))
+	if _, err := fmt.Fprintf(c.conn, "\x00AUTH EXTERNAL %v\r\n", uid); err != nil {
+		return err
+	}
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "OK ") {
+		return fmt.Errorf("server rejected EXTERNAL authentication: %q", strings.TrimSpace(line))
+	}
+	_, err = fmt.Fprint(c.conn, "BEGIN\r\n")
+	return err
+}
+
+// Name returns the connection's unique name on the bus.
+func (c *Conn) Name() string {
+	return c.name
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	err := c.conn.Close()
+	<-c.done
+	return err
+}
+
+// read dispatches incoming messages until the connection fails.
+func (c *Conn) read() {
+	var err error
+	defer func() {
+		c.m.Lock()
+		c.err = err
+		c.m.Unlock()
+		close(c.done)
+	}()
+
+	for {
+		var m *Message
+		m, err = readMessage(c.r)
+		if err != nil {
+			return
+		}
+
+		switch m.Type {
+		case TypeMethodReturn, TypeError:
+			c.m.Lock()
+			ch, ok := c.calls[m.ReplySerial]
+			delete(c.calls, m.ReplySerial)
+			c.m.Unlock()
+			if ok {
+				ch <- m
+			}
+
+		case TypeSignal:
+			c.m.Lock()
+			subs := make([]*subscription, 0, len(c.subs))
+			for s := range c.subs {
+				if s.match.matches(m) {
+					subs = append(subs, s)
+				}
+			}
+			c.m.Unlock()
+			for _, s := range subs {
+				select {
+				case s.c <- m:
+				case <-s.done:
+				}
+			}
+
+		case TypeMethodCall:
+			c.m.Lock()
+			served := c.served
+			c.m.Unlock()
+			if served != nil {
+				served <- m
+				continue
+			}
+			if m.Flags&flagNoReplyExpected == 0 {
+				go c.ReplyError(m, "org.freedesktop.DBus.Error.UnknownObject", "no objects are exported")
+			}
+		}
+	}
+}
+
+// closedErr returns the error that ended the connection, wrapped in
+// ErrClosed.
+func (c *Conn) closedErr() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.err == nil {
+		return ErrClosed
+	}
+	return fmt.Errorf("%w: %w", ErrClosed, c.err)
+}
+
+// send assigns m a serial and writes it.
+func (c *Conn) send(m *Message, reply chan *Message) error {
+	c.wm.Lock()
+	defer c.wm.Unlock()
+
+	c.m.Lock()
+	c.serial++
+	m.Serial = c.serial
+	if reply != nil {
+		c.calls[m.Serial] = reply
+	}
+	c.m.Unlock()
+
+	buf, err := m.encode()
+	if err == nil {
+		_, err = c.conn.Write(buf)
+	}
+	if err != nil {
+		c.m.Lock()
+		delete(c.calls, m.Serial)
+		c.m.Unlock()
+		select {
+		case <-c.done:
+			return c.closedErr()
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Call calls a method and waits for its reply, returning the reply's body.
+// An error reply is returned as an *Error.
+func (c *Conn) Call(ctx context.Context, dest string, path ObjectPath, iface, member string, sig Signature, args ...any) ([]any, error) {
+	m := Message{
+		Type:        TypeMethodCall,
+		Path:        path,
+		Interface:   iface,
+		Member:      member,
+		Destination: dest,
+		Signature:   sig,
+		Body:        args,
+	}
+	reply := make(chan *Message, 1)
+	if err := c.send(&m, reply); err != nil {
+		return nil, fmt.Errorf("call %v.%v: %w", iface, member, err)
+	}
+
+	select {
+	case r := <-reply:
+		if r.Type == TypeError {
+			err := Error{Name: r.ErrorName}
+			if len(r.Body) > 0 {
+				err.Message, _ = r.Body[0].(string)
+			}
+			return nil, &err
+		}
+		return r.Body, nil
+
+	case <-ctx.Done():
+		c.m.Lock()
+		delete(c.calls, m.Serial)
+		c.m.Unlock()
+		return nil, fmt.Errorf("call %v.%v: %w", iface, member, context.Cause(ctx))
+
+	case <-c.done:
+		return nil, fmt.Errorf("call %v.%v: %w", iface, member, c.closedErr())
+	}
+}
+
+// Match selects signals. Empty fields match anything.
+type Match struct {
+	Sender    string
+	Path      ObjectPath
+	Interface string
+	Member    string
+}
+
+func (m Match) rule() string {
+	parts := []string{"type='signal'"}
+	add := func(k, v string) {
+		if v != "" {
+			parts = append(parts, fmt.Sprintf("%v='%v'", k, strings.ReplaceAll(v, "'", `'\''`)))
+		}
+	}
+	add("sender", m.Sender)
+	add("path", string(m.Path))
+	add("interface", m.Interface)
+	add("member", m.Member)
+	return strings.Join(parts, ",")
+}
+
+// matches reports whether msg is selected by m. Senders given as
+// well-known names can't be checked locally, as signals carry the unique
+// name, so they are left to the bus.
+func (m Match) matches(msg *Message) bool {
+	return ((m.Sender == "") || (m.Sender[0] != ':') || (m.Sender == msg.Sender)) &&
+		((m.Path == "") || (m.Path == msg.Path)) &&
+		((m.Interface == "") || (m.Interface == msg.Interface)) &&
+		((m.Member == "") || (m.Member == msg.Member))
+}
+
+// Subscribe asks the bus for the signals selected by m and returns a channel
+// that they are delivered to. The channel must be drained until cancel is
+// called, which also removes the match from the bus.
+func (c *Conn) Subscribe(ctx context.Context, m Match) (signals <-chan *Message, cancel func(), err error) {
+	_, err = c.Call(ctx, busName, busPath, busInterface, "AddMatch", "s", m.rule())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s := subscription{match: m, c: make(chan *Message, 8), done: make(chan struct{})}
+	c.m.Lock()
+	c.subs[&s] = struct{}{}
+	c.m.Unlock()
+
+	var once sync.Once
+	cancel = func() {
+		once.Do(func() {
+			c.m.Lock()
+			delete(c.subs, &s)
+			c.m.Unlock()
+			close(s.done)
+			// Best-effort; the match goes away with the connection anyway.
+			go c.Call(context.Background(), busName, busPath, busInterface, "RemoveMatch", "s", m.rule())
+		})
+	}
+	return s.c, cancel, nil
+}
+
+// Emit sends a signal.
+func (c *Conn) Emit(path ObjectPath, iface, member string, sig Signature, args ...any) error {
+	m := Message{
+		Type:      TypeSignal,
+		Path:      path,
+		Interface: iface,
+		Member:    member,
+		Signature: sig,
+		Body:      args,
+	}
+	return c.send(&m, nil)
+}
+
+// Serve returns a channel that incoming method calls are delivered to. Each
+// of them must be answered with Reply or ReplyError unless it has the
+// no-reply flag set. Before Serve is called, method calls are answered with
+// an error.
+func (c *Conn) Serve() <-chan *Message {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.served == nil {
+		c.served = make(chan *Message, 8)
+	}
+	return c.served
+}
+
+// Reply answers a method call.
+func (c *Conn) Reply(call *Message, sig Signature, args ...any) error {
+	m := Message{
+		Type:        TypeMethodReturn,
+		ReplySerial: call.Serial,
+		Destination: call.Sender,
+		Signature:   sig,
+		Body:        args,
+	}
+	return c.send(&m, nil)
+}
+
+// ReplyError answers a method call with an error.
+func (c *Conn) ReplyError(call *Message, name, text string) error {
+	m := Message{
+		Type:        TypeError,
+		ErrorName:   name,
+		ReplySerial: call.Serial,
+		Destination: call.Sender,
+		Signature:   "s",
+		Body:        []any{text},
+	}
+	return c.send(&m, nil)
+}
+
+// RequestName asks the bus for a well-known name, failing if another
+// connection already has it.
+func (c *Conn) RequestName(ctx context.Context, name string) error {
+	const (
+		doNotQueue   = 4
+		primaryOwner = 1
+		alreadyOwner = 4
+	)
+	reply, err := c.Call(ctx, busName, busPath, busInterface, "RequestName", "su", name, uint32(doNotQueue))
+	if err != nil {
+		return err
+	}
+	if len(reply) == 1 {
+		switch reply[0] {
+		case any(uint32(primaryOwner)), any(uint32(alreadyOwner)):
+			return nil
+		}
+	}
+	return fmt.Errorf("name %v is already taken", name)
+}
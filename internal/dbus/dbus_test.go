@@ -0,0 +1,159 @@
+package dbus
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startBus starts a private dbus-daemon for the test and returns its
+// address. The test is skipped if dbus-daemon isn't installed.
+func startBus(t *testing.T) string {
+	t.Helper()
+
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not found")
+	}
+	addr := "unix:path=" + filepath.Join(t.TempDir(), "bus")
+	cmd := exec.Command(daemon, "--session", "--nofork", "--nopidfile", "--address="+addr)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	for range 100 {
+		c, err := Dial(addr)
+		if err == nil {
+			c.Close()
+			return addr
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("dbus-daemon did not start")
+	return ""
+}
+
+func TestConn(t *testing.T) {
+	addr := startBus(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	server, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	if !strings.HasPrefix(server.Name(), ":") {
+		t.Fatalf("unique name = %q", server.Name())
+	}
+	if err := server.RequestName(ctx, "org.example.Echo"); err != nil {
+		t.Fatal(err)
+	}
+	calls := server.Serve()
+	go func() {
+		for call := range calls {
+			switch call.Member {
+			case "Echo":
+				server.Reply(call, call.Signature, call.Body...)
+				server.Emit("/org/example/Echo", "org.example.Echo", "Echoed", "s", call.Body[0])
+			default:
+				server.ReplyError(call, "org.example.Error.Nope", "no such method")
+			}
+		}
+	}()
+
+	client, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	signals, stop, err := client.Subscribe(ctx, Match{
+		Path:   "/org/example/Echo",
+		Member: "Echoed",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	reply, err := client.Call(ctx, "org.example.Echo", "/org/example/Echo", "org.example.Echo", "Echo", "sa{sv}",
+		"hello", map[string]Variant{"n": MakeVariant(uint32(1))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (len(reply) != 2) || (reply[0] != "hello") {
+		t.Fatalf("reply = %#v", reply)
+	}
+
+	select {
+	case sig := <-signals:
+		if (sig.Sender != server.Name()) || (sig.Body[0] != "hello") {
+			t.Fatalf("signal = %+v", sig)
+		}
+	case <-ctx.Done():
+		t.Fatal("no signal received")
+	}
+
+	_, err = client.Call(ctx, "org.example.Echo", "/org/example/Echo", "org.example.Echo", "Other", "")
+	var derr *Error
+	if !errors.As(err, &derr) || (derr.Name != "org.example.Error.Nope") {
+		t.Fatalf("error = %v, want org.example.Error.Nope", err)
+	}
+
+	if err := server.RequestName(ctx, "org.example.Echo"); err != nil {
+		t.Fatalf("requesting an owned name again: %v", err)
+	}
+	if err := client.RequestName(ctx, "org.example.Echo"); err == nil {
+		t.Fatal("expected error requesting a taken name")
+	}
+}
+
+func TestConn_closed(t *testing.T) {
+	addr := startBus(t)
+	c, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	_, err = c.Call(context.Background(), busName, busPath, busInterface, "GetId", "")
+	if !errors.Is(err, ErrClosed) {
+		t.Fatalf("error = %v, want ErrClosed", err)
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		addr    string
+		address string
+	}{
+		{"unix:path=/run/user/1000/bus", "/run/user/1000/bus"},
+		{"unix:path=/tmp/a%20b,guid=123", "/tmp/a b"},
+		{"unix:abstract=/tmp/dbus-X,guid=1", "@/tmp/dbus-X"},
+	}
+	for _, tt := range tests {
+		_, address, err := parseAddress(tt.addr)
+		if err != nil {
+			t.Errorf("parseAddress(%q): %v", tt.addr, err)
+			continue
+		}
+		if address != tt.address {
+			t.Errorf("parseAddress(%q) = %q, want %q", tt.addr, address, tt.address)
+		}
+	}
+
+	for _, addr := range []string{"tcp:host=localhost", "unix:tmpdir=/tmp", "nope"} {
+		if _, _, err := parseAddress(addr); err == nil {
+			t.Errorf("parseAddress(%q): expected error", addr)
+		}
+	}
+}
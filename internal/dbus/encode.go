@@ -0,0 +1,539 @@
+package dbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// ObjectPath is a D-Bus object path, which is encoded with type "o".
+type ObjectPath string
+
+// Signature is a D-Bus type signature, which is encoded with type "g".
+type Signature string
+
+// Variant is a value together with its type, encoded with type "v".
+type Variant struct {
+	Sig   Signature
+	Value any
+}
+
+// MakeVariant returns a Variant for v, which must be of one of the basic
+// types that decoding produces.
+func MakeVariant(v any) Variant {
+	var sig Signature
+	switch v.(type) {
+	case byte:
+		sig = "y"
+	case bool:
+		sig = "b"
+	case int16:
+		sig = "n"
+	case uint16:
+		sig = "q"
+	case int32:
+		sig = "i"
+	case uint32:
+		sig = "u"
+	case int64:
+		sig = "x"
+	case uint64:
+		sig = "t"
+	case float64:
+		sig = "d"
+	case string:
+		sig = "s"
+	case ObjectPath:
+		sig = "o"
+	case Signature:
+		sig = "g"
+	case []string:
+		sig = "as"
+	case []byte:
+		sig = "ay"
+	case map[string]Variant:
+		sig = "a{sv}"
+	default:
+		panic(fmt.Errorf("no variant signature for %T", v))
+	}
+	return Variant{Sig: sig, Value: v}
+}
+
+// nextType splits the first complete type off of sig.
+func nextType(sig string) (first, rest string, err error) {
+	if sig == "" {
+		return "", "", fmt.Errorf("empty signature")
+	}
+	switch sig[0] {
+	case 'a':
+		elem, rest, err := nextType(sig[1:])
+		if err != nil {
+			return "", "", fmt.Errorf("array: %w", err)
+		}
+		return "a" + elem, rest, nil
+
+	case '(', '{':
+		end := byte(')')
+		if sig[0] == '{' {
+			end = '}'
+		}
+		i := 1
+		for (i < len(sig)) && (sig[i] != end) {
+			_, r, err := nextType(sig[i:])
+			if err != nil {
+				return "", "", err
+			}
+			i = len(sig) - len(r)
+		}
+		if i >= len(sig) {
+			return "", "", fmt.Errorf("unterminated %q in signature %q", sig[0], sig)
+		}
+		return sig[:i+1], sig[i+1:], nil
+
+	case 'y', 'b', 'n', 'q', 'i', 'u', 'x', 't', 'd', 's', 'o', 'g', 'v', 'h':
+		return sig[:1], sig[1:], nil
+
+	default:
+		return "", "", fmt.Errorf("unknown type %q in signature", sig[0])
+	}
+}
+
+// splitSignature splits sig into its complete types.
+func splitSignature(sig string) ([]string, error) {
+	var types []string
+	for sig != "" {
+		t, rest, err := nextType(sig)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, t)
+		sig = rest
+	}
+	return types, nil
+}
+
+// alignment returns the alignment of values of the type that sig starts
+// with.
+func alignment(sig string) int {
+	switch sig[0] {
+	case 'y', 'g', 'v':
+		return 1
+	case 'n', 'q':
+		return 2
+	case 'x', 't', 'd', '(', '{':
+		return 8
+	default:
+		return 4
+	}
+}
+
+// encoder appends values in little-endian wire format. Offsets, and so
+// alignment, are relative to the start of buf, which must itself be 8-byte
+// aligned in the message.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) align(n int) {
+	for len(e.buf)%n != 0 {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *encoder) u32(v uint32) {
+	e.align(4)
+	e.buf = binary.LittleEndian.AppendUint32(e.buf, v)
+}
+
+// values encodes args as the types in sig.
+func (e *encoder) values(sig string, args ...any) error {
+	types, err := splitSignature(sig)
+	if err != nil {
+		return err
+	}
+	if len(types) != len(args) {
+		return fmt.Errorf("signature %q has %v types, but got %v values", sig, len(types), len(args))
+	}
+	for i, t := range types {
+		if err := e.value(t, args[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// value encodes v as the single complete type t.
+func (e *encoder) value(t string, v any) (err error) {
+	defer func() {
+		// Mismatched types panic in the conversions below.
+		if r := recover(); r != nil {
+			err = fmt.Errorf("cannot encode %T as %q", v, t)
+		}
+	}()
+
+	switch t[0] {
+	case 'y':
+		e.buf = append(e.buf, v.(byte))
+	case 'b':
+		var b uint32
+		if v.(bool) {
+			b = 1
+		}
+		e.u32(b)
+	case 'n':
+		e.align(2)
+		e.buf = binary.LittleEndian.AppendUint16(e.buf, uint16(v.(int16)))
+	case 'q':
+		e.align(2)
+		e.buf = binary.LittleEndian.AppendUint16(e.buf, v.(uint16))
+	case 'i':
+		switch v := v.(type) {
+		case int:
+			e.u32(uint32(int32(v)))
+		default:
+			e.u32(uint32(v.(int32)))
+		}
+	case 'u', 'h':
+		e.u32(v.(uint32))
+	case 'x':
+		e.align(8)
+		e.buf = binary.LittleEndian.AppendUint64(e.buf, uint64(v.(int64)))
+	case 't':
+		e.align(8)
+		e.buf = binary.LittleEndian.AppendUint64(e.buf, v.(uint64))
+	case 'd':
+		e.align(8)
+		e.buf = binary.LittleEndian.AppendUint64(e.buf, math.Float64bits(v.(float64)))
+	case 's', 'o':
+		s := reflect.ValueOf(v).String()
+		if strings.IndexByte(s, 0) >= 0 {
+			return fmt.Errorf("string %q contains NUL", s)
+		}
+		e.u32(uint32(len(s)))
+		e.buf = append(e.buf, s...)
+		e.buf = append(e.buf, 0)
+	case 'g':
+		s := reflect.ValueOf(v).String()
+		e.buf = append(e.buf, byte(len(s)))
+		e.buf = append(e.buf, s...)
+		e.buf = append(e.buf, 0)
+	case 'v':
+		vv := v.(Variant)
+		if _, rest, err := nextType(string(vv.Sig)); (err != nil) || (rest != "") {
+			return fmt.Errorf("variant signature %q is not a single type", vv.Sig)
+		}
+		if err := e.value("g", vv.Sig); err != nil {
+			return err
+		}
+		return e.value(string(vv.Sig), vv.Value)
+	case 'a':
+		return e.array(t[1:], v)
+	case '(':
+		fields, err := splitSignature(t[1 : len(t)-1])
+		if err != nil {
+			return err
+		}
+		vals := v.([]any)
+		if len(vals) != len(fields) {
+			return fmt.Errorf("struct %q has %v fields, but got %v values", t, len(fields), len(vals))
+		}
+		e.align(8)
+		for i, f := range fields {
+			if err := e.value(f, vals[i]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("cannot encode type %q", t)
+	}
+	return nil
+}
+
+// array encodes a slice, or a map if elem is a dict entry.
+func (e *encoder) array(elem string, v any) error {
+	e.u32(0)
+	lenAt := len(e.buf) - 4
+	e.align(alignment(elem))
+	start := len(e.buf)
+
+	rv := reflect.ValueOf(v)
+	if elem[0] == '{' {
+		kv, err := splitSignature(elem[1 : len(elem)-1])
+		if (err != nil) || (len(kv) != 2) {
+			return fmt.Errorf("bad dict entry %q", elem)
+		}
+		if rv.Kind() != reflect.Map {
+			return fmt.Errorf("cannot encode %T as %q", v, "a"+elem)
+		}
+		iter := rv.MapRange()
+		for iter.Next() {
+			e.align(8)
+			if err := e.value(kv[0], iter.Key().Interface()); err != nil {
+				return err
+			}
+			if err := e.value(kv[1], iter.Value().Interface()); err != nil {
+				return err
+			}
+		}
+	} else {
+		if rv.Kind() != reflect.Slice {
+			return fmt.Errorf("cannot encode %T as %q", v, "a"+elem)
+		}
+		for i := range rv.Len() {
+			if err := e.value(elem, rv.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+	}
+
+	binary.LittleEndian.PutUint32(e.buf[lenAt:], uint32(len(e.buf)-start))
+	return nil
+}
+
+// decoder reads values in wire format from a whole message, so that
+// alignment is relative to its start.
+type decoder struct {
+	order binary.ByteOrder
+	buf   []byte
+	pos   int
+}
+
+func (d *decoder) align(n int) error {
+	next := (d.pos + n - 1) / n * n
+	if next > len(d.buf) {
+		return fmt.Errorf("unexpected end of message")
+	}
+	d.pos = next
+	return nil
+}
+
+func (d *decoder) take(n int) ([]byte, error) {
+	if (n < 0) || (d.pos+n > len(d.buf)) {
+		return nil, fmt.Errorf("unexpected end of message")
+	}
+	b := d.buf[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *decoder) u32() (uint32, error) {
+	if err := d.align(4); err != nil {
+		return 0, err
+	}
+	b, err := d.take(4)
+	if err != nil {
+		return 0, err
+	}
+	return d.order.Uint32(b), nil
+}
+
+// values decodes the types in sig.
+func (d *decoder) values(sig string) ([]any, error) {
+	types, err := splitSignature(sig)
+	if err != nil {
+		return nil, err
+	}
+	vals := make([]any, 0, len(types))
+	for _, t := range types {
+		v, err := d.value(t, 0)
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, v)
+	}
+	return vals, nil
+}
+
+// maxDepth limits how deeply containers may nest, as in the specification.
+const maxDepth = 64
+
+// value decodes the single complete type t. Arrays become []any, except
+// that byte arrays become []byte and dicts with string keys become
+// map[string]any. Other dicts become map[any]any and structs []any.
+func (d *decoder) value(t string, depth int) (any, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("message nests too deeply")
+	}
+
+	switch t[0] {
+	case 'y':
+		b, err := d.take(1)
+		if err != nil {
+			return nil, err
+		}
+		return b[0], nil
+	case 'b':
+		v, err := d.u32()
+		if err != nil {
+			return nil, err
+		}
+		if v > 1 {
+			return nil, fmt.Errorf("invalid boolean %v", v)
+		}
+		return v == 1, nil
+	case 'n', 'q':
+		if err := d.align(2); err != nil {
+			return nil, err
+		}
+		b, err := d.take(2)
+		if err != nil {
+			return nil, err
+		}
+		if t[0] == 'n' {
+			return int16(d.order.Uint16(b)), nil
+		}
+		return d.order.Uint16(b), nil
+	case 'i':
+		v, err := d.u32()
+		return int32(v), err
+	case 'u', 'h':
+		return d.u32()
+	case 'x', 't', 'd':
+		if err := d.align(8); err != nil {
+			return nil, err
+		}
+		b, err := d.take(8)
+		if err != nil {
+			return nil, err
+		}
+		v := d.order.Uint64(b)
+		switch t[0] {
+		case 'x':
+			return int64(v), nil
+		case 't':
+			return v, nil
+		default:
+			return math.Float64frombits(v), nil
+		}
+	case 's', 'o':
+		n, err := d.u32()
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.take(int(n) + 1)
+		if err != nil {
+			return nil, err
+		}
+		if t[0] == 'o' {
+			return ObjectPath(b[:n]), nil
+		}
+		return string(b[:n]), nil
+	case 'g':
+		n, err := d.take(1)
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.take(int(n[0]) + 1)
+		if err != nil {
+			return nil, err
+		}
+		return Signature(b[:n[0]]), nil
+	case 'v':
+		sig, err := d.value("g", depth)
+		if err != nil {
+			return nil, err
+		}
+		vt, rest, err := nextType(string(sig.(Signature)))
+		if (err != nil) || (rest != "") {
+			return nil, fmt.Errorf("variant signature %q is not a single type", sig)
+		}
+		v, err := d.value(vt, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		return Variant{Sig: sig.(Signature), Value: v}, nil
+	case 'a':
+		return d.array(t[1:], depth+1)
+	case '(':
+		fields, err := splitSignature(t[1 : len(t)-1])
+		if err != nil {
+			return nil, err
+		}
+		if err := d.align(8); err != nil {
+			return nil, err
+		}
+		vals := make([]any, 0, len(fields))
+		for _, f := range fields {
+			v, err := d.value(f, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			vals = append(vals, v)
+		}
+		return vals, nil
+	default:
+		return nil, fmt.Errorf("cannot decode type %q", t)
+	}
+}
+
+func (d *decoder) array(elem string, depth int) (any, error) {
+	n, err := d.u32()
+	if err != nil {
+		return nil, err
+	}
+	if err := d.align(alignment(elem)); err != nil {
+		return nil, err
+	}
+	end := d.pos + int(n)
+	if end > len(d.buf) {
+		return nil, fmt.Errorf("unexpected end of message")
+	}
+
+	switch {
+	case elem == "y":
+		b, err := d.take(int(n))
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), b...), nil
+
+	case elem[0] == '{':
+		kv, err := splitSignature(elem[1 : len(elem)-1])
+		if (err != nil) || (len(kv) != 2) {
+			return nil, fmt.Errorf("bad dict entry %q", elem)
+		}
+		strMap := make(map[string]any)
+		anyMap := make(map[any]any)
+		for d.pos < end {
+			if err := d.align(8); err != nil {
+				return nil, err
+			}
+			k, err := d.value(kv[0], depth)
+			if err != nil {
+				return nil, err
+			}
+			v, err := d.value(kv[1], depth)
+			if err != nil {
+				return nil, err
+			}
+			if s, ok := k.(string); ok {
+				strMap[s] = v
+			} else {
+				anyMap[k] = v
+			}
+		}
+		if d.pos != end {
+			return nil, fmt.Errorf("array overruns its length")
+		}
+		if kv[0] == "s" {
+			return strMap, nil
+		}
+		return anyMap, nil
+
+	default:
+		var vals []any
+		for d.pos < end {
+			v, err := d.value(elem, depth)
+			if err != nil {
+				return nil, err
+			}
+			vals = append(vals, v)
+		}
+		if d.pos != end {
+			return nil, fmt.Errorf("array overruns its length")
+		}
+		return vals, nil
+	}
+}
@@ -0,0 +1,153 @@
+package dbus
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestNextType(t *testing.T) {
+	tests := []struct {
+		sig         string
+		first, rest string
+	}{
+		{"s", "s", ""},
+		{"su", "s", "u"},
+		{"a{sv}i", "a{sv}", "i"},
+		{"(ua{sv})s", "(ua{sv})", "s"},
+		{"aa(yv)", "aa(yv)", ""},
+	}
+	for _, tt := range tests {
+		first, rest, err := nextType(tt.sig)
+		if err != nil {
+			t.Errorf("nextType(%q): %v", tt.sig, err)
+			continue
+		}
+		if (first != tt.first) || (rest != tt.rest) {
+			t.Errorf("nextType(%q) = %q, %q, want %q, %q", tt.sig, first, rest, tt.first, tt.rest)
+		}
+	}
+
+	for _, sig := range []string{"", "a", "(su", "z"} {
+		if _, _, err := nextType(sig); err == nil {
+			t.Errorf("nextType(%q): expected error", sig)
+		}
+	}
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	in := Message{
+		Type:        TypeMethodCall,
+		Serial:      7,
+		Path:        "/org/example/Thing",
+		Interface:   "org.example.Thing",
+		Member:      "Do",
+		Destination: "org.example",
+		Signature:   "yboiasa{sv}(ud)xv",
+		Body: []any{
+			byte(3),
+			true,
+			ObjectPath("/a/b"),
+			int32(-5),
+			[]string{"one", "two"},
+			map[string]Variant{
+				"token": MakeVariant("abc"),
+				"types": MakeVariant(uint32(3)),
+			},
+			[]any{uint32(1), 2.5},
+			int64(-1 << 40),
+			MakeVariant([]byte{1, 2, 3}),
+		},
+	}
+	buf, err := in.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := readMessage(bufio.NewReader(bytes.NewReader(buf)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (out.Type != in.Type) || (out.Serial != in.Serial) || (out.Path != in.Path) ||
+		(out.Interface != in.Interface) || (out.Member != in.Member) ||
+		(out.Destination != in.Destination) || (out.Signature != in.Signature) {
+		t.Fatalf("header = %+v, want %+v", out, in)
+	}
+
+	want := []any{
+		byte(3),
+		true,
+		ObjectPath("/a/b"),
+		int32(-5),
+		[]any{"one", "two"},
+		map[string]any{
+			"token": Variant{Sig: "s", Value: "abc"},
+			"types": Variant{Sig: "u", Value: uint32(3)},
+		},
+		[]any{uint32(1), 2.5},
+		int64(-1 << 40),
+		Variant{Sig: "ay", Value: []byte{1, 2, 3}},
+	}
+	if !reflect.DeepEqual(out.Body, want) {
+		t.Fatalf("body = %#v, want %#v", out.Body, want)
+	}
+}
+
+func TestEncode_alignment(t *testing.T) {
+	// A byte followed by a uint64 must pad to 8, and an empty array of
+	// 8-aligned elements must still pad before its (absent) first element.
+	var e encoder
+	if err := e.values("yt", byte(1), uint64(2)); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{1, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0}
+	if !bytes.Equal(e.buf, want) {
+		t.Fatalf("yt = %v, want %v", e.buf, want)
+	}
+
+	e = encoder{}
+	if err := e.values("a(yy)", []any{}); err != nil {
+		t.Fatal(err)
+	}
+	want = []byte{0, 0, 0, 0, 0, 0, 0, 0}
+	if !bytes.Equal(e.buf, want) {
+		t.Fatalf("empty a(yy) = %v, want %v", e.buf, want)
+	}
+}
+
+func TestEncode_mismatch(t *testing.T) {
+	var e encoder
+	if err := e.values("u", "nope"); err == nil {
+		t.Fatal("expected error encoding a string as u")
+	}
+	if err := e.values("su", "one"); err == nil {
+		t.Fatal("expected error for missing value")
+	}
+}
+
+func TestReadMessage_bigEndian(t *testing.T) {
+	// A signal with a single uint32 in the body, sent big-endian.
+	fields := []byte{
+		8, 1, 'g', 0, 1, 'u', 0, // SIGNATURE "u"
+	}
+	var buf []byte
+	buf = append(buf, 'B', byte(TypeSignal), 0, 1)
+	buf = binary.BigEndian.AppendUint32(buf, 4)
+	buf = binary.BigEndian.AppendUint32(buf, 9)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(fields)))
+	buf = append(buf, fields...)
+	for len(buf)%8 != 0 {
+		buf = append(buf, 0)
+	}
+	buf = binary.BigEndian.AppendUint32(buf, 0x01020304)
+
+	m, err := readMessage(bufio.NewReader(bytes.NewReader(buf)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (m.Serial != 9) || (len(m.Body) != 1) || (m.Body[0] != any(uint32(0x01020304))) {
+		t.Fatalf("message = %+v", m)
+	}
+}
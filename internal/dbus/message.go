@@ -0,0 +1,179 @@
+package dbus
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// MessageType is the type of a message.
+type MessageType byte
+
+const (
+	TypeMethodCall   MessageType = 1
+	TypeMethodReturn MessageType = 2
+	TypeError        MessageType = 3
+	TypeSignal       MessageType = 4
+)
+
+// Header fields.
+const (
+	fieldPath        = 1
+	fieldInterface   = 2
+	fieldMember      = 3
+	fieldErrorName   = 4
+	fieldReplySerial = 5
+	fieldDestination = 6
+	fieldSender      = 7
+	fieldSignature   = 8
+)
+
+// flagNoReplyExpected marks method calls that don't want a reply.
+const flagNoReplyExpected = 1
+
+// maxMessageSize is the largest message that is accepted, as in the
+// specification.
+const maxMessageSize = 1 << 27
+
+// Message is a single D-Bus message.
+type Message struct {
+	Type   MessageType
+	Flags  byte
+	Serial uint32
+
+	Path        ObjectPath
+	Interface   string
+	Member      string
+	ErrorName   string
+	ReplySerial uint32
+	Destination string
+	Sender      string
+
+	// Signature is the signature of Body.
+	Signature Signature
+	Body      []any
+}
+
+// encode returns the message in wire format.
+func (m *Message) encode() ([]byte, error) {
+	var body encoder
+	if err := body.values(string(m.Signature), m.Body...); err != nil {
+		return nil, err
+	}
+
+	var fields []any
+	field := func(code byte, sig Signature, v any) {
+		fields = append(fields, []any{code, Variant{Sig: sig, Value: v}})
+	}
+	if m.Path != "" {
+		field(fieldPath, "o", m.Path)
+	}
+	if m.Interface != "" {
+		field(fieldInterface, "s", m.Interface)
+	}
+	if m.Member != "" {
+		field(fieldMember, "s", m.Member)
+	}
+	if m.ErrorName != "" {
+		field(fieldErrorName, "s", m.ErrorName)
+	}
+	if m.ReplySerial != 0 {
+		field(fieldReplySerial, "u", m.ReplySerial)
+	}
+	if m.Destination != "" {
+		field(fieldDestination, "s", m.Destination)
+	}
+	if m.Sender != "" {
+		field(fieldSender, "s", m.Sender)
+	}
+	if m.Signature != "" {
+		field(fieldSignature, "g", m.Signature)
+	}
+
+	e := encoder{buf: []byte{'l', byte(m.Type), m.Flags, 1}}
+	e.u32(uint32(len(body.buf)))
+	e.u32(m.Serial)
+	if err := e.value("a(yv)", fields); err != nil {
+		return nil, err
+	}
+	e.align(8)
+	return append(e.buf, body.buf...), nil
+}
+
+// readMessage reads and decodes a single message.
+func readMessage(r *bufio.Reader) (*Message, error) {
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, err
+	}
+
+	var order binary.ByteOrder
+	switch fixed[0] {
+	case 'l':
+		order = binary.LittleEndian
+	case 'B':
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("invalid endianness %q", fixed[0])
+	}
+	bodyLen := order.Uint32(fixed[4:])
+	fieldsLen := order.Uint32(fixed[12:])
+	headerLen := (16 + uint64(fieldsLen) + 7) / 8 * 8
+	if headerLen+uint64(bodyLen) > maxMessageSize {
+		return nil, fmt.Errorf("message too large")
+	}
+
+	buf := make([]byte, headerLen+uint64(bodyLen))
+	copy(buf, fixed)
+	if _, err := io.ReadFull(r, buf[16:]); err != nil {
+		return nil, err
+	}
+
+	m := Message{
+		Type:   MessageType(fixed[1]),
+		Flags:  fixed[2],
+		Serial: order.Uint32(fixed[8:]),
+	}
+	d := decoder{order: order, buf: buf[:16+fieldsLen], pos: 12}
+	fields, err := d.value("a(yv)", 0)
+	if err != nil {
+		return nil, fmt.Errorf("decode header: %w", err)
+	}
+	for _, f := range fields.([]any) {
+		f := f.([]any)
+		v := f[1].(Variant).Value
+		var ok bool
+		switch f[0].(byte) {
+		case fieldPath:
+			m.Path, ok = v.(ObjectPath)
+		case fieldInterface:
+			m.Interface, ok = v.(string)
+		case fieldMember:
+			m.Member, ok = v.(string)
+		case fieldErrorName:
+			m.ErrorName, ok = v.(string)
+		case fieldReplySerial:
+			m.ReplySerial, ok = v.(uint32)
+		case fieldDestination:
+			m.Destination, ok = v.(string)
+		case fieldSender:
+			m.Sender, ok = v.(string)
+		case fieldSignature:
+			m.Signature, ok = v.(Signature)
+		default:
+			// Unknown fields must be ignored.
+			ok = true
+		}
+		if !ok {
+			return nil, fmt.Errorf("header field %v has type %q", f[0], f[1].(Variant).Sig)
+		}
+	}
+
+	d = decoder{order: order, buf: buf, pos: int(headerLen)}
+	m.Body, err = d.values(string(m.Signature))
+	if err != nil {
+		return nil, fmt.Errorf("decode body: %w", err)
+	}
+	return &m, nil
+}
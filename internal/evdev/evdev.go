@@ -7,6 +7,7 @@ import (
 	"os"
 	"structs"
 	"syscall"
+	"time"
 	"unsafe"
 
 	"golang.org/x/sys/unix"
@@ -23,7 +24,18 @@ type Device struct {
 }
 
 func Open(path string) (*Device, error) {
-	file, err := os.Open(path)
+	return open(path, os.O_RDONLY)
+}
+
+// OpenReadWrite opens the device for writing events to as well as reading
+// them, such as to set its LEDs. This usually needs more permissions than
+// reading.
+func OpenReadWrite(path string) (*Device, error) {
+	return open(path, os.O_RDWR)
+}
+
+func open(path string, flag int) (*Device, error) {
+	file, err := os.OpenFile(path, flag, 0)
 	if err != nil {
 		return nil, err
 	}
@@ -72,7 +84,7 @@ func (d *Device) init() error {
 	d.bitsABS = bitsABS[:]
 
 	var bitsLED [(ledCount + wordbits - 1) / 8]byte
-	err = cctl(conn, uintptr(eviocgbit(evLed, uintptr(len(bitsLED)))), &bitsLED[0])
+	err = cctl(conn, uintptr(eviocgbit(EvLed, uintptr(len(bitsLED)))), &bitsLED[0])
 	if err != nil {
 		return fmt.Errorf("get type bits: %w", err)
 	}
@@ -132,7 +144,7 @@ func (d *Device) typeCodes(t uint16) []byte {
 		return d.bitsMSC
 	case EvSw:
 		return d.bitsSW
-	case evLed:
+	case EvLed:
 		return d.bitsLED
 	case evSnd:
 		return d.bitsSND
@@ -151,23 +163,121 @@ func (d *Device) HasEventCode(t, code uint16) bool {
 	return d.HasEventType(t) && isBitSet(d.typeCodes(t), code)
 }
 
+// inputEvent is the kernel's struct input_event.
+type inputEvent struct {
+	_     structs.HostLayout
+	Time  unix.Timeval
+	Type  uint16
+	Code  uint16
+	Value int32
+}
+
 func (d *Device) NextEvent() (InputEvent, error) {
-	type inputEvent struct {
-		_ structs.HostLayout
-		_ [16]byte // TODO: Add timestamp support.
-		InputEvent
-	}
-	var ev [unsafe.Sizeof(inputEvent{})]byte
-	_, err := io.ReadFull(d.file, ev[:])
+	var buf [unsafe.Sizeof(inputEvent{})]byte
+	_, err := io.ReadFull(d.file, buf[:])
 	if err != nil {
 		return InputEvent{}, fmt.Errorf("read: %w", err)
 	}
 
-	return (*inputEvent)(unsafe.Pointer(&ev[0])).InputEvent, nil
+	ev := (*inputEvent)(unsafe.Pointer(&buf[0]))
+	r := InputEvent{
+		Type:  ev.Type,
+		Code:  ev.Code,
+		Value: ev.Value,
+	}
+	if sec, nsec := ev.Time.Unix(); (sec != 0) || (nsec != 0) {
+		r.Time = time.Unix(sec, nsec)
+	}
+	return r, nil
+}
+
+// writeEvents writes events to the device, followed by a SYN_REPORT. The
+// device must have been opened with OpenReadWrite.
+func (d *Device) writeEvents(evs ...inputEvent) error {
+	evs = append(evs, inputEvent{Type: EvSyn, Code: synReport})
+	size := int(unsafe.Sizeof(inputEvent{}))
+	buf := unsafe.Slice((*byte)(unsafe.Pointer(&evs[0])), len(evs)*size)
+	_, err := d.file.Write(buf)
+	if err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	return nil
+}
+
+// LED reports whether the LED with the given code is lit.
+func (d *Device) LED(code uint16) (bool, error) {
+	conn, err := d.file.SyscallConn()
+	if err != nil {
+		return false, err
+	}
+
+	var bits [(ledCount + wordbits - 1) / 8]byte
+	err = cctl(conn, eviocgled(uintptr(len(bits))), &bits[0])
+	if err != nil {
+		return false, fmt.Errorf("get led state: %w", err)
+	}
+	return isBitSet(bits[:], code), nil
+}
+
+// SetLED lights or clears the LED with the given code. The device must
+// have been opened with OpenReadWrite.
+func (d *Device) SetLED(code uint16, on bool) error {
+	var value int32
+	if on {
+		value = 1
+	}
+	return d.writeEvents(inputEvent{Type: EvLed, Code: code, Value: value})
+}
+
+// CanRumble reports whether the device has a rumble motor.
+func (d *Device) CanRumble() bool {
+	return d.HasEventCode(evFf, ffRumble)
+}
+
+// UploadRumble uploads a rumble effect of the given length and motor
+// strengths and returns its ID for PlayEffect. The device must have been
+// opened with OpenReadWrite.
+func (d *Device) UploadRumble(strong, weak uint16, length time.Duration) (int16, error) {
+	conn, err := d.file.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	effect := ffEffect{
+		Type: ffRumble,
+		ID:   -1,
+	}
+	effect.Replay.Length = uint16(min(length.Milliseconds(), 0xffff))
+	effect.Rumble.StrongMagnitude = strong
+	effect.Rumble.WeakMagnitude = weak
+	err = cctl(conn, eviocsff, &effect)
+	if err != nil {
+		return 0, fmt.Errorf("upload effect: %w", err)
+	}
+	return effect.ID, nil
+}
+
+// PlayEffect plays an uploaded force feedback effect once.
+func (d *Device) PlayEffect(id int16) error {
+	return d.writeEvents(inputEvent{Type: evFf, Code: uint16(id), Value: 1})
+}
+
+// RemoveEffect removes an uploaded force feedback effect.
+func (d *Device) RemoveEffect(id int16) error {
+	conn, err := d.file.SyscallConn()
+	if err != nil {
+		return err
+	}
+	return control(conn, func(fd uintptr) error {
+		_, _, errno := unix.Syscall(unix.SYS_IOCTL, fd, eviocrmff, uintptr(id))
+		return fromErrno(errno)
+	})
 }
 
 type InputEvent struct {
-	_     structs.HostLayout
+	// Time is the kernel timestamp of the event. It is the zero time
+	// if the kernel did not provide one.
+	Time  time.Time
 	Type  uint16
 	Code  uint16
 	Value int32
@@ -1,6 +1,10 @@
 package evdev
 
-import "unsafe"
+import (
+	"strings"
+	"structs"
+	"unsafe"
+)
 
 const (
 	wordbits = unsafe.Sizeof(uintptr(0)) * 8
@@ -59,10 +63,72 @@ const (
 )
 
 const (
-	evLed = 0x11 + iota
+	EvLed = 0x11 + iota
 	evSnd
 )
 
+// synReport is the code of the EV_SYN event that ends a batch.
+const synReport = 0
+
+// ffRumble is the force feedback effect type of a rumble motor.
+const ffRumble = 0x50
+
+const ptrSize = unsafe.Sizeof(uintptr(0))
+
+// ffUnionSize is the size of the union in struct ff_effect, which is that
+// of its largest member, struct ff_periodic_effect, with its pointer at the
+// end.
+const ffUnionSize = 24 + ptrSize
+
+// ffEffect is the kernel's struct ff_effect, with only the rumble member
+// of its union.
+type ffEffect struct {
+	_         structs.HostLayout
+	Type      uint16
+	ID        int16
+	Direction uint16
+	Trigger   struct{ Button, Interval uint16 }
+	Replay    struct{ Length, Delay uint16 }
+	Rumble    ffRumbleEffect
+}
+
+type ffRumbleEffect struct {
+	_ structs.HostLayout
+	// The union is aligned for its pointer.
+	_               [0]uintptr
+	StrongMagnitude uint16
+	WeakMagnitude   uint16
+	_               [ffUnionSize - 4]byte
+}
+
+var (
+	eviocsff  = (iocWrite << iocDirShift) | ('E' << iocTypeShift) | (0x80 << iocNRShift) | (unsafe.Sizeof(ffEffect{}) << iocSizeShift)
+	eviocrmff = (iocWrite << iocDirShift) | ('E' << iocTypeShift) | (0x81 << iocNRShift) | (unsafe.Sizeof(int32(0)) << iocSizeShift)
+)
+
+// ledNames maps the names of the LED codes to them, as in
+// linux/input-event-codes.h, with "lock" spelled out for the first three.
+var ledNames = map[string]uint16{
+	"numlock":    0x00,
+	"capslock":   0x01,
+	"scrolllock": 0x02,
+	"compose":    0x03,
+	"kana":       0x04,
+	"sleep":      0x05,
+	"suspend":    0x06,
+	"mute":       0x07,
+	"misc":       0x08,
+	"mail":       0x09,
+	"charging":   0x0a,
+}
+
+// LEDByName returns the code of the LED with the given name, such as
+// "scrolllock", ignoring case.
+func LEDByName(name string) (uint16, bool) {
+	code, ok := ledNames[strings.ToLower(name)]
+	return code, ok
+}
+
 const (
 	evRep = 0x14 + iota
 	evFf
@@ -74,6 +140,10 @@ func eviocgname(length uintptr) uintptr {
 	return eviocgnameBase | (length << iocSizeShift)
 }
 
+func eviocgled(length uintptr) uintptr {
+	return iocReadEBase | (0x19 << iocNRShift) | (length << iocSizeShift)
+}
+
 func eviocgbit(ev, length uintptr) uintptr {
 	return iocReadEBase | ((0x20 + ev) << iocNRShift) | (length << iocSizeShift)
 }
@@ -1,6 +1,8 @@
 //go:build ignore
 
-// Command gen_keysyms builds keysyms.go from X11 keysym header files.
+// Command gen_keysyms builds keysyms.go from X11 keysym header files. It
+// writes both the name→value table and a value→name table holding one
+// canonical name per value for reverse lookups.
 //
 // Usage (from package directory or via go:generate):
 //
@@ -56,10 +58,11 @@ func main() {
 	}
 
 	table := make(map[string]uint32)
+	names := make(map[uint32][]candidate)
 	var sources []string
 	for _, name := range headerFiles {
 		path := filepath.Join(dir, name)
-		n, err := parseHeader(path, table)
+		n, err := parseHeader(path, table, names)
 		if err != nil {
 			if os.IsNotExist(err) {
 				continue
@@ -77,14 +80,64 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err := writeKeysyms(*outPath, table, sources, dir); err != nil {
+	addCompat(table, names)
+
+	if err := writeKeysyms(*outPath, table, canonicalNames(table, names), sources, dir); err != nil {
 		fmt.Fprintf(os.Stderr, "write %s: %v\n", *outPath, err)
 		os.Exit(1)
 	}
 	fmt.Fprintf(os.Stderr, "wrote %s (%d names)\n", *outPath, len(table))
 }
 
-func parseHeader(path string, table map[string]uint32) (int, error) {
+// compatKeysyms are names that some xorgproto releases define and others
+// don't, such as ones added after the headers that distributions commonly
+// ship or dropped from them since. They are added if the headers lack them,
+// so that a config written against either keeps working. Preferred ones are
+// the canonical name in the releases that have them and are used as such
+// here as well.
+var compatKeysyms = []struct {
+	prefix, name string
+	val          uint32
+	preferred    bool
+}{
+	{"XF86XK_", "MediaSelectCD", 0x1008ff53, false},
+	{"XK_", "guillemetleft", 0xab, true},
+	{"XK_", "guillemetright", 0xbb, true},
+	{"XK_", "ordmasculine", 0xba, true},
+	{"XK_", "dead_schwa", 0xfe8a, true},
+	{"XK_", "dead_SCHWA", 0xfe8b, true},
+	{"XK_", "dead_hamza", 0xfe8d, true},
+}
+
+// addCompat adds the compatKeysyms that the headers did not define.
+func addCompat(table map[string]uint32, names map[uint32][]candidate) {
+	for _, k := range compatKeysyms {
+		display := k.name
+		if k.prefix == "XF86XK_" {
+			display = "XF86" + k.name
+		}
+		if _, ok := table[display]; ok {
+			continue
+		}
+		add(table, k.name, k.val)
+		add(table, display, k.val)
+
+		c := candidate{name: display, deprecated: !k.preferred}
+		if k.preferred {
+			names[k.val] = append([]candidate{c}, names[k.val]...)
+		} else {
+			names[k.val] = append(names[k.val], c)
+		}
+	}
+}
+
+// candidate is a name defined for a keysym value, in header order.
+type candidate struct {
+	name       string
+	deprecated bool
+}
+
+func parseHeader(path string, table map[string]uint32, names map[uint32][]candidate) (int, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return 0, err
@@ -109,10 +162,17 @@ func parseHeader(path string, table map[string]uint32) (int, error) {
 
 		// Bare name (Alt_L, AudioMute) — matches XKB_KEY_* after strip.
 		add(table, name, val)
-		// XF86 headers are often referenced as XF86AudioMute as well.
+		// XF86 headers are often referenced as XF86AudioMute as well, which
+		// is also the name xkbcommon prints for them.
+		display := name
 		if prefix == "XF86XK_" {
 			add(table, "XF86"+name, val)
+			display = "XF86" + name
 		}
+		names[val] = append(names[val], candidate{
+			name:       display,
+			deprecated: strings.Contains(line, "deprecated"),
+		})
 		n++
 	}
 	return n, sc.Err()
@@ -126,7 +186,30 @@ func add(table map[string]uint32, name string, val uint32) {
 	table[name] = val
 }
 
-func writeKeysyms(path string, table map[string]uint32, sources []string, dir string) error {
+// canonicalNames picks one name per keysym value: the first definition that
+// is not marked deprecated (the headers list the preferred name first), or
+// the first definition if all are. Names that lost to an earlier definition
+// with a different value in table are skipped, so every result looks up to
+// its own value.
+func canonicalNames(table map[string]uint32, names map[uint32][]candidate) map[uint32]string {
+	out := make(map[uint32]string, len(names))
+	for val, cands := range names {
+		for _, c := range cands {
+			if table[c.name] != val {
+				continue
+			}
+			if _, ok := out[val]; !ok || !c.deprecated {
+				out[val] = c.name
+			}
+			if !c.deprecated {
+				break
+			}
+		}
+	}
+	return out
+}
+
+func writeKeysyms(path string, table map[string]uint32, canonical map[uint32]string, sources []string, dir string) error {
 	names := make([]string, 0, len(table))
 	for n := range table {
 		names = append(names, n)
@@ -137,7 +220,7 @@ func writeKeysyms(path string, table map[string]uint32, sources []string, dir st
 	b.WriteString("// Code generated by go run ./gen_keysyms.go; DO NOT EDIT.\n")
 	b.WriteString("//\n")
 	b.WriteString("// Regenerate (from repo root):\n")
-	b.WriteString("//   go generate ./internal/xdo\n")
+	b.WriteString("//   go generate ./internal/keysym\n")
 	b.WriteString("// or (from this package directory):\n")
 	b.WriteString("//   go run ./gen_keysyms.go -o keysyms.go\n")
 	b.WriteString("//\n")
@@ -148,12 +231,25 @@ func writeKeysyms(path string, table map[string]uint32, sources []string, dir st
 	b.WriteString(" (from ")
 	b.WriteString(dir)
 	b.WriteString(")\n\n")
-	b.WriteString("package xdo\n\n")
+	b.WriteString("package keysym\n\n")
 	b.WriteString("// keysyms maps X11/xkb-style keysym names (without XK_/XKB_KEY_ prefix) to X keysym values.\n")
 	b.WriteString("var keysyms = map[string]uint32{\n")
 	for _, name := range names {
 		fmt.Fprintf(&b, "\t%q: 0x%x,\n", name, table[name])
 	}
+	b.WriteString("}\n\n")
+
+	vals := make([]uint32, 0, len(canonical))
+	for v := range canonical {
+		vals = append(vals, v)
+	}
+	sort.Slice(vals, func(i, j int) bool { return vals[i] < vals[j] })
+
+	b.WriteString("// keysymNames maps X keysym values to their canonical name, as used in keysyms.\n")
+	b.WriteString("var keysymNames = map[uint32]string{\n")
+	for _, v := range vals {
+		fmt.Fprintf(&b, "\t0x%x: %q,\n", v, canonical[v])
+	}
 	b.WriteString("}\n")
 
 	formatted, err := format.Source(b.Bytes())
@@ -0,0 +1,181 @@
+// Package keysym resolves X keysym names without a display connection, using
+// a name table generated from the X11 headers (see keysyms.go and
+// go:generate).
+//
+// Names match the usual X11 / xkbcommon macros with optional prefixes stripped
+// (XKB_KEY_, XK_, XF86XK_). Lookup is exact after that strip — no case folding.
+// Names are case-sensitive. [Name] goes the other way, and [WithPrefix] and
+// [Search] list names for completion and diagnostics.
+//
+// A keysym may also be given as a single character ("é", "§") or as a Unicode
+// codepoint in U+XXXX notation ("U+1F399"). Latin-1 characters map to their
+// legacy keysyms; all others map to the Unicode keysym range (0x01000000 plus
+// the codepoint).
+package keysym
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+//go:generate go run ./gen_keysyms.go -o keysyms.go
+
+// prefixes are optional header-style prefixes, longest first, so at most one
+// prefix is stripped (e.g. XKB_KEY_ before XK_).
+var prefixes = []string{"XKB_KEY_", "XF86XK_", "XK_"}
+
+// Lookup looks up an X11/xkb-style keysym name (e.g. "Alt_L"). Names are
+// exact (case-sensitive) after optional prefix stripping. Single characters
+// and U+XXXX codepoints are accepted as well (see package docs).
+func Lookup(name string) (uint32, bool) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return 0, false
+	}
+	if v, ok := keysyms[stripPrefix(name)]; ok {
+		return v, true
+	}
+	if r, ok := parseCodepoint(name); ok {
+		return unicodeKeysym(r), true
+	}
+	if r, size := utf8.DecodeRuneInString(name); (size == len(name)) && (r != utf8.RuneError) {
+		if unicodeKeysymValid(r) {
+			return unicodeKeysym(r), true
+		}
+	}
+	return 0, false
+}
+
+// Name returns the canonical name of sym. When several names share a value,
+// the one the X11 headers list first (and do not mark deprecated) is used.
+// Unnamed keysyms in the Unicode range are returned in U+XXXX notation.
+func Name(sym uint32) (string, bool) {
+	if name, ok := keysymNames[sym]; ok {
+		return name, true
+	}
+	if r := rune(sym - 0x01000000); (sym > 0x01000000) && unicodeKeysymValid(r) {
+		return fmt.Sprintf("U+%04X", r), true
+	}
+	return "", false
+}
+
+var sortedNames = sync.OnceValue(func() []string {
+	return slices.Sorted(maps.Keys(keysyms))
+})
+
+// WithPrefix returns the known keysym names that start with prefix, sorted.
+// Like [Lookup], matching is case-sensitive and an optional header-style
+// prefix is stripped first. An empty prefix lists every name.
+func WithPrefix(prefix string) []string {
+	prefix = stripPrefix(strings.TrimSpace(prefix))
+	names := sortedNames()
+	start, _ := slices.BinarySearch(names, prefix)
+	end := start
+	for (end < len(names)) && strings.HasPrefix(names[end], prefix) {
+		end++
+	}
+	return slices.Clone(names[start:end])
+}
+
+// Search returns the known keysym names that contain s, ignoring case. Names
+// that start with s come first; each group is sorted.
+func Search(s string) []string {
+	s = strings.ToLower(stripPrefix(strings.TrimSpace(s)))
+	var prefixed, contained []string
+	for _, name := range sortedNames() {
+		lower := strings.ToLower(name)
+		switch {
+		case strings.HasPrefix(lower, s):
+			prefixed = append(prefixed, name)
+		case strings.Contains(lower, s):
+			contained = append(contained, name)
+		}
+	}
+	return append(prefixed, contained...)
+}
+
+// Split splits libxdo/xdotool-style sequences ("Control_L+Alt_L") into their
+// names. A single bare name has one part. U+XXXX names are kept whole, and a
+// lone "+" is the plus sign itself; inside a sequence it has to be written as
+// "plus".
+func Split(keys string) []string {
+	keys = strings.TrimSpace(keys)
+	if keys == "" {
+		return nil
+	}
+	if keys == "+" {
+		return []string{keys}
+	}
+	parts := strings.Split(keys, "+")
+	out := make([]string, 0, len(parts))
+	for i := 0; i < len(parts); i++ {
+		p := strings.TrimSpace(parts[i])
+		if (p == "U") && (i+1 < len(parts)) && isCodepointHex(parts[i+1]) {
+			p += "+" + parts[i+1]
+			i++
+		}
+		if p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// parseCodepoint parses a name in U+XXXX notation (four to six hex digits).
+func parseCodepoint(name string) (rune, bool) {
+	hex, ok := strings.CutPrefix(name, "U+")
+	if !ok || !isCodepointHex(hex) {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || !unicodeKeysymValid(rune(v)) {
+		return 0, false
+	}
+	return rune(v), true
+}
+
+func isCodepointHex(s string) bool {
+	if (len(s) < 4) || (len(s) > 6) {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// unicodeKeysymValid reports whether r is a printable character that has a
+// keysym. Control characters have dedicated keysyms (Return, Tab, ...) that
+// are looked up by name instead.
+func unicodeKeysymValid(r rune) bool {
+	return utf8.ValidRune(r) && !unicode.IsControl(r)
+}
+
+// unicodeKeysym returns the keysym for r. Latin-1 characters use their legacy
+// keysyms, which equal the codepoint, so they match what layouts contain;
+// everything else is in the Unicode keysym range.
+func unicodeKeysym(r rune) uint32 {
+	if r <= 0xff {
+		return uint32(r)
+	}
+	return 0x01000000 + uint32(r)
+}
+
+// stripPrefix removes at most one known header-style prefix, matching the
+// longest applicable prefix first.
+func stripPrefix(name string) string {
+	for _, p := range prefixes {
+		if strings.HasPrefix(name, p) {
+			return name[len(p):]
+		}
+	}
+	return name
+}
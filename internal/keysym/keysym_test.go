@@ -0,0 +1,260 @@
+package keysym
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLookup_commonPTT(t *testing.T) {
+	// Values from X11/keysymdef.h — must match what libxdo/xkb accepted.
+	cases := map[string]uint32{
+		"Alt_L":            0xffe9,
+		"Alt_R":            0xffea,
+		"Control_L":        0xffe3,
+		"Control_R":        0xffe4,
+		"Shift_L":          0xffe1,
+		"Shift_R":          0xffe2,
+		"Super_L":          0xffeb,
+		"Super_R":          0xffec,
+		"Meta_L":           0xffe7,
+		"Meta_R":           0xffe8,
+		"space":            0x0020,
+		"Return":           0xff0d,
+		"Tab":              0xff09,
+		"Escape":           0xff1b,
+		"Caps_Lock":        0xffe5,
+		"F1":               0xffbe,
+		"F13":              0xffca,
+		"a":                0x0061,
+		"A":                0x0041,
+		"ISO_Level3_Shift": 0xfe03,
+	}
+	for name, want := range cases {
+		got, ok := Lookup(name)
+		if !ok {
+			t.Errorf("Lookup(%q) missing", name)
+			continue
+		}
+		if got != want {
+			t.Errorf("Lookup(%q) = 0x%x, want 0x%x", name, got, want)
+		}
+	}
+}
+
+func TestLookup_compat(t *testing.T) {
+	// Names that not every release of the X11 headers defines, but that
+	// configs may already use.
+	cases := map[string]uint32{
+		"MediaSelectCD":     0x1008ff53,
+		"XF86MediaSelectCD": 0x1008ff53,
+		"XF86CD":            0x1008ff53,
+		"guillemetleft":     0xab,
+		"guillemotleft":     0xab,
+		"ordmasculine":      0xba,
+		"dead_schwa":        0xfe8a,
+		"dead_hamza":        0xfe8d,
+		"XF86AudioMicMute":  0x1008ffb2,
+	}
+	for name, want := range cases {
+		got, ok := Lookup(name)
+		if !ok {
+			t.Errorf("Lookup(%q) missing", name)
+			continue
+		}
+		if got != want {
+			t.Errorf("Lookup(%q) = 0x%x, want 0x%x", name, got, want)
+		}
+	}
+}
+
+func TestLookup_prefixes(t *testing.T) {
+	base, ok := Lookup("Alt_L")
+	if !ok {
+		t.Fatal("Alt_L missing")
+	}
+	for _, name := range []string{"XK_Alt_L", "XKB_KEY_Alt_L", "XF86XK_AudioMute"} {
+		got, ok := Lookup(name)
+		if name == "XF86XK_AudioMute" {
+			want, wantOK := Lookup("AudioMute")
+			if !ok || !wantOK || got != want {
+				t.Errorf("Lookup(%q) = (%x, %v), want AudioMute (%x, %v)", name, got, ok, want, wantOK)
+			}
+			continue
+		}
+		if !ok || got != base {
+			t.Errorf("Lookup(%q) = (%x, %v), want (%x, true)", name, got, ok, base)
+		}
+	}
+}
+
+func TestStripPrefix_longestOnly(t *testing.T) {
+	// Single longest-match strip: do not strip XK_ after XKB_KEY_.
+	if got := stripPrefix("XKB_KEY_Alt_L"); got != "Alt_L" {
+		t.Fatalf("XKB_KEY_Alt_L -> %q, want Alt_L", got)
+	}
+	if got := stripPrefix("XK_Alt_L"); got != "Alt_L" {
+		t.Fatalf("XK_Alt_L -> %q, want Alt_L", got)
+	}
+	if got := stripPrefix("XF86XK_AudioMute"); got != "AudioMute" {
+		t.Fatalf("XF86XK_AudioMute -> %q, want AudioMute", got)
+	}
+	// Nested/pathological: only one prefix, so XKB_KEY_XK_Foo becomes XK_Foo
+	// (not Foo). Lookup would then fail unless XK_Foo exists as a name.
+	if got := stripPrefix("XKB_KEY_XK_Alt_L"); got != "XK_Alt_L" {
+		t.Fatalf("XKB_KEY_XK_Alt_L -> %q, want XK_Alt_L (single strip)", got)
+	}
+	if got := stripPrefix("Alt_L"); got != "Alt_L" {
+		t.Fatalf("Alt_L -> %q, want unchanged", got)
+	}
+}
+
+func TestLookup_exactOnly(t *testing.T) {
+	// Case-sensitive: multi-segment names must match exactly.
+	if _, ok := Lookup("alt_l"); ok {
+		t.Error("alt_l must not resolve (exact names only)")
+	}
+	if _, ok := Lookup("ALT_L"); ok {
+		t.Error("ALT_L must not resolve (exact names only)")
+	}
+	if _, ok := Lookup("Alt_l"); ok {
+		t.Error("Alt_l must not resolve (exact names only)")
+	}
+	// Canonical form still works.
+	if _, ok := Lookup("Alt_L"); !ok {
+		t.Error("Alt_L must resolve")
+	}
+}
+
+func TestLookup_unknown(t *testing.T) {
+	if _, ok := Lookup("NotARealKeysym_XYZ"); ok {
+		t.Fatal("expected unknown keysym to fail")
+	}
+	if _, ok := Lookup(""); ok {
+		t.Fatal("expected empty name to fail")
+	}
+}
+
+func TestSplit(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []string
+	}{
+		{"Alt_L", []string{"Alt_L"}},
+		{"Control_L+Alt_L", []string{"Control_L", "Alt_L"}},
+		{"  Shift_L + a ", []string{"Shift_L", "a"}},
+		{"", nil},
+	}
+	for _, tc := range cases {
+		got := Split(tc.in)
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("Split(%q) = %v, want %v", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestLookup_unicode(t *testing.T) {
+	cases := []struct {
+		name string
+		want uint32
+		ok   bool
+	}{
+		{"U+1F399", 0x0101f399, true},
+		{"U+20AC", 0x010020ac, true},
+		{"U+00E9", 0xe9, true}, // Latin-1 uses the legacy keysym
+		{"U+0040", 0x40, true},
+		{"§", 0xa7, true},
+		{"€", 0x010020ac, true},
+		{"🎙", 0x0101f399, true},
+		{"a", 0x61, true}, // named keysym wins
+		{"U+41", 0, false},
+		{"U+0000", 0, false},
+		{"U+D800", 0, false},
+		{"U+110000", 0, false},
+		{"U+XYZW", 0, false},
+		{"\t", 0, false},
+		{"ab", 0, false},
+	}
+	for _, tc := range cases {
+		got, ok := Lookup(tc.name)
+		if (ok != tc.ok) || (got != tc.want) {
+			t.Errorf("Lookup(%q) = (0x%x, %v), want (0x%x, %v)", tc.name, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestSplit_unicode(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []string
+	}{
+		{"U+1F399", []string{"U+1F399"}},
+		{"Control_L+U+20AC", []string{"Control_L", "U+20AC"}},
+		{"U+A", []string{"U", "A"}},
+		{"+", []string{"+"}},
+		{"Shift_L+§", []string{"Shift_L", "§"}},
+	}
+	for _, tc := range cases {
+		got := Split(tc.in)
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("Split(%q) = %v, want %v", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestName(t *testing.T) {
+	cases := []struct {
+		sym  uint32
+		want string
+		ok   bool
+	}{
+		{0xffe9, "Alt_L", true},
+		{0xab, "guillemetleft", true},       // not the deprecated guillemotleft
+		{0x1008ff12, "XF86AudioMute", true}, // not the bare AudioMute
+		{0x0101f399, "U+1F399", true},
+		{0x01000000, "", false},
+		{0xdeadbeef, "", false},
+	}
+	for _, tc := range cases {
+		got, ok := Name(tc.sym)
+		if (ok != tc.ok) || (got != tc.want) {
+			t.Errorf("Name(0x%x) = (%q, %v), want (%q, %v)", tc.sym, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestNames_roundTrip(t *testing.T) {
+	for sym, name := range keysymNames {
+		if got, ok := Lookup(name); !ok || (got != sym) {
+			t.Errorf("Lookup(Name(0x%x) = %q) = (0x%x, %v)", sym, name, got, ok)
+		}
+	}
+	for name, sym := range keysyms {
+		if _, ok := keysymNames[sym]; !ok {
+			t.Errorf("no canonical name for %q (0x%x)", name, sym)
+		}
+	}
+}
+
+func TestWithPrefix(t *testing.T) {
+	got := WithPrefix("XK_Alt_")
+	if want := []string{"Alt_L", "Alt_R"}; !slices.Equal(got, want) {
+		t.Errorf("WithPrefix(XK_Alt_) = %v, want %v", got, want)
+	}
+	if got := WithPrefix("alt_"); len(got) != 0 {
+		t.Errorf("WithPrefix(alt_) = %v, want none (case-sensitive)", got)
+	}
+	if got := WithPrefix(""); len(got) != len(keysyms) {
+		t.Errorf("WithPrefix(\"\") returned %d names, want %d", len(got), len(keysyms))
+	}
+}
+
+func TestSearch(t *testing.T) {
+	got := Search("audiomute")
+	if want := []string{"AudioMute", "XF86AudioMute"}; !slices.Equal(got, want) {
+		t.Errorf("Search(audiomute) = %v, want %v", got, want)
+	}
+	if got := Search("NotARealKeysym_XYZ"); len(got) != 0 {
+		t.Errorf("Search(NotARealKeysym_XYZ) = %v, want none", got)
+	}
+}
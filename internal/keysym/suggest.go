@@ -0,0 +1,141 @@
+package keysym
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// maxSuggestions limits how many names an unknown keysym error suggests.
+const maxSuggestions = 3
+
+// Valid checks that every name in a keysym sequence (see [Split]) is known.
+// Errors for unknown names suggest similar known ones.
+func Valid(keys string) error {
+	_, err := Parse(keys)
+	return err
+}
+
+// Parse resolves the names in a keysym sequence (see [Split]) to keysyms.
+// Errors for unknown names are as for [Valid].
+func Parse(keys string) ([]uint32, error) {
+	parts := Split(keys)
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("empty key sequence")
+	}
+	syms := make([]uint32, 0, len(parts))
+	for _, part := range parts {
+		sym, ok := Lookup(part)
+		if !ok {
+			return nil, UnknownError(part)
+		}
+		syms = append(syms, sym)
+	}
+	return syms, nil
+}
+
+// UnknownError returns the error for a name that [Lookup] rejected,
+// including suggestions if there are any.
+func UnknownError(name string) error {
+	sugg, folded := suggestKeysyms(name)
+	if len(sugg) == 0 {
+		return fmt.Errorf("unknown keysym %q", name)
+	}
+
+	quoted := make([]string, len(sugg))
+	for i, s := range sugg {
+		quoted[i] = fmt.Sprintf("%q", s)
+	}
+	list := quoted[0]
+	if len(quoted) > 1 {
+		list = strings.Join(quoted[:len(quoted)-1], ", ") + " or " + quoted[len(quoted)-1]
+	}
+
+	if folded {
+		return fmt.Errorf("unknown keysym %q (names are case-sensitive; did you mean %v?)", name, list)
+	}
+	return fmt.Errorf("unknown keysym %q (did you mean %v?)", name, list)
+}
+
+// suggestKeysyms returns known names close to name. If any names differ from
+// it only in case, those are returned and folded is true. Otherwise the names
+// within a small edit distance, ignoring case, are returned, closest first.
+func suggestKeysyms(name string) (sugg []string, folded bool) {
+	name = stripPrefix(strings.TrimSpace(name))
+	if name == "" {
+		return nil, false
+	}
+	lower := strings.ToLower(name)
+
+	for _, known := range sortedNames() {
+		if strings.ToLower(known) == lower {
+			sugg = append(sugg, known)
+		}
+	}
+	if len(sugg) > 0 {
+		return sugg[:min(len(sugg), maxSuggestions)], true
+	}
+
+	// Allow roughly one typo per three characters, but never so many that
+	// short names match nearly everything.
+	limit := min(3, max(1, len(lower)/3))
+
+	type match struct {
+		name string
+		dist int
+	}
+	var matches []match
+	for _, known := range sortedNames() {
+		if abs(len(known)-len(lower)) > limit {
+			continue
+		}
+		if d := editDistance(lower, strings.ToLower(known)); d <= limit {
+			matches = append(matches, match{name: known, dist: d})
+		}
+	}
+	slices.SortStableFunc(matches, func(m1, m2 match) int {
+		return cmp.Compare(m1.dist, m2.dist)
+	})
+
+	for _, m := range matches[:min(len(matches), maxSuggestions)] {
+		sugg = append(sugg, m.name)
+	}
+	return sugg, false
+}
+
+// editDistance returns the optimal string alignment distance between a and b:
+// the number of single-byte insertions, deletions, substitutions and
+// transpositions of adjacent bytes needed to turn one into the other.
+func editDistance(a, b string) int {
+	// Three rows of the full matrix are enough: transpositions look back two.
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if (i > 1) && (j > 1) && (a[i-1] == b[j-2]) && (a[i-2] == b[j-1]) {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
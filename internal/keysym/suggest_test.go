@@ -0,0 +1,74 @@
+package keysym
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestEditDistance(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"alt_l", "alt_l", 0},
+		{"alt_l", "alt_r", 1},
+		{"atl_l", "alt_l", 1}, // transposition
+		{"alt", "alt_l", 2},
+		{"kitten", "sitting", 3},
+	}
+	for _, tc := range cases {
+		if got := editDistance(tc.a, tc.b); got != tc.want {
+			t.Errorf("editDistance(%q, %q) = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+		if got := editDistance(tc.b, tc.a); got != tc.want {
+			t.Errorf("editDistance(%q, %q) = %v, want %v", tc.b, tc.a, got, tc.want)
+		}
+	}
+}
+
+func TestSuggestKeysyms(t *testing.T) {
+	cases := []struct {
+		name   string
+		want   []string
+		folded bool
+	}{
+		{"alt_l", []string{"Alt_L"}, true},
+		{"XK_ALT_R", []string{"Alt_R"}, true},
+		{"Atl_L", []string{"Alt_L"}, false},
+		{"Contrl_L", []string{"Control_L", "Control_R"}, false},
+		{"NotARealKeysym_XYZ", nil, false},
+		{"", nil, false},
+	}
+	for _, tc := range cases {
+		got, folded := suggestKeysyms(tc.name)
+		if !slices.Equal(got, tc.want) || (folded != tc.folded) {
+			t.Errorf("suggestKeysyms(%q) = (%v, %v), want (%v, %v)", tc.name, got, folded, tc.want, tc.folded)
+		}
+	}
+
+	if got, _ := suggestKeysyms("Alt_X"); len(got) > maxSuggestions {
+		t.Errorf("suggestKeysyms(Alt_X) returned %d names, want at most %d", len(got), maxSuggestions)
+	}
+}
+
+func TestValid(t *testing.T) {
+	if err := Valid("Control_L+Alt_L"); err != nil {
+		t.Errorf("Valid(Control_L+Alt_L): %v", err)
+	}
+	if err := Valid(""); err == nil {
+		t.Error("Valid(\"\"): expected error")
+	}
+
+	err := Valid("Control_L+alt_l")
+	if err == nil {
+		t.Fatal("Valid(Control_L+alt_l): expected error")
+	}
+	for _, want := range []string{`"alt_l"`, "case-sensitive", `did you mean "Alt_L"?`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package mumble
+
+import (
+	"encoding/xml"
+	"errors"
+	"net"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// testMumble is a stand-in for Mumble's RPC socket. It records the
+// commands it gets and answers with succeed.
+type testMumble struct {
+	path    string
+	succeed bool
+
+	m        sync.Mutex
+	commands []string
+}
+
+func (s *testMumble) start(t *testing.T) {
+	t.Helper()
+
+	s.path = filepath.Join(t.TempDir(), "MumbleSocket")
+	lis, err := net.Listen("unix", s.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lis.Close() })
+
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+}
+
+func (s *testMumble) serve(conn net.Conn) {
+	defer conn.Close()
+
+	var req struct {
+		XMLName xml.Name
+		Params  []struct {
+			XMLName xml.Name
+			Value   string `xml:",chardata"`
+		} `xml:",any"`
+	}
+	if err := xml.NewDecoder(conn).Decode(&req); err != nil {
+		return
+	}
+	s.m.Lock()
+	for _, p := range req.Params {
+		s.commands = append(s.commands, req.XMLName.Local+"/"+p.XMLName.Local+"="+p.Value)
+	}
+	s.m.Unlock()
+
+	succeeded := "false"
+	if s.succeed {
+		succeeded = "true"
+	}
+	conn.Write([]byte("<reply>\n <succeeded>" + succeeded + "</succeeded>\n</reply>\n"))
+
+	// Like Mumble, wait for the client to hang up.
+	conn.Read(make([]byte, 1))
+}
+
+func TestSend(t *testing.T) {
+	s := testMumble{succeed: true}
+	s.start(t)
+
+	if err := Send(s.path, StartTalking); err != nil {
+		t.Fatal(err)
+	}
+	if err := Send(s.path, StopTalking); err != nil {
+		t.Fatal(err)
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	want := []string{"self/starttalking=starttalking", "self/stoptalking=stoptalking"}
+	if !slices.Equal(s.commands, want) {
+		t.Errorf("commands = %q, want %q", s.commands, want)
+	}
+}
+
+func TestSend_failed(t *testing.T) {
+	s := testMumble{succeed: false}
+	s.start(t)
+
+	if err := Send(s.path, StartTalking); !errors.Is(err, ErrFailed) {
+		t.Errorf("Send = %v, want ErrFailed", err)
+	}
+}
+
+func TestSend_notRunning(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "MumbleSocket")
+	if err := Send(path, StartTalking); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("Send without a socket = %v, want ErrNotRunning", err)
+	}
+
+	// A socket left behind by a Mumble that crashed.
+	lis, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis.SetUnlinkOnClose(false)
+	lis.Close()
+	if err := Send(path, StartTalking); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("Send to a stale socket = %v, want ErrNotRunning", err)
+	}
+}
+
+func TestSocketPath(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", dir)
+	got, err := SocketPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "MumbleSocket"); got != want {
+		t.Errorf("SocketPath = %q, want %q", got, want)
+	}
+
+	t.Setenv("XDG_RUNTIME_DIR", filepath.Join(dir, "missing"))
+	t.Setenv("HOME", dir)
+	got, err = SocketPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, ".MumbleSocket"); got != want {
+		t.Errorf("SocketPath without a runtime dir = %q, want %q", got, want)
+	}
+}
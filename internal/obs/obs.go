@@ -0,0 +1,270 @@
+// Package obs is a small client for the OBS Studio WebSocket protocol,
+// version 5, which OBS 28 and later have built in. It implements only the
+// requests that ptt-fix needs.
+package obs
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultPort is the port that OBS listens on unless configured
+	// otherwise.
+	DefaultPort = "4455"
+
+	// rpcVersion is the RPC version that is asked for.
+	rpcVersion = 1
+
+	// requestTimeout limits how long a request may wait for its
+	// response, and the handshake for its messages.
+	requestTimeout = 5 * time.Second
+
+	// closeAuthFailed is the close code OBS uses for a wrong password.
+	closeAuthFailed = 4009
+)
+
+// Message opcodes.
+const (
+	opHello           = 0
+	opIdentify        = 1
+	opIdentified      = 2
+	opRequest         = 6
+	opRequestResponse = 7
+)
+
+// ErrAuth is returned if OBS rejects the password or needs one that wasn't
+// given.
+var ErrAuth = errors.New("obs websocket authentication failed")
+
+// Error is a failed request status.
+type Error struct {
+	Code    int
+	Comment string
+}
+
+// Request status codes that callers may want to check for, from the
+// protocol documentation.
+var (
+	ErrNotFound = &Error{Code: 600}
+)
+
+func (err *Error) Error() string {
+	if err.Comment == "" {
+		return fmt.Sprintf("request failed with status %v", err.Code)
+	}
+	return fmt.Sprintf("request failed with status %v: %v", err.Code, err.Comment)
+}
+
+// Is reports whether target is an *Error with the same code.
+func (err *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && (t.Code == err.Code)
+}
+
+type message struct {
+	Op int             `json:"op"`
+	D  json.RawMessage `json:"d"`
+}
+
+type hello struct {
+	RPCVersion     int `json:"rpcVersion"`
+	Authentication *struct {
+		Challenge string `json:"challenge"`
+		Salt      string `json:"salt"`
+	} `json:"authentication"`
+}
+
+type identify struct {
+	RPCVersion         int    `json:"rpcVersion"`
+	Authentication     string `json:"authentication,omitempty"`
+	EventSubscriptions int    `json:"eventSubscriptions"`
+}
+
+type request struct {
+	RequestType string `json:"requestType"`
+	RequestID   string `json:"requestId"`
+	RequestData any    `json:"requestData,omitempty"`
+}
+
+type requestResponse struct {
+	RequestID     string `json:"requestId"`
+	RequestStatus struct {
+		Result  bool   `json:"result"`
+		Code    int    `json:"code"`
+		Comment string `json:"comment"`
+	} `json:"requestStatus"`
+}
+
+// Client is a connection to OBS. Its methods may be called concurrently,
+// but requests are sent one at a time.
+type Client struct {
+	m    sync.Mutex
+	ws   *wsConn
+	next uint64
+}
+
+// Dial connects to OBS at addr, which is a ws:// URL or a host:port, and
+// identifies, authenticating with password if OBS asks for it. No events
+// are subscribed to.
+func Dial(addr, password string) (*Client, error) {
+	u, err := parseURL(addr)
+	if err != nil {
+		return nil, fmt.Errorf("parse obs address: %w", err)
+	}
+	conn, err := net.DialTimeout("tcp", u.Host, requestTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("connect to obs: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	ws, err := wsDial(conn, u, "obswebsocket.json")
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("connect to obs: %w", err)
+	}
+	if err := identifyWith(ws, password); err != nil {
+		ws.Close()
+		return nil, fmt.Errorf("identify with obs: %w", err)
+	}
+	conn.SetDeadline(time.Time{})
+	return &Client{ws: ws}, nil
+}
+
+// identifyWith answers the server's Hello and waits until it is identified.
+func identifyWith(ws *wsConn, password string) error {
+	var h hello
+	if err := readMessage(ws, opHello, &h); err != nil {
+		return err
+	}
+
+	id := identify{RPCVersion: rpcVersion}
+	if h.Authentication != nil {
+		if password == "" {
+			return fmt.Errorf("%w: obs requires a password", ErrAuth)
+		}
+		id.Authentication = authResponse(password, h.Authentication.Salt, h.Authentication.Challenge)
+	}
+	if err := writeMessage(ws, opIdentify, id); err != nil {
+		return err
+	}
+
+	err := readMessage(ws, opIdentified, nil)
+	var cerr *CloseError
+	if errors.As(err, &cerr) && (cerr.Code == closeAuthFailed) {
+		return ErrAuth
+	}
+	return err
+}
+
+// authResponse computes the authentication string for password from the
+// server's salt and challenge.
+func authResponse(password, salt, challenge string) string {
+	secret := sha256.Sum256([]byte(password + salt))
+	auth := sha256.Sum256([]byte(base64.StdEncoding.EncodeToString(secret[:]) + challenge))
+	return base64.StdEncoding.EncodeToString(auth[:])
+}
+
+func writeMessage(ws *wsConn, op int, d any) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	msg, err := json.Marshal(message{Op: op, D: data})
+	if err != nil {
+		return err
+	}
+	return ws.WriteText(msg)
+}
+
+// readMessage reads the next message, which must have the given opcode,
+// and decodes its data into d unless d is nil.
+func readMessage(ws *wsConn, op int, d any) error {
+	data, err := ws.ReadMessage()
+	if err != nil {
+		return err
+	}
+	var msg message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return fmt.Errorf("decode message: %w", err)
+	}
+	if msg.Op != op {
+		return fmt.Errorf("got message with opcode %v, want %v", msg.Op, op)
+	}
+	if d == nil {
+		return nil
+	}
+	if err := json.Unmarshal(msg.D, d); err != nil {
+		return fmt.Errorf("decode message: %w", err)
+	}
+	return nil
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	return c.ws.Close()
+}
+
+// SetInputMute mutes or unmutes the input with the given name.
+func (c *Client) SetInputMute(name string, mute bool) error {
+	if name == "" {
+		return errors.New("missing input name")
+	}
+	err := c.request("SetInputMute", map[string]any{
+		"inputName": name,
+		"inputMute": mute,
+	})
+	if err != nil {
+		return fmt.Errorf("set mute on input %q: %w", name, err)
+	}
+	return nil
+}
+
+// request sends a request and waits for its response. Anything else that
+// the server sends in the meantime is ignored.
+func (c *Client) request(typ string, data any) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.next++
+	id := strconv.FormatUint(c.next, 10)
+
+	c.ws.conn.SetDeadline(time.Now().Add(requestTimeout))
+	defer c.ws.conn.SetDeadline(time.Time{})
+
+	err := writeMessage(c.ws, opRequest, request{RequestType: typ, RequestID: id, RequestData: data})
+	if err != nil {
+		return err
+	}
+	for {
+		msg, err := c.ws.ReadMessage()
+		if err != nil {
+			return err
+		}
+		var m message
+		if err := json.Unmarshal(msg, &m); err != nil {
+			return fmt.Errorf("decode message: %w", err)
+		}
+		if m.Op != opRequestResponse {
+			continue
+		}
+		var resp requestResponse
+		if err := json.Unmarshal(m.D, &resp); err != nil {
+			return fmt.Errorf("decode response: %w", err)
+		}
+		if resp.RequestID != id {
+			continue
+		}
+		if !resp.RequestStatus.Result {
+			return &Error{Code: resp.RequestStatus.Code, Comment: resp.RequestStatus.Comment}
+		}
+		return nil
+	}
+}
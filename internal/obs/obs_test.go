@@ -0,0 +1,235 @@
+package obs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// testServer is a stand-in for OBS that speaks just enough of the protocol
+// for Client. It has one input, "Mic/Aux".
+type testServer struct {
+	addr     string
+	password string
+
+	m     sync.Mutex
+	muted map[string]bool
+	conns []net.Conn
+}
+
+func newTestServer(t *testing.T, password string) *testServer {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := testServer{
+		addr:     lis.Addr().String(),
+		password: password,
+		muted:    map[string]bool{"Mic/Aux": false},
+	}
+	t.Cleanup(func() {
+		lis.Close()
+		s.closeConns()
+	})
+
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			s.m.Lock()
+			s.conns = append(s.conns, conn)
+			s.m.Unlock()
+			go s.serve(conn)
+		}
+	}()
+	return &s
+}
+
+// closeConns drops every client connection, as OBS does when it quits.
+func (s *testServer) closeConns() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.conns = nil
+}
+
+func (s *testServer) isMuted(name string) bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.muted[name]
+}
+
+func (s *testServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	req, err := http.ReadRequest(r)
+	if err != nil {
+		return
+	}
+	if req.Header.Get("Sec-WebSocket-Protocol") != "obswebsocket.json" {
+		fmt.Fprintf(conn, "HTTP/1.1 400 Bad Request\r\n\r\n")
+		return
+	}
+	fmt.Fprintf(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %v\r\nSec-WebSocket-Protocol: obswebsocket.json\r\n\r\n",
+		acceptKey(req.Header.Get("Sec-WebSocket-Key")))
+	ws := &wsConn{conn: conn, r: r}
+
+	h := map[string]any{"obsWebSocketVersion": "5.0.0", "rpcVersion": 1}
+	if s.password != "" {
+		h["authentication"] = map[string]string{"challenge": "challenge", "salt": "salt"}
+	}
+	writeMessage(ws, opHello, h)
+
+	var id identify
+	if err := readMessage(ws, opIdentify, &id); err != nil {
+		return
+	}
+	if (s.password != "") && (id.Authentication != authResponse(s.password, "salt", "challenge")) {
+		ws.writeFrame(opClose, append(binary.BigEndian.AppendUint16(nil, closeAuthFailed), "Authentication failed."...))
+		return
+	}
+	writeMessage(ws, opIdentified, map[string]int{"negotiatedRpcVersion": 1})
+
+	for {
+		var req struct {
+			RequestType string          `json:"requestType"`
+			RequestID   string          `json:"requestId"`
+			RequestData json.RawMessage `json:"requestData"`
+		}
+		if err := readMessage(ws, opRequest, &req); err != nil {
+			return
+		}
+
+		// An unrelated event and a response to someone else come
+		// first, which the client has to skip.
+		writeMessage(ws, 5, map[string]any{"eventType": "ExitStarted", "eventIntent": 1})
+		writeMessage(ws, opRequestResponse, map[string]any{
+			"requestType":   req.RequestType,
+			"requestId":     "other",
+			"requestStatus": map[string]any{"result": false, "code": 204},
+		})
+
+		status := map[string]any{"result": true, "code": 100}
+		switch req.RequestType {
+		case "SetInputMute":
+			var data struct {
+				InputName string `json:"inputName"`
+				InputMute bool   `json:"inputMute"`
+			}
+			json.Unmarshal(req.RequestData, &data)
+			s.m.Lock()
+			if _, ok := s.muted[data.InputName]; ok {
+				s.muted[data.InputName] = data.InputMute
+			} else {
+				status = map[string]any{"result": false, "code": 600, "comment": "No source was found by the name of `" + data.InputName + "`."}
+			}
+			s.m.Unlock()
+		default:
+			status = map[string]any{"result": false, "code": 204}
+		}
+		writeMessage(ws, opRequestResponse, map[string]any{
+			"requestType":   req.RequestType,
+			"requestId":     req.RequestID,
+			"requestStatus": status,
+		})
+	}
+}
+
+func TestClient(t *testing.T) {
+	for _, password := range []string{"", "hunter2"} {
+		s := newTestServer(t, password)
+
+		c, err := Dial(s.addr, password)
+		if err != nil {
+			t.Fatalf("Dial with password %q: %v", password, err)
+		}
+		if err := c.SetInputMute("Mic/Aux", true); err != nil {
+			t.Fatal(err)
+		}
+		if !s.isMuted("Mic/Aux") {
+			t.Error("input not muted")
+		}
+		if err := c.SetInputMute("Mic/Aux", false); err != nil {
+			t.Fatal(err)
+		}
+		if s.isMuted("Mic/Aux") {
+			t.Error("input not unmuted")
+		}
+
+		err = c.SetInputMute("Nonexistent", true)
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("SetInputMute on unknown input = %v, want ErrNotFound", err)
+		}
+		c.Close()
+	}
+}
+
+func TestDial_auth(t *testing.T) {
+	s := newTestServer(t, "hunter2")
+
+	for _, password := range []string{"", "wrong"} {
+		_, err := Dial("ws://"+s.addr, password)
+		if !errors.Is(err, ErrAuth) {
+			t.Errorf("Dial with password %q = %v, want ErrAuth", password, err)
+		}
+	}
+}
+
+func TestClient_lostConnection(t *testing.T) {
+	s := newTestServer(t, "")
+
+	c, err := Dial(s.addr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	s.closeConns()
+	err = c.SetInputMute("Mic/Aux", true)
+	if err == nil {
+		t.Fatal("SetInputMute after the server quit succeeded")
+	}
+	var rerr *Error
+	if errors.As(err, &rerr) {
+		t.Errorf("lost connection reported as request failure: %v", err)
+	}
+}
+
+func TestParseURL(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{"localhost", "ws://localhost:4455"},
+		{"localhost:4456", "ws://localhost:4456"},
+		{"ws://127.0.0.1", "ws://127.0.0.1:4455"},
+		{"ws://[::1]:4444/path", "ws://[::1]:4444/path"},
+	}
+	for _, tt := range tests {
+		u, err := parseURL(tt.addr)
+		if err != nil {
+			t.Errorf("parseURL(%q): %v", tt.addr, err)
+			continue
+		}
+		if u.String() != tt.want {
+			t.Errorf("parseURL(%q) = %v, want %v", tt.addr, u, tt.want)
+		}
+	}
+
+	if _, err := parseURL("wss://localhost"); err == nil {
+		t.Error("parseURL with wss succeeded")
+	}
+}
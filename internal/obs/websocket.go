@@ -0,0 +1,268 @@
+package obs
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Frame opcodes, from RFC 6455.
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// wsGUID is appended to the handshake key to compute the accept key.
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxMessageSize is the largest message that is accepted.
+const maxMessageSize = 16 * 1024 * 1024
+
+// CloseError is returned when the server closes the WebSocket connection.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (err *CloseError) Error() string {
+	if err.Reason == "" {
+		return fmt.Sprintf("connection closed with code %v", err.Code)
+	}
+	return fmt.Sprintf("connection closed with code %v: %v", err.Code, err.Reason)
+}
+
+// wsConn is a WebSocket connection that sends and receives whole messages.
+// It doesn't support extensions. Reading and writing aren't safe to do
+// concurrently with themselves.
+type wsConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	// client is true on the client side, whose frames must be masked.
+	client bool
+}
+
+// wsDial connects to a ws:// URL and performs the opening handshake,
+// asking for the given subprotocol.
+func wsDial(conn net.Conn, u *url.URL, protocol string) (*wsConn, error) {
+	var nonce [16]byte
+	rand.Read(nonce[:])
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        &url.URL{Path: u.Path, RawQuery: u.RawQuery},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Host:       u.Host,
+		Header: http.Header{
+			"Upgrade":                {"websocket"},
+			"Connection":             {"Upgrade"},
+			"Sec-WebSocket-Key":      {key},
+			"Sec-WebSocket-Version":  {"13"},
+			"Sec-WebSocket-Protocol": {protocol},
+		},
+	}
+	if req.URL.Path == "" {
+		req.URL.Path = "/"
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		return nil, fmt.Errorf("read handshake response: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("handshake failed: %v", resp.Status)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		return nil, errors.New("handshake failed: wrong accept key")
+	}
+	return &wsConn{conn: conn, r: r, client: true}, nil
+}
+
+// acceptKey returns the Sec-WebSocket-Accept value for key.
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// writeFrame writes a single frame with the FIN bit set.
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	buf := []byte{0x80 | opcode, 0}
+	var mask byte
+	if c.client {
+		mask = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		buf[1] = mask | byte(n)
+	case n <= 0xffff:
+		buf[1] = mask | 126
+		buf = binary.BigEndian.AppendUint16(buf, uint16(n))
+	default:
+		buf[1] = mask | 127
+		buf = binary.BigEndian.AppendUint64(buf, uint64(n))
+	}
+
+	if !c.client {
+		_, err := c.conn.Write(append(buf, payload...))
+		return err
+	}
+	var key [4]byte
+	rand.Read(key[:])
+	buf = append(buf, key[:]...)
+	start := len(buf)
+	buf = append(buf, payload...)
+	for i := range payload {
+		buf[start+i] ^= key[i%4]
+	}
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+// WriteText sends a text message.
+func (c *wsConn) WriteText(msg []byte) error {
+	return c.writeFrame(opText, msg)
+}
+
+// readFrame reads one frame, unmasking its payload if necessary.
+func (c *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.r, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = head[0]&0x80 != 0
+	opcode = head[0] & 0x0f
+	masked := head[1]&0x80 != 0
+
+	n := uint64(head[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > maxMessageSize {
+		return false, 0, nil, fmt.Errorf("frame of %v bytes is too large", n)
+	}
+
+	var key [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.r, key[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= key[i%4]
+		}
+	}
+	return fin, opcode, payload, nil
+}
+
+// ReadMessage returns the next data message, answering pings on the way. A
+// close from the other side is answered and returned as a *CloseError.
+func (c *wsConn) ReadMessage() ([]byte, error) {
+	var msg []byte
+	var started bool
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			cerr := CloseError{Code: 1005}
+			if len(payload) >= 2 {
+				cerr.Code = int(binary.BigEndian.Uint16(payload))
+				cerr.Reason = string(payload[2:])
+			}
+			c.writeFrame(opClose, payload[:min(len(payload), 2)])
+			return nil, &cerr
+		case opText, opBinary:
+			if started {
+				return nil, errors.New("new message in the middle of a fragmented one")
+			}
+			started = true
+		case opContinuation:
+			if !started {
+				return nil, errors.New("continuation frame without a message")
+			}
+		default:
+			return nil, fmt.Errorf("unknown opcode %v", opcode)
+		}
+
+		if len(msg)+len(payload) > maxMessageSize {
+			return nil, fmt.Errorf("message is larger than %v bytes", maxMessageSize)
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+// Close sends a normal close frame and closes the connection without
+// waiting for the answer.
+func (c *wsConn) Close() error {
+	c.writeFrame(opClose, binary.BigEndian.AppendUint16(nil, 1000))
+	return c.conn.Close()
+}
+
+// parseURL returns the ws:// URL for addr, which may also be a plain
+// host:port.
+func parseURL(addr string) (*url.URL, error) {
+	if !strings.Contains(addr, "://") {
+		addr = "ws://" + addr
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ws" {
+		return nil, fmt.Errorf("unsupported scheme %q, only ws is supported", u.Scheme)
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), DefaultPort)
+	}
+	return u, nil
+}
@@ -0,0 +1,228 @@
+// Package portal injects input through the RemoteDesktop interface of
+// xdg-desktop-portal, which reaches native Wayland clients as well as
+// XWayland ones.
+package portal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"deedles.dev/ptt-fix/internal/dbus"
+)
+
+const (
+	desktopName            = "org.freedesktop.portal.Desktop"
+	desktopPath            = "/org/freedesktop/portal/desktop"
+	remoteDesktopInterface = "org.freedesktop.portal.RemoteDesktop"
+	requestInterface       = "org.freedesktop.portal.Request"
+	sessionInterface       = "org.freedesktop.portal.Session"
+)
+
+// DeviceType is a set of device types to ask for.
+type DeviceType uint32
+
+const (
+	Keyboard DeviceType = 1
+	Pointer  DeviceType = 2
+)
+
+// closeTimeout limits how long Close waits for the portal.
+const closeTimeout = 5 * time.Second
+
+// persistUntilRevoked is the persist_mode that keeps the permission until
+// the user revokes it, so that a restore token can skip the dialog.
+const persistUntilRevoked = 2
+
+var (
+	// ErrCancelled is returned if the user dismissed the permission
+	// dialog.
+	ErrCancelled = errors.New("remote desktop request cancelled")
+
+	// ErrNotAllowed is returned if the session was started without a
+	// device type that an event needs.
+	ErrNotAllowed = errors.New("device type not allowed in remote desktop session")
+)
+
+// tokenCounter makes request and session tokens unique within the process.
+var tokenCounter atomic.Uint32
+
+// Session is a started remote desktop session.
+type Session struct {
+	conn         *dbus.Conn
+	handle       dbus.ObjectPath
+	devices      DeviceType
+	restoreToken string
+
+	closeOnce sync.Once
+}
+
+// Start creates and starts a remote desktop session for the given device
+// types. If restoreToken is from an earlier session, the portal may start
+// the new one without asking the user again. The user may otherwise take
+// arbitrarily long to answer, so ctx should only be cancelled on shutdown.
+func Start(ctx context.Context, conn *dbus.Conn, devices DeviceType, restoreToken string) (*Session, error) {
+	results, err := request(ctx, conn, "CreateSession", "a{sv}", map[string]dbus.Variant{
+		"session_handle_token": dbus.MakeVariant(newToken()),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create session: %w", err)
+	}
+	var handle dbus.ObjectPath
+	switch v := results["session_handle"].(type) {
+	case string:
+		handle = dbus.ObjectPath(v)
+	case dbus.ObjectPath:
+		handle = v
+	default:
+		return nil, fmt.Errorf("create session: no session handle in response")
+	}
+	s := Session{conn: conn, handle: handle}
+
+	opts := map[string]dbus.Variant{
+		"types":        dbus.MakeVariant(uint32(devices)),
+		"persist_mode": dbus.MakeVariant(uint32(persistUntilRevoked)),
+	}
+	if restoreToken != "" {
+		opts["restore_token"] = dbus.MakeVariant(restoreToken)
+	}
+	if _, err := request(ctx, conn, "SelectDevices", "oa{sv}", handle, opts); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("select devices: %w", err)
+	}
+
+	results, err = request(ctx, conn, "Start", "osa{sv}", handle, "", map[string]dbus.Variant{})
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("start session: %w", err)
+	}
+	if v, ok := results["devices"].(uint32); ok {
+		s.devices = DeviceType(v)
+	}
+	s.restoreToken, _ = results["restore_token"].(string)
+	return &s, nil
+}
+
+// request calls a portal method that answers through a Request object and
+// returns the results of its Response signal. The handle_token option is
+// added to the last argument, which must be the options.
+func request(ctx context.Context, conn *dbus.Conn, method string, sig dbus.Signature, args ...any) (map[string]any, error) {
+	token := newToken()
+	args[len(args)-1].(map[string]dbus.Variant)["handle_token"] = dbus.MakeVariant(token)
+
+	// Subscribe before calling, as the response may come before the
+	// reply. The path is checked afterwards, as very old portals
+	// don't use the path built from the token.
+	signals, stop, err := conn.Subscribe(ctx, dbus.Match{
+		Sender:    desktopName,
+		Interface: requestInterface,
+		Member:    "Response",
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+
+	reply, err := conn.Call(ctx, desktopName, desktopPath, remoteDesktopInterface, method, sig, args...)
+	if err != nil {
+		return nil, err
+	}
+	var handle dbus.ObjectPath
+	if len(reply) == 1 {
+		handle, _ = reply[0].(dbus.ObjectPath)
+	}
+	if handle == "" {
+		return nil, fmt.Errorf("unexpected reply %v", reply)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		case m := <-signals:
+			if m.Path != handle {
+				continue
+			}
+			if len(m.Body) != 2 {
+				return nil, fmt.Errorf("malformed response %v", m.Body)
+			}
+			code, _ := m.Body[0].(uint32)
+			results, _ := m.Body[1].(map[string]any)
+			switch code {
+			case 0:
+				return unwrapResults(results), nil
+			case 1:
+				return nil, ErrCancelled
+			default:
+				return nil, fmt.Errorf("request failed with response %v", code)
+			}
+		}
+	}
+}
+
+// unwrapResults replaces the variants in a vardict with their values.
+func unwrapResults(results map[string]any) map[string]any {
+	for k, v := range results {
+		if v, ok := v.(dbus.Variant); ok {
+			results[k] = v.Value
+		}
+	}
+	return results
+}
+
+func newToken() string {
+	return fmt.Sprintf("ptt_fix%v", tokenCounter.Add(1))
+}
+
+// Devices returns the device types that the user allowed.
+func (s *Session) Devices() DeviceType {
+	return s.devices
+}
+
+// RestoreToken returns the token to pass to a later [Start] to skip the
+// permission dialog, or "" if the portal didn't provide one. Each token can
+// only be used once.
+func (s *Session) RestoreToken() string {
+	return s.restoreToken
+}
+
+// Key presses or releases the key with the given evdev keycode.
+func (s *Session) Key(ctx context.Context, keycode int32, pressed bool) error {
+	if s.devices&Keyboard == 0 {
+		return fmt.Errorf("key %v: %w", keycode, ErrNotAllowed)
+	}
+	return s.notify(ctx, "NotifyKeyboardKeycode", keycode, pressed)
+}
+
+// Button presses or releases the pointer button with the given evdev code,
+// such as 0x110 for BTN_LEFT.
+func (s *Session) Button(ctx context.Context, button int32, pressed bool) error {
+	if s.devices&Pointer == 0 {
+		return fmt.Errorf("button %v: %w", button, ErrNotAllowed)
+	}
+	return s.notify(ctx, "NotifyPointerButton", button, pressed)
+}
+
+func (s *Session) notify(ctx context.Context, method string, code int32, pressed bool) error {
+	var state uint32
+	if pressed {
+		state = 1
+	}
+	_, err := s.conn.Call(ctx, desktopName, desktopPath, remoteDesktopInterface, method, "oa{sv}iu",
+		s.handle, map[string]dbus.Variant{}, code, state)
+	return err
+}
+
+// Close ends the session. It is safe to call more than once.
+func (s *Session) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+		defer cancel()
+		_, err = s.conn.Call(ctx, desktopName, s.handle, sessionInterface, "Close", "")
+	})
+	return err
+}
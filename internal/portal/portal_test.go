@@ -0,0 +1,229 @@
+package portal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"deedles.dev/ptt-fix/internal/dbus"
+)
+
+// startBus starts a private dbus-daemon for the test and returns its
+// address. The test is skipped if dbus-daemon isn't installed.
+func startBus(t *testing.T) string {
+	t.Helper()
+
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not found")
+	}
+	addr := "unix:path=" + filepath.Join(t.TempDir(), "bus")
+	cmd := exec.Command(daemon, "--session", "--nofork", "--nopidfile", "--address="+addr)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	for range 100 {
+		c, err := dbus.Dial(addr)
+		if err == nil {
+			c.Close()
+			return addr
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("dbus-daemon did not start")
+	return ""
+}
+
+// fakePortal is a stand-in for xdg-desktop-portal's RemoteDesktop interface.
+type fakePortal struct {
+	conn *dbus.Conn
+
+	// response is the response code for Start.
+	response uint32
+
+	m            sync.Mutex
+	types        uint32
+	restoreToken string
+	events       []string
+	closed       bool
+}
+
+func newFakePortal(t *testing.T, addr string) *fakePortal {
+	t.Helper()
+
+	conn, err := dbus.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err := conn.RequestName(context.Background(), desktopName); err != nil {
+		t.Fatal(err)
+	}
+
+	p := fakePortal{conn: conn}
+	calls := conn.Serve()
+	go func() {
+		for call := range calls {
+			p.handle(call)
+		}
+	}()
+	return &p
+}
+
+func (p *fakePortal) handle(call *dbus.Message) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	opts := func(i int) map[string]any {
+		o, _ := call.Body[i].(map[string]any)
+		return o
+	}
+	str := func(v any) string {
+		s, _ := v.(dbus.Variant).Value.(string)
+		return s
+	}
+
+	switch call.Interface + "." + call.Member {
+	case remoteDesktopInterface + ".CreateSession":
+		o := opts(0)
+		session := desktopPath + "/session/x/" + str(o["session_handle_token"])
+		p.respond(call, str(o["handle_token"]), 0, map[string]dbus.Variant{
+			"session_handle": dbus.MakeVariant(session),
+		})
+
+	case remoteDesktopInterface + ".SelectDevices":
+		o := opts(1)
+		p.types, _ = o["types"].(dbus.Variant).Value.(uint32)
+		if v, ok := o["restore_token"]; ok {
+			p.restoreToken = str(v)
+		}
+		p.respond(call, str(o["handle_token"]), 0, map[string]dbus.Variant{})
+
+	case remoteDesktopInterface + ".Start":
+		p.respond(call, str(opts(2)["handle_token"]), p.response, map[string]dbus.Variant{
+			"devices":       dbus.MakeVariant(p.types),
+			"restore_token": dbus.MakeVariant("new-token"),
+		})
+
+	case remoteDesktopInterface + ".NotifyKeyboardKeycode", remoteDesktopInterface + ".NotifyPointerButton":
+		name := strings.TrimPrefix(call.Member, "Notify")
+		p.events = append(p.events, fmt.Sprint(name, " ", call.Body[0], " ", call.Body[2], " ", call.Body[3]))
+		p.conn.Reply(call, "")
+
+	case sessionInterface + ".Close":
+		p.closed = true
+		p.conn.Reply(call, "")
+
+	default:
+		p.conn.ReplyError(call, "org.freedesktop.DBus.Error.UnknownMethod", call.Member)
+	}
+}
+
+// respond replies with a Request handle and then emits its Response, as the
+// portal does once the user has answered.
+func (p *fakePortal) respond(call *dbus.Message, token string, code uint32, results map[string]dbus.Variant) {
+	sender := strings.ReplaceAll(strings.TrimPrefix(call.Sender, ":"), ".", "_")
+	handle := dbus.ObjectPath(desktopPath + "/request/" + sender + "/" + token)
+	p.conn.Reply(call, "o", handle)
+	p.conn.Emit(handle, requestInterface, "Response", "ua{sv}", code, results)
+}
+
+func TestSession(t *testing.T) {
+	addr := startBus(t)
+	p := newFakePortal(t, addr)
+
+	conn, err := dbus.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	s, err := Start(ctx, conn, Keyboard, "old-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.RestoreToken() != "new-token" {
+		t.Errorf("RestoreToken = %q, want new-token", s.RestoreToken())
+	}
+	if s.Devices() != Keyboard {
+		t.Errorf("Devices = %v, want %v", s.Devices(), Keyboard)
+	}
+
+	if err := s.Key(ctx, 56, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Key(ctx, 56, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Button(ctx, 0x110, true); !errors.Is(err, ErrNotAllowed) {
+		t.Fatalf("Button without pointer access = %v, want ErrNotAllowed", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+	if p.restoreToken != "old-token" {
+		t.Errorf("portal got restore token %q, want old-token", p.restoreToken)
+	}
+	if p.types != uint32(Keyboard) {
+		t.Errorf("portal got types %v, want %v", p.types, Keyboard)
+	}
+	session := string(s.handle)
+	want := []string{
+		"KeyboardKeycode " + session + " 56 1",
+		"KeyboardKeycode " + session + " 56 0",
+	}
+	if strings.Join(p.events, "\n") != strings.Join(want, "\n") {
+		t.Errorf("events = %q, want %q", p.events, want)
+	}
+	if !p.closed {
+		t.Error("session not closed")
+	}
+}
+
+func TestStart_cancelled(t *testing.T) {
+	addr := startBus(t)
+	p := newFakePortal(t, addr)
+	p.m.Lock()
+	p.response = 1
+	p.m.Unlock()
+
+	conn, err := dbus.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = Start(ctx, conn, Keyboard|Pointer, "")
+	if !errors.Is(err, ErrCancelled) {
+		t.Fatalf("Start = %v, want ErrCancelled", err)
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+	if !p.closed {
+		t.Error("session not closed after cancelled start")
+	}
+}
@@ -0,0 +1,430 @@
+// Package pulse is a small client for the PulseAudio native protocol, as
+// served by PulseAudio itself and by pipewire-pulse. It implements only the
+// commands that ptt-fix needs, without cgo or libpulse.
+package pulse
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSource names the server's default source in place of a real
+// source name.
+const DefaultSource = "@DEFAULT_SOURCE@"
+
+const (
+	// protocolVersion is the protocol version that is asked for. It is
+	// what PulseAudio 4.0 and later speak, and without the shared memory
+	// flags in the upper bits, as they aren't used.
+	protocolVersion = 32
+
+	// cookieSize is the length of the authentication cookie.
+	cookieSize = 256
+
+	// requestTimeout limits how long a command may wait for its reply.
+	requestTimeout = 5 * time.Second
+
+	// controlChannel is the channel of command packets, as opposed to
+	// stream data.
+	controlChannel = 0xffffffff
+
+	// invalidIndex means "look the object up by name instead".
+	invalidIndex = 0xffffffff
+
+	// maxPacketSize is the largest packet that is accepted, as in
+	// pulsecore/pstream.c.
+	maxPacketSize = 16 * 1024 * 1024
+)
+
+// Commands, from pulsecore/native-common.h.
+const (
+	commandError              = 0
+	commandReply              = 2
+	commandAuth               = 8
+	commandSetClientName      = 9
+	commandCreateUploadStream = 15
+	commandFinishUploadStream = 17
+	commandPlaySample         = 18
+	commandRemoveSample       = 19
+	commandSetSourceMute      = 40
+)
+
+const (
+	// sampleS16LE is the sample format of signed 16-bit little-endian
+	// PCM, from pulse/sample.h.
+	sampleS16LE = 3
+
+	// channelMono is the position of the only channel of mono audio,
+	// from pulse/channelmap.h.
+	channelMono = 0
+
+	// volumeNorm is the volume that plays samples unchanged.
+	volumeNorm = 0x10000
+)
+
+// Error is an error reported by the server in reply to a command.
+type Error struct {
+	Code uint32
+}
+
+// Error codes that callers may want to check for, from pulse/def.h.
+var (
+	ErrAccess   = &Error{Code: 1}
+	ErrNoEntity = &Error{Code: 5}
+)
+
+var errorText = map[uint32]string{
+	1:  "access denied",
+	2:  "unknown command",
+	3:  "invalid argument",
+	4:  "entity exists",
+	5:  "no such entity",
+	6:  "connection refused",
+	7:  "protocol error",
+	8:  "timeout",
+	9:  "no authentication key",
+	10: "internal error",
+	11: "connection terminated",
+	12: "entity killed",
+	13: "invalid server",
+	17: "incompatible protocol version",
+	19: "not supported",
+	23: "not implemented",
+}
+
+func (err *Error) Error() string {
+	if text, ok := errorText[err.Code]; ok {
+		return text
+	}
+	return fmt.Sprintf("error %v", err.Code)
+}
+
+// Is reports whether target is an *Error with the same code.
+func (err *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && (t.Code == err.Code)
+}
+
+// Client is a connection to a server. Its methods may be called
+// concurrently, but commands are sent one at a time.
+type Client struct {
+	m    sync.Mutex
+	conn net.Conn
+	seq  uint32
+}
+
+// Dial connects to server and authenticates. server is in the same form as
+// $PULSE_SERVER, such as "unix:/run/user/1000/pulse/native" or
+// "tcp:localhost:4713". If it is empty, $PULSE_SERVER and then the usual
+// socket in $XDG_RUNTIME_DIR are used.
+func Dial(server string) (*Client, error) {
+	network, addr, err := serverAddress(server)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout(network, addr, requestTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("connect to pulse server: %w", err)
+	}
+
+	c := Client{conn: conn}
+	if err := c.auth(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("authenticate with pulse server: %w", err)
+	}
+	if err := c.setClientName("ptt-fix"); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("set pulse client name: %w", err)
+	}
+	return &c, nil
+}
+
+// serverAddress returns the address to dial for server. Only the first
+// entry of a list of servers is used.
+func serverAddress(server string) (network, addr string, err error) {
+	if server == "" {
+		server = os.Getenv("PULSE_SERVER")
+	}
+	server, _, _ = strings.Cut(strings.TrimSpace(server), " ")
+	if server == "" {
+		dir := os.Getenv("XDG_RUNTIME_DIR")
+		if dir == "" {
+			dir = fmt.Sprintf("/run/user/%v", os.Getuid())
+		}
+		return "unix", filepath.Join(dir, "pulse", "native"), nil
+	}
+
+	switch {
+	case strings.HasPrefix(server, "unix:"):
+		return "unix", strings.TrimPrefix(server, "unix:"), nil
+	case strings.HasPrefix(server, "/"):
+		return "unix", server, nil
+	case strings.HasPrefix(server, "tcp:"), strings.HasPrefix(server, "tcp4:"), strings.HasPrefix(server, "tcp6:"):
+		network, addr, _ := strings.Cut(server, ":")
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(strings.Trim(addr, "[]"), "4713")
+		}
+		return network, addr, nil
+	default:
+		return "", "", fmt.Errorf("unsupported pulse server address %q", server)
+	}
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// SetSourceMute mutes or unmutes the source with the given name, which may
+// be [DefaultSource].
+func (c *Client) SetSourceMute(name string, mute bool) error {
+	if name == "" {
+		return errors.New("missing source name")
+	}
+	_, err := c.request(commandSetSourceMute, func(w *tagWriter) {
+		w.u32(invalidIndex)
+		w.string(name)
+		w.bool(mute)
+	})
+	if err != nil {
+		return fmt.Errorf("set mute on source %q: %w", name, err)
+	}
+	return nil
+}
+
+// UploadSample stores mono signed 16-bit PCM at the given sample rate in the
+// server's sample cache under name, replacing any sample with that name, so
+// that it can be played with PlaySample. Samples stay in the cache after the
+// client disconnects, until they are removed with RemoveSample.
+func (c *Client) UploadSample(name string, rate uint32, pcm []int16) error {
+	if name == "" {
+		return errors.New("missing sample name")
+	}
+	if len(pcm) == 0 {
+		return errors.New("empty sample")
+	}
+
+	data := make([]byte, 0, 2*len(pcm))
+	for _, v := range pcm {
+		data = binary.LittleEndian.AppendUint16(data, uint16(v))
+	}
+
+	r, err := c.request(commandCreateUploadStream, func(w *tagWriter) {
+		w.string(name)
+		w.sampleSpec(sampleS16LE, 1, rate)
+		w.channelMap(channelMono)
+		w.u32(uint32(len(data)))
+		w.proplist(map[string]string{"media.name": name})
+	})
+	if err != nil {
+		return fmt.Errorf("upload sample %q: %w", name, err)
+	}
+	channel, err := r.u32()
+	if err != nil {
+		return fmt.Errorf("upload sample %q: read channel: %w", name, err)
+	}
+
+	if err := c.write(channel, data); err != nil {
+		return fmt.Errorf("upload sample %q: %w", name, err)
+	}
+	_, err = c.request(commandFinishUploadStream, func(w *tagWriter) {
+		w.u32(channel)
+	})
+	if err != nil {
+		return fmt.Errorf("upload sample %q: %w", name, err)
+	}
+	return nil
+}
+
+// PlaySample plays a sample from the server's sample cache on the default
+// sink. It returns once playing has started.
+func (c *Client) PlaySample(name string) error {
+	_, err := c.request(commandPlaySample, func(w *tagWriter) {
+		w.u32(invalidIndex)
+		w.string("")
+		w.u32(volumeNorm)
+		w.string(name)
+		w.proplist(nil)
+	})
+	if err != nil {
+		return fmt.Errorf("play sample %q: %w", name, err)
+	}
+	return nil
+}
+
+// RemoveSample removes a sample from the server's sample cache.
+func (c *Client) RemoveSample(name string) error {
+	_, err := c.request(commandRemoveSample, func(w *tagWriter) {
+		w.string(name)
+	})
+	if err != nil {
+		return fmt.Errorf("remove sample %q: %w", name, err)
+	}
+	return nil
+}
+
+func (c *Client) auth() error {
+	cookie := readCookie()
+	r, err := c.request(commandAuth, func(w *tagWriter) {
+		w.u32(protocolVersion)
+		w.arbitrary(cookie)
+	})
+	if err != nil {
+		return err
+	}
+	v, err := r.u32()
+	if err != nil {
+		return fmt.Errorf("read server version: %w", err)
+	}
+	if (v & 0xffff) < 13 {
+		// Older servers don't take a property list in SET_CLIENT_NAME.
+		return fmt.Errorf("server protocol version %v is too old", v&0xffff)
+	}
+	return nil
+}
+
+func (c *Client) setClientName(name string) error {
+	_, err := c.request(commandSetClientName, func(w *tagWriter) {
+		w.proplist(map[string]string{
+			"application.name":           name,
+			"application.process.binary": filepath.Base(os.Args[0]),
+		})
+	})
+	return err
+}
+
+// readCookie returns the authentication cookie from the first of the usual
+// files that has one. If none does, a cookie of zeroes is returned, which
+// servers that don't check it, such as pipewire-pulse, accept.
+func readCookie() []byte {
+	var paths []string
+	if path := os.Getenv("PULSE_COOKIE"); path != "" {
+		paths = append(paths, path)
+	}
+	if dir, err := os.UserConfigDir(); err == nil {
+		paths = append(paths, filepath.Join(dir, "pulse", "cookie"))
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".pulse-cookie"))
+	}
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if (err == nil) && (len(data) >= cookieSize) {
+			return data[:cookieSize]
+		}
+	}
+	return make([]byte, cookieSize)
+}
+
+// request sends a command with the arguments written by args and waits for
+// its reply. Anything else that the server sends in the meantime is ignored.
+func (c *Client) request(command uint32, args func(w *tagWriter)) (*tagReader, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.seq++
+	tag := c.seq
+
+	var w tagWriter
+	w.u32(command)
+	w.u32(tag)
+	if args != nil {
+		args(&w)
+	}
+
+	c.conn.SetDeadline(time.Now().Add(requestTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	if err := writePacket(c.conn, w.buf); err != nil {
+		return nil, err
+	}
+	for {
+		channel, payload, err := readPacket(c.conn)
+		if err != nil {
+			return nil, err
+		}
+		if channel != controlChannel {
+			continue
+		}
+
+		r := tagReader{buf: payload}
+		cmd, err := r.u32()
+		if err != nil {
+			return nil, fmt.Errorf("read command: %w", err)
+		}
+		t, err := r.u32()
+		if err != nil {
+			return nil, fmt.Errorf("read tag: %w", err)
+		}
+		if t != tag {
+			continue
+		}
+
+		switch cmd {
+		case commandReply:
+			return &r, nil
+		case commandError:
+			code, err := r.u32()
+			if err != nil {
+				return nil, fmt.Errorf("read error code: %w", err)
+			}
+			return nil, &Error{Code: code}
+		default:
+			return nil, fmt.Errorf("unexpected command %v in reply", cmd)
+		}
+	}
+}
+
+// write sends data on a stream's channel.
+func (c *Client) write(channel uint32, data []byte) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.conn.SetDeadline(time.Now().Add(requestTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	return writeChannelPacket(c.conn, channel, data)
+}
+
+// writePacket writes a command packet.
+func writePacket(w io.Writer, payload []byte) error {
+	return writeChannelPacket(w, controlChannel, payload)
+}
+
+// writeChannelPacket writes a packet on a channel. Packets start with a
+// descriptor of five 32-bit words: the payload length, the channel, a 64-bit
+// offset and flags, the last three of which are only used for stream data
+// and are left as zero, which means to append it.
+func writeChannelPacket(w io.Writer, channel uint32, payload []byte) error {
+	buf := make([]byte, 20, 20+len(payload))
+	binary.BigEndian.PutUint32(buf[0:], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:], channel)
+	buf = append(buf, payload...)
+	_, err := w.Write(buf)
+	return err
+}
+
+func readPacket(r io.Reader) (channel uint32, payload []byte, err error) {
+	var desc [20]byte
+	if _, err := io.ReadFull(r, desc[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(desc[0:])
+	if n > maxPacketSize {
+		return 0, nil, fmt.Errorf("packet of %v bytes is too large", n)
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return binary.BigEndian.Uint32(desc[4:]), payload, nil
+}
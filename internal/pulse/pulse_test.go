@@ -0,0 +1,382 @@
+package pulse
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// testServer is a stand-in for a pulse server that speaks just enough of the
+// native protocol for Client. It has one source, "mic", which is also the
+// default.
+type testServer struct {
+	addr string
+
+	m          sync.Mutex
+	cookie     []byte
+	clientName string
+	muted      map[string]bool
+	conns      []net.Conn
+
+	// uploads holds the data of upload streams by channel, and samples
+	// the finished ones by name.
+	uploads map[uint32]*upload
+	samples map[string][]byte
+	played  []string
+}
+
+type upload struct {
+	name   string
+	length uint32
+	data   []byte
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "native")
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := testServer{
+		addr:    "unix:" + path,
+		muted:   map[string]bool{"mic": false},
+		uploads: make(map[uint32]*upload),
+		samples: make(map[string][]byte),
+	}
+	t.Cleanup(func() {
+		lis.Close()
+		s.closeConns()
+	})
+
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			s.m.Lock()
+			s.conns = append(s.conns, conn)
+			s.m.Unlock()
+			go s.serve(conn)
+		}
+	}()
+	return &s
+}
+
+// closeConns drops every client connection, as a restarting server would.
+func (s *testServer) closeConns() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.conns = nil
+}
+
+func (s *testServer) isMuted(name string) bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.muted[name]
+}
+
+func (s *testServer) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		channel, payload, err := readPacket(conn)
+		if err != nil {
+			return
+		}
+		if channel != controlChannel {
+			s.m.Lock()
+			if u, ok := s.uploads[channel]; ok {
+				u.data = append(u.data, payload...)
+			}
+			s.m.Unlock()
+			continue
+		}
+		r := tagReader{buf: payload}
+		cmd, _ := r.u32()
+		tag, _ := r.u32()
+
+		reply, code := s.handle(cmd, &r)
+		var w tagWriter
+		if code != 0 {
+			w.u32(commandError)
+			w.u32(tag)
+			w.u32(code)
+		} else {
+			w.u32(commandReply)
+			w.u32(tag)
+			w.buf = append(w.buf, reply.buf...)
+		}
+
+		// Something unrelated first, which the client must skip.
+		var event tagWriter
+		event.u32(66) // SUBSCRIBE_EVENT
+		event.u32(0xffffffff)
+		writePacket(conn, event.buf)
+
+		if err := writePacket(conn, w.buf); err != nil {
+			return
+		}
+	}
+}
+
+func (s *testServer) handle(cmd uint32, r *tagReader) (reply tagWriter, code uint32) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	switch cmd {
+	case commandAuth:
+		if _, err := r.u32(); err != nil {
+			return reply, 7
+		}
+		cookie, err := r.arbitrary()
+		if err != nil {
+			return reply, 7
+		}
+		s.cookie = bytes.Clone(cookie)
+		reply.u32(protocolVersion)
+		return reply, 0
+
+	case commandSetClientName:
+		props, err := r.proplist()
+		if err != nil {
+			return reply, 7
+		}
+		s.clientName = props["application.name"]
+		reply.u32(3)
+		return reply, 0
+
+	case commandSetSourceMute:
+		idx, err := r.u32()
+		if (err != nil) || (idx != invalidIndex) {
+			return reply, 3
+		}
+		name, err := r.string()
+		if err != nil {
+			return reply, 7
+		}
+		mute, err := r.bool()
+		if err != nil {
+			return reply, 7
+		}
+		if name == DefaultSource {
+			name = "mic"
+		}
+		if _, ok := s.muted[name]; !ok {
+			return reply, 5
+		}
+		s.muted[name] = mute
+		return reply, 0
+
+	case commandCreateUploadStream:
+		name, err := r.string()
+		if err != nil {
+			return reply, 7
+		}
+		format, channels, _, err := r.sampleSpec()
+		if (err != nil) || (format != sampleS16LE) || (channels != 1) {
+			return reply, 3
+		}
+		if _, err := r.channelMap(); err != nil {
+			return reply, 7
+		}
+		length, err := r.u32()
+		if err != nil {
+			return reply, 7
+		}
+		if _, err := r.proplist(); err != nil {
+			return reply, 7
+		}
+		channel := uint32(len(s.uploads))
+		s.uploads[channel] = &upload{name: name, length: length}
+		reply.u32(channel)
+		reply.u32(length)
+		return reply, 0
+
+	case commandFinishUploadStream:
+		channel, err := r.u32()
+		if err != nil {
+			return reply, 7
+		}
+		u, ok := s.uploads[channel]
+		if !ok || (uint32(len(u.data)) != u.length) {
+			return reply, 3
+		}
+		delete(s.uploads, channel)
+		s.samples[u.name] = u.data
+		return reply, 0
+
+	case commandPlaySample:
+		if _, err := r.u32(); err != nil {
+			return reply, 7
+		}
+		if _, err := r.string(); err != nil {
+			return reply, 7
+		}
+		if _, err := r.u32(); err != nil {
+			return reply, 7
+		}
+		name, err := r.string()
+		if err != nil {
+			return reply, 7
+		}
+		if _, ok := s.samples[name]; !ok {
+			return reply, 5
+		}
+		s.played = append(s.played, name)
+		reply.u32(0)
+		return reply, 0
+
+	case commandRemoveSample:
+		name, err := r.string()
+		if err != nil {
+			return reply, 7
+		}
+		if _, ok := s.samples[name]; !ok {
+			return reply, 5
+		}
+		delete(s.samples, name)
+		return reply, 0
+
+	default:
+		return reply, 2
+	}
+}
+
+func TestClient(t *testing.T) {
+	cookie := bytes.Repeat([]byte{0xa5}, cookieSize)
+	cookiePath := filepath.Join(t.TempDir(), "cookie")
+	if err := os.WriteFile(cookiePath, cookie, 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PULSE_COOKIE", cookiePath)
+
+	s := newTestServer(t)
+	c, err := Dial(s.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if !bytes.Equal(s.cookie, cookie) {
+		t.Errorf("server got cookie %x, want %x", s.cookie, cookie)
+	}
+	if s.clientName != "ptt-fix" {
+		t.Errorf("client name = %q, want ptt-fix", s.clientName)
+	}
+
+	if err := c.SetSourceMute("mic", true); err != nil {
+		t.Fatal(err)
+	}
+	if !s.isMuted("mic") {
+		t.Fatal("mic not muted")
+	}
+	if err := c.SetSourceMute(DefaultSource, false); err != nil {
+		t.Fatal(err)
+	}
+	if s.isMuted("mic") {
+		t.Fatal("default source not unmuted")
+	}
+
+	err = c.SetSourceMute("nope", true)
+	if !errors.Is(err, ErrNoEntity) {
+		t.Fatalf("unknown source error = %v, want %v", err, ErrNoEntity)
+	}
+
+	// The connection is still usable after an error.
+	if err := c.SetSourceMute("mic", true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_samples(t *testing.T) {
+	s := newTestServer(t)
+	c, err := Dial(s.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := c.UploadSample("click", 48000, []int16{0, 0x1234, -2}); err != nil {
+		t.Fatal(err)
+	}
+	s.m.Lock()
+	data := s.samples["click"]
+	s.m.Unlock()
+	if want := []byte{0, 0, 0x34, 0x12, 0xfe, 0xff}; !bytes.Equal(data, want) {
+		t.Errorf("uploaded data = %x, want %x", data, want)
+	}
+
+	if err := c.PlaySample("click"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.PlaySample("nope"); !errors.Is(err, ErrNoEntity) {
+		t.Errorf("playing a missing sample = %v, want %v", err, ErrNoEntity)
+	}
+	s.m.Lock()
+	played := s.played
+	s.m.Unlock()
+	if !slices.Equal(played, []string{"click"}) {
+		t.Errorf("played = %q, want [click]", played)
+	}
+
+	if err := c.RemoveSample("click"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.PlaySample("click"); !errors.Is(err, ErrNoEntity) {
+		t.Errorf("playing a removed sample = %v, want %v", err, ErrNoEntity)
+	}
+}
+
+func TestServerAddress(t *testing.T) {
+	t.Setenv("PULSE_SERVER", "")
+	t.Setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+
+	tests := []struct {
+		server        string
+		network, addr string
+	}{
+		{"", "unix", "/run/user/1000/pulse/native"},
+		{"unix:/tmp/pulse", "unix", "/tmp/pulse"},
+		{"/tmp/pulse", "unix", "/tmp/pulse"},
+		{"tcp:localhost", "tcp", "localhost:4713"},
+		{"tcp:localhost:1234", "tcp", "localhost:1234"},
+		{"tcp6:[::1]", "tcp6", "[::1]:4713"},
+		{"unix:/a unix:/b", "unix", "/a"},
+	}
+	for _, tt := range tests {
+		network, addr, err := serverAddress(tt.server)
+		if err != nil {
+			t.Errorf("serverAddress(%q): %v", tt.server, err)
+			continue
+		}
+		if (network != tt.network) || (addr != tt.addr) {
+			t.Errorf("serverAddress(%q) = %v %v, want %v %v", tt.server, network, addr, tt.network, tt.addr)
+		}
+	}
+
+	t.Setenv("PULSE_SERVER", "unix:/env")
+	if _, addr, _ := serverAddress(""); addr != "/env" {
+		t.Errorf("serverAddress ignored $PULSE_SERVER, got %v", addr)
+	}
+
+	if _, _, err := serverAddress("{machine}unix:/x"); err == nil {
+		t.Error("expected error for unsupported address")
+	}
+}
+
+func TestDial_noServer(t *testing.T) {
+	_, err := Dial("unix:" + filepath.Join(t.TempDir(), "missing"))
+	if err == nil {
+		t.Fatal("expected error without a server")
+	}
+}
@@ -0,0 +1,224 @@
+package pulse
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Tags of the values in a tagstruct, the serialization that the native
+// protocol uses for command arguments and replies.
+const (
+	tagString     = 't'
+	tagStringNull = 'N'
+	tagU32        = 'L'
+	tagBoolTrue   = '1'
+	tagBoolFalse  = '0'
+	tagArbitrary  = 'x'
+	tagProplist   = 'P'
+	tagSampleSpec = 'a'
+	tagChannelMap = 'm'
+)
+
+// tagWriter builds a tagstruct.
+type tagWriter struct {
+	buf []byte
+}
+
+func (w *tagWriter) u32(v uint32) {
+	w.buf = append(w.buf, tagU32)
+	w.buf = binary.BigEndian.AppendUint32(w.buf, v)
+}
+
+// string writes s, or a null string if s is empty, which is how the protocol
+// marks optional names as unset.
+func (w *tagWriter) string(s string) {
+	if s == "" {
+		w.buf = append(w.buf, tagStringNull)
+		return
+	}
+	w.buf = append(w.buf, tagString)
+	w.buf = append(w.buf, s...)
+	w.buf = append(w.buf, 0)
+}
+
+func (w *tagWriter) bool(v bool) {
+	if v {
+		w.buf = append(w.buf, tagBoolTrue)
+		return
+	}
+	w.buf = append(w.buf, tagBoolFalse)
+}
+
+func (w *tagWriter) arbitrary(data []byte) {
+	w.buf = append(w.buf, tagArbitrary)
+	w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(len(data)))
+	w.buf = append(w.buf, data...)
+}
+
+func (w *tagWriter) sampleSpec(format, channels byte, rate uint32) {
+	w.buf = append(w.buf, tagSampleSpec, format, channels)
+	w.buf = binary.BigEndian.AppendUint32(w.buf, rate)
+}
+
+func (w *tagWriter) channelMap(positions ...byte) {
+	w.buf = append(w.buf, tagChannelMap, byte(len(positions)))
+	w.buf = append(w.buf, positions...)
+}
+
+// proplist writes string properties, sorted by key so that the encoding is
+// stable. As in libpulse, string values are stored with their terminating
+// NUL.
+func (w *tagWriter) proplist(props map[string]string) {
+	w.buf = append(w.buf, tagProplist)
+	keys := make([]string, 0, len(props))
+	for k := range props {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		v := props[k] + "\x00"
+		w.string(k)
+		w.u32(uint32(len(v)))
+		w.arbitrary([]byte(v))
+	}
+	w.buf = append(w.buf, tagStringNull)
+}
+
+// tagReader reads a tagstruct.
+type tagReader struct {
+	buf []byte
+}
+
+func (r *tagReader) tag(want byte) error {
+	if len(r.buf) == 0 {
+		return fmt.Errorf("want tag %q, got end of data", want)
+	}
+	if r.buf[0] != want {
+		return fmt.Errorf("want tag %q, got %q", want, r.buf[0])
+	}
+	r.buf = r.buf[1:]
+	return nil
+}
+
+func (r *tagReader) u32() (uint32, error) {
+	if err := r.tag(tagU32); err != nil {
+		return 0, err
+	}
+	if len(r.buf) < 4 {
+		return 0, fmt.Errorf("truncated u32")
+	}
+	v := binary.BigEndian.Uint32(r.buf)
+	r.buf = r.buf[4:]
+	return v, nil
+}
+
+// string reads a string, returning "" for a null string.
+func (r *tagReader) string() (string, error) {
+	if (len(r.buf) > 0) && (r.buf[0] == tagStringNull) {
+		r.buf = r.buf[1:]
+		return "", nil
+	}
+	if err := r.tag(tagString); err != nil {
+		return "", err
+	}
+	i := bytes.IndexByte(r.buf, 0)
+	if i < 0 {
+		return "", fmt.Errorf("unterminated string")
+	}
+	s := string(r.buf[:i])
+	r.buf = r.buf[i+1:]
+	return s, nil
+}
+
+func (r *tagReader) bool() (bool, error) {
+	if len(r.buf) == 0 {
+		return false, fmt.Errorf("want boolean, got end of data")
+	}
+	switch r.buf[0] {
+	case tagBoolTrue:
+		r.buf = r.buf[1:]
+		return true, nil
+	case tagBoolFalse:
+		r.buf = r.buf[1:]
+		return false, nil
+	default:
+		return false, fmt.Errorf("want boolean, got tag %q", r.buf[0])
+	}
+}
+
+func (r *tagReader) arbitrary() ([]byte, error) {
+	if err := r.tag(tagArbitrary); err != nil {
+		return nil, err
+	}
+	if len(r.buf) < 4 {
+		return nil, fmt.Errorf("truncated arbitrary length")
+	}
+	n := binary.BigEndian.Uint32(r.buf)
+	r.buf = r.buf[4:]
+	if uint32(len(r.buf)) < n {
+		return nil, fmt.Errorf("truncated arbitrary data")
+	}
+	data := r.buf[:n:n]
+	r.buf = r.buf[n:]
+	return data, nil
+}
+
+func (r *tagReader) sampleSpec() (format, channels byte, rate uint32, err error) {
+	if err := r.tag(tagSampleSpec); err != nil {
+		return 0, 0, 0, err
+	}
+	if len(r.buf) < 6 {
+		return 0, 0, 0, fmt.Errorf("truncated sample spec")
+	}
+	format, channels = r.buf[0], r.buf[1]
+	rate = binary.BigEndian.Uint32(r.buf[2:])
+	r.buf = r.buf[6:]
+	return format, channels, rate, nil
+}
+
+func (r *tagReader) channelMap() ([]byte, error) {
+	if err := r.tag(tagChannelMap); err != nil {
+		return nil, err
+	}
+	if len(r.buf) < 1 {
+		return nil, fmt.Errorf("truncated channel map")
+	}
+	n := int(r.buf[0])
+	if len(r.buf) < 1+n {
+		return nil, fmt.Errorf("truncated channel map")
+	}
+	positions := r.buf[1 : 1+n : 1+n]
+	r.buf = r.buf[1+n:]
+	return positions, nil
+}
+
+func (r *tagReader) proplist() (map[string]string, error) {
+	if err := r.tag(tagProplist); err != nil {
+		return nil, err
+	}
+	props := make(map[string]string)
+	for {
+		k, err := r.string()
+		if err != nil {
+			return nil, err
+		}
+		if k == "" {
+			return props, nil
+		}
+		n, err := r.u32()
+		if err != nil {
+			return nil, err
+		}
+		v, err := r.arbitrary()
+		if err != nil {
+			return nil, err
+		}
+		if uint32(len(v)) != n {
+			return nil, fmt.Errorf("property %q has length %v, want %v", k, len(v), n)
+		}
+		props[k] = strings.TrimSuffix(string(v), "\x00")
+	}
+}
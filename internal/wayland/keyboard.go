@@ -0,0 +1,345 @@
+package wayland
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	seatInterface            = "wl_seat"
+	keyboardManagerInterface = "zwp_virtual_keyboard_manager_v1"
+
+	managerCreateVirtualKeyboard = 0
+
+	keyboardKeymap    = 0
+	keyboardKey       = 1
+	keyboardModifiers = 2
+	keyboardDestroy   = 3
+
+	// keymapFormatXKB is WL_KEYBOARD_KEYMAP_FORMAT_XKB_V1.
+	keymapFormatXKB = 1
+)
+
+// ErrUnsupported is returned if the compositor doesn't offer the virtual
+// keyboard protocol, as GNOME and KDE don't.
+var ErrUnsupported = errors.New("compositor does not support " + keyboardManagerInterface)
+
+// Keyboard is a virtual keyboard. Key codes are evdev codes, interpreted
+// through the keymap it was created with.
+type Keyboard struct {
+	c     *conn
+	id    uint32
+	start time.Time
+
+	m    sync.Mutex
+	err  error
+	done chan struct{}
+}
+
+// NewKeyboard connects to the compositor in $WAYLAND_DISPLAY and creates a
+// virtual keyboard on its first seat that uses keymap, in XKB text format
+// (see [Keymap]).
+func NewKeyboard(keymap string) (*Keyboard, error) {
+	path, err := socketPath()
+	if err != nil {
+		return nil, err
+	}
+	return newKeyboard(path, keymap)
+}
+
+func newKeyboard(path, keymap string) (*Keyboard, error) {
+	c, err := dial(path)
+	if err != nil {
+		return nil, err
+	}
+	k, err := setup(c, keymap)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return k, nil
+}
+
+func setup(c *conn, keymap string) (*Keyboard, error) {
+	registry := c.newID()
+	var m message
+	m.uint(registry)
+	if err := c.send(displayID, displayGetRegistry, m); err != nil {
+		return nil, err
+	}
+
+	// Only version 1 of anything is needed, which every global has.
+	globals := make(map[string]uint32)
+	err := c.roundtrip(func(ev event) error {
+		if (ev.object != registry) || (ev.opcode != registryGlobal) {
+			return nil
+		}
+		name, err := ev.uint()
+		if err != nil {
+			return err
+		}
+		iface, err := ev.string()
+		if err != nil {
+			return err
+		}
+		if _, ok := globals[iface]; !ok {
+			globals[iface] = name
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list wayland globals: %w", err)
+	}
+
+	seat, ok := globals[seatInterface]
+	if !ok {
+		return nil, errors.New("compositor has no seat")
+	}
+	manager, ok := globals[keyboardManagerInterface]
+	if !ok {
+		return nil, ErrUnsupported
+	}
+
+	bind := func(name uint32, iface string) (uint32, error) {
+		id := c.newID()
+		var m message
+		m.uint(name)
+		m.string(iface)
+		m.uint(1)
+		m.uint(id)
+		return id, c.send(registry, registryBind, m)
+	}
+	seatID, err := bind(seat, seatInterface)
+	if err != nil {
+		return nil, err
+	}
+	managerID, err := bind(manager, keyboardManagerInterface)
+	if err != nil {
+		return nil, err
+	}
+
+	k := Keyboard{c: c, id: c.newID(), start: time.Now(), done: make(chan struct{})}
+	m = message{}
+	m.uint(seatID)
+	m.uint(k.id)
+	if err := c.send(managerID, managerCreateVirtualKeyboard, m); err != nil {
+		return nil, err
+	}
+	if err := k.setKeymap(keymap); err != nil {
+		return nil, err
+	}
+
+	// Errors such as being unauthorized come back asynchronously, so
+	// wait for them here instead of in the first key press.
+	if err := c.roundtrip(nil); err != nil {
+		return nil, fmt.Errorf("create virtual keyboard: %w", err)
+	}
+
+	go k.read()
+	return &k, nil
+}
+
+// setKeymap uploads keymap through a memfd, as the protocol requires.
+func (k *Keyboard) setKeymap(keymap string) error {
+	data := append([]byte(keymap), 0)
+	fd, err := unix.MemfdCreate("ptt-fix-keymap", unix.MFD_CLOEXEC|unix.MFD_ALLOW_SEALING)
+	if err != nil {
+		return fmt.Errorf("create keymap file: %w", err)
+	}
+	defer unix.Close(fd)
+	for len(data) > 0 {
+		n, err := unix.Write(fd, data)
+		if err != nil {
+			return fmt.Errorf("write keymap: %w", err)
+		}
+		data = data[n:]
+	}
+	// The compositor maps it, so it must not be able to change size.
+	unix.FcntlInt(uintptr(fd), unix.F_ADD_SEALS, unix.F_SEAL_SHRINK|unix.F_SEAL_GROW|unix.F_SEAL_WRITE|unix.F_SEAL_SEAL)
+
+	var m message
+	m.uint(keymapFormatXKB)
+	m.fd(fd)
+	m.uint(uint32(len(keymap) + 1))
+	return k.c.send(k.id, keyboardKeymap, m)
+}
+
+// read drains events, which a keyboard doesn't need, until the connection
+// fails, so that the compositor's buffer for it never fills up. A protocol
+// error ends the connection and is kept for Key to return.
+func (k *Keyboard) read() {
+	defer close(k.done)
+	for {
+		ev, err := k.c.read()
+		if err == nil && (ev.object == displayID) && (ev.opcode == displayError) {
+			err = displayErr(ev)
+		}
+		if err != nil {
+			k.m.Lock()
+			if k.err == nil {
+				k.err = err
+			}
+			k.m.Unlock()
+			k.c.Close()
+			return
+		}
+	}
+}
+
+// Key presses or releases the key with the given evdev code.
+func (k *Keyboard) Key(code uint32, pressed bool) error {
+	if err := k.check(); err != nil {
+		return err
+	}
+
+	var state uint32
+	if pressed {
+		state = 1
+	}
+	var m message
+	m.uint(uint32(time.Since(k.start).Milliseconds()))
+	m.uint(code)
+	m.uint(state)
+	if err := k.c.send(k.id, keyboardKey, m); err != nil {
+		return fmt.Errorf("send key: %w", err)
+	}
+	return nil
+}
+
+// Modifiers tells the compositor which modifiers are held, as a mask of
+// real modifiers such as [ModifierMask] returns. Pressing a modifier key
+// with Key doesn't do that by itself.
+func (k *Keyboard) Modifiers(depressed uint32) error {
+	if err := k.check(); err != nil {
+		return err
+	}
+
+	var m message
+	m.uint(depressed)
+	m.uint(0) // latched
+	m.uint(0) // locked
+	m.uint(0) // group
+	if err := k.c.send(k.id, keyboardModifiers, m); err != nil {
+		return fmt.Errorf("send modifiers: %w", err)
+	}
+	return nil
+}
+
+// check returns the error that ended the connection, if any.
+func (k *Keyboard) check() error {
+	k.m.Lock()
+	err := k.err
+	k.m.Unlock()
+	if errors.Is(err, ErrClosed) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrClosed, err)
+	}
+	return nil
+}
+
+// Close destroys the keyboard and disconnects. Keys that are still held
+// are released by the compositor.
+func (k *Keyboard) Close() error {
+	k.m.Lock()
+	alive := k.err == nil
+	if alive {
+		k.err = ErrClosed
+	}
+	k.m.Unlock()
+	if alive {
+		k.c.send(k.id, keyboardDestroy, message{})
+	}
+	err := k.c.Close()
+	<-k.done
+	return err
+}
+
+const (
+	// KeymapBase is the evdev code of the first key in keymaps made by
+	// [Keymap]. It is KEY_F13, which is rarely bound to anything.
+	KeymapBase = 183
+
+	// MaxKeymapKeys is the most keys that a keymap made by [Keymap] can
+	// have, as XKB keycodes end at 255.
+	MaxKeymapKeys = 255 - 8 - KeymapBase + 1
+)
+
+// modifierKeys are the keysyms that hold a real modifier, and which one, as
+// in the usual XKB layouts. Locking keys such as Caps_Lock are left out.
+var modifierKeys = map[string]string{
+	"Shift_L":          "Shift",
+	"Shift_R":          "Shift",
+	"Control_L":        "Control",
+	"Control_R":        "Control",
+	"Alt_L":            "Mod1",
+	"Alt_R":            "Mod1",
+	"Meta_L":           "Mod1",
+	"Meta_R":           "Mod1",
+	"Super_L":          "Mod4",
+	"Super_R":          "Mod4",
+	"Hyper_L":          "Mod4",
+	"Hyper_R":          "Mod4",
+	"ISO_Level3_Shift": "Mod5",
+	"Mode_switch":      "Mod5",
+}
+
+// modifierMasks are the real modifiers in XKB's fixed order.
+var modifierMasks = map[string]uint32{
+	"Shift":   1 << 0,
+	"Lock":    1 << 1,
+	"Control": 1 << 2,
+	"Mod1":    1 << 3,
+	"Mod2":    1 << 4,
+	"Mod3":    1 << 5,
+	"Mod4":    1 << 6,
+	"Mod5":    1 << 7,
+}
+
+// ModifierMask returns the real modifier that the keysym named sym holds in
+// keymaps made by [Keymap], as a mask for [Keyboard.Modifiers], or 0 if it
+// isn't a modifier.
+func ModifierMask(sym string) uint32 {
+	return modifierMasks[modifierKeys[sym]]
+}
+
+// Keymap returns an XKB keymap in which the evdev code KeymapBase+i types
+// syms[i], which is a keysym name or a number such as "0x1008ff12". All
+// keys are on one level. Modifier keys, such as Control_L, hold their
+// modifier (see [ModifierMask]).
+func Keymap(syms []string) (string, error) {
+	if len(syms) > MaxKeymapKeys {
+		return "", fmt.Errorf("%v keys is more than the %v that fit in a keymap", len(syms), MaxKeymapKeys)
+	}
+
+	var b strings.Builder
+	b.WriteString("xkb_keymap {\n")
+	b.WriteString("xkb_keycodes \"ptt-fix\" {\n\tminimum = 8;\n\tmaximum = 255;\n")
+	for i := range syms {
+		fmt.Fprintf(&b, "\t<K%v> = %v;\n", i, KeymapBase+i+8)
+	}
+	b.WriteString("};\n")
+	b.WriteString("xkb_types \"ptt-fix\" {\n\ttype \"ONE_LEVEL\" {\n\t\tmodifiers = none;\n\t\tlevel_name[Level1] = \"Any\";\n\t};\n};\n")
+	// Modifier keys only set their modifier through an action, which
+	// this gives every key in a modifier_map.
+	b.WriteString("xkb_compatibility \"ptt-fix\" {\n")
+	b.WriteString("\tinterpret Any+AnyOf(all) {\n\t\taction = SetMods(modifiers=modMapMods,clearLocks);\n\t};\n")
+	b.WriteString("};\n")
+	b.WriteString("xkb_symbols \"ptt-fix\" {\n")
+	for i, sym := range syms {
+		fmt.Fprintf(&b, "\tkey <K%v> { [ %v ] };\n", i, sym)
+	}
+	for i, sym := range syms {
+		if mod, ok := modifierKeys[sym]; ok {
+			fmt.Fprintf(&b, "\tmodifier_map %v { <K%v> };\n", mod, i)
+		}
+	}
+	b.WriteString("};\n};\n")
+	return b.String(), nil
+}
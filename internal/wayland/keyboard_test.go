@@ -0,0 +1,329 @@
+package wayland
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// fakeCompositor is a stand-in for a compositor that offers the virtual
+// keyboard protocol. It serves one client.
+type fakeCompositor struct {
+	path string
+
+	// noManager leaves out the virtual keyboard manager global, and
+	// deny makes creating a keyboard fail with a protocol error.
+	noManager bool
+	deny      bool
+
+	m         sync.Mutex
+	keymap    string
+	events    []string
+	destroyed bool
+	done      chan struct{}
+}
+
+// start listens on a new socket, whose path is put in f.path.
+func (f *fakeCompositor) start(t *testing.T) {
+	t.Helper()
+
+	f.path = filepath.Join(t.TempDir(), "wayland-0")
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: f.path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	f.done = make(chan struct{})
+	go func() {
+		defer close(f.done)
+		c, err := l.AcceptUnix()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		f.serve(c)
+	}()
+}
+
+func (f *fakeCompositor) serve(c *net.UnixConn) {
+	ifaces := map[uint32]string{displayID: "wl_display"}
+	var fds []int
+	var buf []byte
+
+	send := func(object uint32, opcode uint16, m message) {
+		b := binary.NativeEndian.AppendUint32(nil, object)
+		b = binary.NativeEndian.AppendUint32(b, uint32(8+len(m.buf))<<16|uint32(opcode))
+		c.Write(append(b, m.buf...))
+	}
+
+	for {
+		data := make([]byte, maxMessageSize)
+		oob := make([]byte, unix.CmsgSpace(4*28))
+		n, oobn, _, _, err := c.ReadMsgUnix(data, oob)
+		if err != nil {
+			return
+		}
+		if msgs, err := unix.ParseSocketControlMessage(oob[:oobn]); err == nil {
+			for _, m := range msgs {
+				got, _ := unix.ParseUnixRights(&m)
+				fds = append(fds, got...)
+			}
+		}
+		buf = append(buf, data[:n]...)
+
+		for len(buf) >= 8 {
+			size := int(binary.NativeEndian.Uint32(buf[4:]) >> 16)
+			if len(buf) < size {
+				break
+			}
+			req := event{
+				object: binary.NativeEndian.Uint32(buf),
+				opcode: uint16(binary.NativeEndian.Uint32(buf[4:])),
+				args:   buf[8:size],
+			}
+			buf = buf[size:]
+
+			switch iface := ifaces[req.object]; {
+			case (iface == "wl_display") && (req.opcode == displaySync):
+				id, _ := req.uint()
+				var m message
+				m.uint(0)
+				send(id, callbackDone, m)
+
+			case (iface == "wl_display") && (req.opcode == displayGetRegistry):
+				id, _ := req.uint()
+				ifaces[id] = "wl_registry"
+				globals := []string{"wl_compositor", seatInterface}
+				if !f.noManager {
+					globals = append(globals, keyboardManagerInterface)
+				}
+				for i, g := range globals {
+					var m message
+					m.uint(uint32(i + 1))
+					m.string(g)
+					m.uint(1)
+					send(id, registryGlobal, m)
+				}
+
+			case (iface == "wl_registry") && (req.opcode == registryBind):
+				req.uint()
+				name, _ := req.string()
+				req.uint()
+				id, _ := req.uint()
+				ifaces[id] = name
+
+			case (iface == keyboardManagerInterface) && (req.opcode == managerCreateVirtualKeyboard):
+				req.uint()
+				id, _ := req.uint()
+				ifaces[id] = "zwp_virtual_keyboard_v1"
+				if f.deny {
+					var m message
+					m.uint(req.object)
+					m.uint(0)
+					m.string("not authorized")
+					send(displayID, displayError, m)
+					return
+				}
+
+			case (iface == "zwp_virtual_keyboard_v1") && (req.opcode == keyboardKeymap):
+				req.uint()
+				size, _ := req.uint()
+				data := make([]byte, size)
+				unix.Pread(fds[0], data, 0)
+				unix.Close(fds[0])
+				fds = fds[1:]
+				f.m.Lock()
+				f.keymap = string(data)
+				f.m.Unlock()
+
+			case (iface == "zwp_virtual_keyboard_v1") && (req.opcode == keyboardKey):
+				req.uint()
+				key, _ := req.uint()
+				state, _ := req.uint()
+				f.m.Lock()
+				f.events = append(f.events, fmt.Sprint(key, " ", state))
+				f.m.Unlock()
+
+			case (iface == "zwp_virtual_keyboard_v1") && (req.opcode == keyboardModifiers):
+				depressed, _ := req.uint()
+				latched, _ := req.uint()
+				locked, _ := req.uint()
+				group, _ := req.uint()
+				f.m.Lock()
+				f.events = append(f.events, fmt.Sprint("mods ", depressed, " ", latched, " ", locked, " ", group))
+				f.m.Unlock()
+
+			case (iface == "zwp_virtual_keyboard_v1") && (req.opcode == keyboardDestroy):
+				f.m.Lock()
+				f.destroyed = true
+				f.m.Unlock()
+			}
+		}
+	}
+}
+
+func TestKeyboard(t *testing.T) {
+	var f fakeCompositor
+	f.start(t)
+
+	keymap, err := Keymap([]string{"XF86AudioMicMute", "0x0100263a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := newKeyboard(f.path, keymap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Key(KeymapBase, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Key(KeymapBase+1, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Key(KeymapBase+1, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Key(KeymapBase, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Key(KeymapBase, true); !errors.Is(err, ErrClosed) {
+		t.Errorf("Key after Close = %v, want ErrClosed", err)
+	}
+
+	select {
+	case <-f.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("compositor did not see the client disconnect")
+	}
+
+	f.m.Lock()
+	defer f.m.Unlock()
+	if f.keymap != keymap+"\x00" {
+		t.Errorf("keymap = %q, want %q with a NUL", f.keymap, keymap)
+	}
+	want := []string{"183 1", "184 1", "184 0", "183 0"}
+	if strings.Join(f.events, ",") != strings.Join(want, ",") {
+		t.Errorf("events = %q, want %q", f.events, want)
+	}
+	if !f.destroyed {
+		t.Error("keyboard not destroyed")
+	}
+}
+
+func TestKeyboard_modifiers(t *testing.T) {
+	var f fakeCompositor
+	f.start(t)
+
+	// Control_L+a, as the sender presses it.
+	keymap, err := Keymap([]string{"Control_L", "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := newKeyboard(f.path, keymap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctrl := ModifierMask("Control_L")
+	for _, step := range []func() error{
+		func() error { return k.Key(KeymapBase, true) },
+		func() error { return k.Modifiers(ctrl) },
+		func() error { return k.Key(KeymapBase+1, true) },
+		func() error { return k.Key(KeymapBase+1, false) },
+		func() error { return k.Key(KeymapBase, false) },
+		func() error { return k.Modifiers(0) },
+	} {
+		if err := step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	k.Close()
+	<-f.done
+
+	f.m.Lock()
+	defer f.m.Unlock()
+	want := []string{"183 1", "mods 4 0 0 0", "184 1", "184 0", "183 0", "mods 0 0 0 0"}
+	if strings.Join(f.events, ",") != strings.Join(want, ",") {
+		t.Errorf("events = %q, want %q", f.events, want)
+	}
+	for _, want := range []string{"modifier_map Control { <K0> };", "SetMods(modifiers=modMapMods"} {
+		if !strings.Contains(f.keymap, want) {
+			t.Errorf("keymap does not contain %q:\n%v", want, f.keymap)
+		}
+	}
+}
+
+func TestModifierMask(t *testing.T) {
+	tests := map[string]uint32{
+		"Shift_R":          1 << 0,
+		"Control_L":        1 << 2,
+		"Alt_L":            1 << 3,
+		"Super_L":          1 << 6,
+		"ISO_Level3_Shift": 1 << 7,
+		"Caps_Lock":        0,
+		"a":                0,
+		"0x1008ff12":       0,
+	}
+	for sym, want := range tests {
+		if got := ModifierMask(sym); got != want {
+			t.Errorf("ModifierMask(%q) = %#x, want %#x", sym, got, want)
+		}
+	}
+}
+
+func TestNewKeyboard_unsupported(t *testing.T) {
+	f := fakeCompositor{noManager: true}
+	f.start(t)
+
+	_, err := newKeyboard(f.path, "")
+	if !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("newKeyboard = %v, want ErrUnsupported", err)
+	}
+}
+
+func TestNewKeyboard_denied(t *testing.T) {
+	f := fakeCompositor{deny: true}
+	f.start(t)
+
+	keymap, _ := Keymap([]string{"a"})
+	_, err := newKeyboard(f.path, keymap)
+	var perr *ProtocolError
+	if !errors.As(err, &perr) {
+		t.Fatalf("newKeyboard = %v, want a ProtocolError", err)
+	}
+	if perr.Message != "not authorized" {
+		t.Errorf("Message = %q, want not authorized", perr.Message)
+	}
+}
+
+func TestKeymap(t *testing.T) {
+	keymap, err := Keymap([]string{"Alt_L", "0x1008ff12"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"<K0> = 191;",
+		"<K1> = 192;",
+		"key <K0> { [ Alt_L ] };",
+		"key <K1> { [ 0x1008ff12 ] };",
+	} {
+		if !strings.Contains(keymap, want) {
+			t.Errorf("keymap does not contain %q:\n%v", want, keymap)
+		}
+	}
+
+	if _, err := Keymap(make([]string, MaxKeymapKeys+1)); err == nil {
+		t.Error("Keymap with too many keys succeeded")
+	}
+}
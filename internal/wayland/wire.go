@@ -0,0 +1,266 @@
+// Package wayland is a small Wayland client that injects key presses
+// through the virtual keyboard protocol (zwp_virtual_keyboard_manager_v1),
+// which wlroots-based compositors such as sway and Hyprland support. It
+// speaks the wire protocol directly, without libwayland.
+package wayland
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// displayID is the object ID of wl_display, which exists from the start.
+const displayID = 1
+
+// Opcodes of the requests and events that are used.
+const (
+	displaySync        = 0
+	displayGetRegistry = 1
+	displayError       = 0 // event
+	displayDeleteID    = 1 // event
+
+	registryBind   = 0
+	registryGlobal = 0 // event
+
+	callbackDone = 0 // event
+)
+
+// maxMessageSize is the largest message that the protocol allows.
+const maxMessageSize = 4096
+
+// ProtocolError is a fatal error that the compositor reported for a
+// request.
+type ProtocolError struct {
+	Object  uint32
+	Code    uint32
+	Message string
+}
+
+func (err *ProtocolError) Error() string {
+	return fmt.Sprintf("wayland protocol error %v on object %v: %v", err.Code, err.Object, err.Message)
+}
+
+// ErrClosed is returned once the connection has been closed or lost.
+var ErrClosed = errors.New("wayland connection closed")
+
+// conn is a connection to a compositor.
+type conn struct {
+	c      *net.UnixConn
+	nextID uint32
+
+	wm sync.Mutex // held while writing
+
+	// buf holds data that has been read but not yet decoded.
+	buf []byte
+}
+
+// socketPath returns the compositor's socket from $WAYLAND_DISPLAY, which
+// is "wayland-0" if unset and relative to $XDG_RUNTIME_DIR if not absolute.
+func socketPath() (string, error) {
+	name := os.Getenv("WAYLAND_DISPLAY")
+	if name == "" {
+		name = "wayland-0"
+	}
+	if filepath.IsAbs(name) {
+		return name, nil
+	}
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		return "", errors.New("$XDG_RUNTIME_DIR is not set")
+	}
+	return filepath.Join(dir, name), nil
+}
+
+func dial(path string) (*conn, error) {
+	c, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("connect to wayland compositor: %w", err)
+	}
+	return &conn{c: c, nextID: displayID + 1}, nil
+}
+
+func (c *conn) Close() error {
+	return c.c.Close()
+}
+
+// newID allocates a client object ID.
+func (c *conn) newID() uint32 {
+	id := c.nextID
+	c.nextID++
+	return id
+}
+
+// message builds the arguments of a request.
+type message struct {
+	buf []byte
+	fds []int
+}
+
+func (m *message) uint(v uint32) {
+	m.buf = binary.NativeEndian.AppendUint32(m.buf, v)
+}
+
+func (m *message) string(s string) {
+	m.uint(uint32(len(s) + 1))
+	m.buf = append(m.buf, s...)
+	m.buf = append(m.buf, 0)
+	for len(m.buf)%4 != 0 {
+		m.buf = append(m.buf, 0)
+	}
+}
+
+// fd passes a file descriptor alongside the message.
+func (m *message) fd(fd int) {
+	m.fds = append(m.fds, fd)
+}
+
+// send sends a request with the given arguments.
+func (c *conn) send(object uint32, opcode uint16, m message) error {
+	size := 8 + len(m.buf)
+	if size > maxMessageSize {
+		return fmt.Errorf("request of %v bytes is too large", size)
+	}
+	buf := make([]byte, 0, size)
+	buf = binary.NativeEndian.AppendUint32(buf, object)
+	buf = binary.NativeEndian.AppendUint32(buf, uint32(size)<<16|uint32(opcode))
+	buf = append(buf, m.buf...)
+
+	var oob []byte
+	if len(m.fds) > 0 {
+		oob = unix.UnixRights(m.fds...)
+	}
+
+	c.wm.Lock()
+	defer c.wm.Unlock()
+	_, _, err := c.c.WriteMsgUnix(buf, oob, nil)
+	return err
+}
+
+// event is a message from the compositor.
+type event struct {
+	object uint32
+	opcode uint16
+	args   []byte
+}
+
+func (ev *event) uint() (uint32, error) {
+	if len(ev.args) < 4 {
+		return 0, fmt.Errorf("truncated event")
+	}
+	v := binary.NativeEndian.Uint32(ev.args)
+	ev.args = ev.args[4:]
+	return v, nil
+}
+
+func (ev *event) string() (string, error) {
+	n, err := ev.uint()
+	if err != nil {
+		return "", err
+	}
+	padded := (int(n) + 3) &^ 3
+	if (n == 0) || (len(ev.args) < padded) {
+		return "", fmt.Errorf("truncated string in event")
+	}
+	s := string(ev.args[:n-1])
+	ev.args = ev.args[padded:]
+	return s, nil
+}
+
+// read returns the next event. File descriptors that come with events are
+// closed, as none of the events that are used carry any.
+func (c *conn) read() (event, error) {
+	for {
+		if len(c.buf) >= 8 {
+			size := int(binary.NativeEndian.Uint32(c.buf[4:]) >> 16)
+			if size < 8 {
+				return event{}, fmt.Errorf("invalid event size %v", size)
+			}
+			if len(c.buf) >= size {
+				ev := event{
+					object: binary.NativeEndian.Uint32(c.buf),
+					opcode: uint16(binary.NativeEndian.Uint32(c.buf[4:])),
+					args:   c.buf[8:size:size],
+				}
+				c.buf = c.buf[size:]
+				return ev, nil
+			}
+		}
+
+		buf := make([]byte, maxMessageSize)
+		oob := make([]byte, unix.CmsgSpace(4*28))
+		n, oobn, _, _, err := c.c.ReadMsgUnix(buf, oob)
+		if err != nil {
+			return event{}, err
+		}
+		closeFDs(oob[:oobn])
+		c.buf = append(c.buf, buf[:n]...)
+	}
+}
+
+func closeFDs(oob []byte) {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return
+	}
+	for _, m := range msgs {
+		fds, err := unix.ParseUnixRights(&m)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			unix.Close(fd)
+		}
+	}
+}
+
+// displayErr decodes a wl_display.error event.
+func displayErr(ev event) error {
+	var err ProtocolError
+	var derr error
+	if err.Object, derr = ev.uint(); derr != nil {
+		return derr
+	}
+	if err.Code, derr = ev.uint(); derr != nil {
+		return derr
+	}
+	if err.Message, derr = ev.string(); derr != nil {
+		return derr
+	}
+	return &err
+}
+
+// roundtrip waits until the compositor has handled every request sent so
+// far, passing each event that arrives in the meantime to handle. Protocol
+// errors are returned.
+func (c *conn) roundtrip(handle func(ev event) error) error {
+	callback := c.newID()
+	var m message
+	m.uint(callback)
+	if err := c.send(displayID, displaySync, m); err != nil {
+		return err
+	}
+
+	for {
+		ev, err := c.read()
+		if err != nil {
+			return err
+		}
+		switch {
+		case (ev.object == displayID) && (ev.opcode == displayError):
+			return displayErr(ev)
+		case (ev.object == callback) && (ev.opcode == callbackDone):
+			return nil
+		case handle != nil:
+			if err := handle(ev); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package xdo
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/xproto"
+)
+
+// x11SocketDir is where local X servers create their sockets.
+const x11SocketDir = "/tmp/.X11-unix"
+
+// displayPollInterval is how often WaitDisplay checks for the socket.
+const displayPollInterval = 250 * time.Millisecond
+
+// Xauthority address families, as in Xauth.h.
+const (
+	familyInternet  = 0
+	familyInternet6 = 6
+	familyLocal     = 256
+	familyWild      = 65535
+)
+
+// display is a parsed X display name such as ":0", "host:1.0" or
+// "/tmp/launch-12/org.xquartz:0".
+type display struct {
+	name    string // as given, for messages
+	network string // "unix" or "tcp"
+	addr    string // socket path or host:port
+	host    string // remote host, or "" for a local socket
+	number  string
+	screen  int
+}
+
+// parseDisplay parses name, or $DISPLAY if name is empty, the same way as
+// Xlib and xgb.
+func parseDisplay(name string) (display, error) {
+	if name == "" {
+		name = os.Getenv("DISPLAY")
+		if name == "" {
+			return display{}, errors.New("no display given and $DISPLAY is not set")
+		}
+	}
+	d := display{name: name}
+
+	colon := strings.LastIndex(name, ":")
+	if colon < 0 {
+		return display{}, fmt.Errorf("bad display name %q", name)
+	}
+	var protocol, socket string
+	if name[0] == '/' {
+		socket = name[:colon]
+	} else {
+		hostPart := name[:colon]
+		if slash := strings.LastIndex(hostPart, "/"); slash >= 0 {
+			protocol, hostPart = hostPart[:slash], hostPart[slash+1:]
+		}
+		if hostPart != "unix" {
+			d.host = hostPart
+		}
+	}
+
+	num, scr, _ := strings.Cut(name[colon+1:], ".")
+	n, err := strconv.Atoi(num)
+	if (err != nil) || (n < 0) {
+		return display{}, fmt.Errorf("bad display name %q", name)
+	}
+	d.number = num
+	if scr != "" {
+		d.screen, err = strconv.Atoi(scr)
+		if (err != nil) || (d.screen < 0) {
+			return display{}, fmt.Errorf("bad display name %q", name)
+		}
+	}
+
+	switch {
+	case socket != "":
+		d.network, d.addr = "unix", socket+":"+num
+	case d.host != "":
+		if protocol == "" {
+			protocol = "tcp"
+		}
+		d.network, d.addr = protocol, net.JoinHostPort(d.host, strconv.Itoa(6000+n))
+	default:
+		d.network, d.addr = "unix", filepath.Join(x11SocketDir, "X"+num)
+	}
+	return d, nil
+}
+
+// DisplayAvailable reports whether the socket of a local display exists.
+// Remote displays are always reported as available. name is as for
+// [OpenDisplay].
+func DisplayAvailable(name string) (bool, error) {
+	d, err := parseDisplay(name)
+	if err != nil {
+		return false, err
+	}
+	return d.available()
+}
+
+func (d display) available() (bool, error) {
+	if d.network != "unix" {
+		return true, nil
+	}
+	_, err := os.Stat(d.addr)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("check display socket: %w", err)
+	}
+	return true, nil
+}
+
+// WaitDisplay waits until [DisplayAvailable] reports the display as
+// available, so that connecting at login does not race the X server (or
+// XWayland) creating its socket. It returns early with an error when ctx is
+// done.
+func WaitDisplay(ctx context.Context, name string) error {
+	d, err := parseDisplay(name)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(displayPollInterval)
+	defer ticker.Stop()
+	for {
+		ok, err := d.available()
+		if (err != nil) || ok {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("wait for display %v: %w", d.name, context.Cause(ctx))
+		case <-ticker.C:
+		}
+	}
+}
+
+// dialDisplay connects to the display and performs the X handshake,
+// authenticating with a cookie from xauthority if given, or otherwise from
+// the first Xauthority file found by xauthorityFiles that has one.
+func dialDisplay(d display, xauthority string) (*xgb.Conn, error) {
+	cookie, err := findCookie(d, xauthority)
+	if err != nil {
+		return nil, err
+	}
+
+	nc, err := net.Dial(d.network, d.addr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to %v: %w", d.name, err)
+	}
+	var conn *xgb.Conn
+	if cookie != nil {
+		conn, err = xgb.NewConnNetWithCookieHex(nc, hex.EncodeToString(cookie))
+	} else {
+		// Lets xgb fall back to its own Xauthority lookup and then no
+		// authentication at all, as xgb.NewConn would.
+		conn, err = xgb.NewConnNet(nc)
+	}
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	// Everything after this indexes the screens with DefaultScreen, so
+	// a screen that doesn't exist has to be caught here.
+	if screens := len(xproto.Setup(conn).Roots); d.screen >= screens {
+		conn.Close()
+		return nil, fmt.Errorf("display %v has no screen %v, only %v", d.name, d.screen, screens)
+	}
+	conn.DisplayNumber, _ = strconv.Atoi(d.number)
+	conn.DefaultScreen = d.screen
+	return conn, nil
+}
+
+// findCookie returns the MIT-MAGIC-COOKIE-1 for d. An explicit xauthority
+// file must exist and contain one. Otherwise the usual locations are tried
+// and nil is returned if none has one.
+func findCookie(d display, xauthority string) ([]byte, error) {
+	if xauthority != "" {
+		cookie, err := readCookie(xauthority, d)
+		if err != nil {
+			return nil, fmt.Errorf("read Xauthority %v: %w", xauthority, err)
+		}
+		if cookie == nil {
+			return nil, fmt.Errorf("xauthority %v has no MIT-MAGIC-COOKIE-1 for display %v", xauthority, d.name)
+		}
+		return cookie, nil
+	}
+
+	for _, path := range xauthorityFiles() {
+		cookie, err := readCookie(path, d)
+		if (err == nil) && (cookie != nil) {
+			return cookie, nil
+		}
+	}
+	return nil, nil
+}
+
+// xauthorityFiles returns the Xauthority files to try, in order: $XAUTHORITY,
+// ~/.Xauthority, and the files that GNOME (Mutter) and KDE (KWin) create for
+// XWayland in $XDG_RUNTIME_DIR, which services started outside the session
+// often do not have $XAUTHORITY for.
+func xauthorityFiles() []string {
+	var files []string
+	if path := os.Getenv("XAUTHORITY"); path != "" {
+		files = append(files, path)
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		files = append(files, filepath.Join(home, ".Xauthority"))
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		for _, pattern := range []string{".mutter-Xwaylandauth.*", "xauth_*"} {
+			m, _ := filepath.Glob(filepath.Join(dir, pattern))
+			files = append(files, m...)
+		}
+	}
+	return files
+}
+
+// readCookie returns the first MIT-MAGIC-COOKIE-1 in the Xauthority file at
+// path that applies to d, or nil if there is none.
+func readCookie(path string, d display) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, addrs := xauthAddresses(d)
+	r := bytes.NewReader(data)
+	for {
+		var family uint16
+		err := binary.Read(r, binary.BigEndian, &family)
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var fields [4][]byte
+		for i := range fields {
+			fields[i], err = readXauthField(r)
+			if err != nil {
+				return nil, fmt.Errorf("truncated entry: %w", err)
+			}
+		}
+		addr, disp, name, cookie := fields[0], string(fields[1]), string(fields[2]), fields[3]
+
+		if (disp != "") && (disp != d.number) {
+			continue
+		}
+		if (name != "MIT-MAGIC-COOKIE-1") || (len(cookie) != 16) {
+			continue
+		}
+		switch family {
+		case familyWild:
+		case familyLocal:
+			if (hostname == "") || (string(addr) != hostname) {
+				continue
+			}
+		case familyInternet, familyInternet6:
+			if !containsIP(addrs, net.IP(addr)) {
+				continue
+			}
+		default:
+			continue
+		}
+		return cookie, nil
+	}
+}
+
+// xauthAddresses returns the hostname that FamilyLocal entries for d use, or
+// the addresses that FamilyInternet entries use for a remote display.
+func xauthAddresses(d display) (hostname string, addrs []net.IP) {
+	if (d.host == "") || (d.host == "localhost") {
+		hostname, _ = os.Hostname()
+		return hostname, nil
+	}
+	addrs, _ = net.LookupIP(d.host)
+	return "", addrs
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, v := range ips {
+		if v.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func readXauthField(r io.Reader) ([]byte, error) {
+	var n uint16
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
@@ -0,0 +1,241 @@
+package xdo
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jezek/xgb/xproto"
+)
+
+func TestParseDisplay(t *testing.T) {
+	cases := []struct {
+		name    string
+		network string
+		addr    string
+		number  string
+		screen  int
+	}{
+		{":0", "unix", "/tmp/.X11-unix/X0", "0", 0},
+		{":1.2", "unix", "/tmp/.X11-unix/X1", "1", 2},
+		{"unix:3", "unix", "/tmp/.X11-unix/X3", "3", 0},
+		{"host:2.1", "tcp", "host:6002", "2", 1},
+		{"tcp/host:1", "tcp", "host:6001", "1", 0},
+		{"/tmp/launch-12/org.xquartz:0", "unix", "/tmp/launch-12/org.xquartz:0", "0", 0},
+	}
+	for _, tc := range cases {
+		d, err := parseDisplay(tc.name)
+		if err != nil {
+			t.Errorf("parseDisplay(%q): %v", tc.name, err)
+			continue
+		}
+		if (d.network != tc.network) || (d.addr != tc.addr) || (d.number != tc.number) || (d.screen != tc.screen) {
+			t.Errorf("parseDisplay(%q) = %+v", tc.name, d)
+		}
+	}
+
+	for _, name := range []string{"0", ":", ":x", "host:-1", ":0.x"} {
+		if _, err := parseDisplay(name); err == nil {
+			t.Errorf("parseDisplay(%q): expected error", name)
+		}
+	}
+
+	t.Setenv("DISPLAY", ":7")
+	if d, err := parseDisplay(""); (err != nil) || (d.number != "7") {
+		t.Errorf("parseDisplay(\"\") with DISPLAY=:7 = %+v, %v", d, err)
+	}
+	t.Setenv("DISPLAY", "")
+	if _, err := parseDisplay(""); err == nil {
+		t.Error("parseDisplay(\"\") without DISPLAY: expected error")
+	}
+}
+
+// xauthEntry encodes one Xauthority file entry.
+func xauthEntry(family uint16, addr, disp string, cookie []byte) []byte {
+	var b []byte
+	b = binary.BigEndian.AppendUint16(b, family)
+	for _, f := range [][]byte{[]byte(addr), []byte(disp), []byte("MIT-MAGIC-COOKIE-1"), cookie} {
+		b = binary.BigEndian.AppendUint16(b, uint16(len(f)))
+		b = append(b, f...)
+	}
+	return b
+}
+
+func TestReadCookie(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skipf("no hostname: %v", err)
+	}
+	other := bytes.Repeat([]byte{1}, 16)
+	want := bytes.Repeat([]byte{2}, 16)
+
+	var file []byte
+	file = append(file, xauthEntry(familyLocal, "elsewhere", "1", other)...)
+	file = append(file, xauthEntry(familyLocal, hostname, "0", other)...)
+	file = append(file, xauthEntry(familyLocal, hostname, "1", want)...)
+	path := filepath.Join(t.TempDir(), "Xauthority")
+	if err := os.WriteFile(path, file, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	d, _ := parseDisplay(":1")
+	got, err := readCookie(path, d)
+	if err != nil {
+		t.Fatalf("readCookie: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("readCookie = %x, want %x", got, want)
+	}
+
+	d, _ = parseDisplay(":2")
+	if got, err := readCookie(path, d); (err != nil) || (got != nil) {
+		t.Errorf("readCookie for :2 = %x, %v; want nothing", got, err)
+	}
+	if _, err := findCookie(d, path); err == nil {
+		t.Error("findCookie with explicit file lacking the display: expected error")
+	}
+
+	if err := os.WriteFile(path, file[:len(file)-3], 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readCookie(path, d); err == nil {
+		t.Error("readCookie of truncated file: expected error")
+	}
+}
+
+func TestOpenDisplay_sendsCookie(t *testing.T) {
+	dir := t.TempDir()
+	sock := filepath.Join(dir, "x:0")
+	lis, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+
+	cookie := bytes.Repeat([]byte{0xab}, 16)
+	auth := filepath.Join(dir, "Xauthority")
+	if err := os.WriteFile(auth, xauthEntry(familyWild, "", "0", cookie), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The server refuses every connection, echoing the cookie it got.
+	go func() {
+		c, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		head := make([]byte, 12)
+		if _, err := io.ReadFull(c, head); err != nil {
+			return
+		}
+		nameLen, dataLen := binary.LittleEndian.Uint16(head[6:]), binary.LittleEndian.Uint16(head[8:])
+		rest := make([]byte, (int(nameLen)+3)&^3+(int(dataLen)+3)&^3)
+		if _, err := io.ReadFull(c, rest); err != nil {
+			return
+		}
+		got := rest[(int(nameLen)+3)&^3:][:dataLen]
+
+		reason := []byte("cookie " + strings.ToUpper(hex.EncodeToString(got)))
+		reply := []byte{0, byte(len(reason)), 11, 0, 0, 0}
+		padded := (len(reason) + 3) &^ 3
+		reply = binary.LittleEndian.AppendUint16(reply, uint16(padded/4))
+		reply = append(reply, reason...)
+		reply = append(reply, make([]byte, padded-len(reason))...)
+		c.Write(reply)
+	}()
+
+	_, err = OpenDisplay(sock, auth)
+	if err == nil {
+		t.Fatal("OpenDisplay: expected refusal")
+	}
+	if want := "cookie " + strings.Repeat("AB", 16); !strings.Contains(err.Error(), want) {
+		t.Errorf("OpenDisplay error = %v, want it to contain %q", err, want)
+	}
+}
+
+func TestOpenDisplay_noScreen(t *testing.T) {
+	dir := t.TempDir()
+	sock := filepath.Join(dir, "x:0")
+	lis, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+
+	// The server accepts the connection and has a single screen.
+	go func() {
+		c, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		head := make([]byte, 12)
+		if _, err := io.ReadFull(c, head); err != nil {
+			return
+		}
+		nameLen, dataLen := binary.LittleEndian.Uint16(head[6:]), binary.LittleEndian.Uint16(head[8:])
+		if _, err := io.ReadFull(c, make([]byte, (int(nameLen)+3)&^3+(int(dataLen)+3)&^3)); err != nil {
+			return
+		}
+
+		setup := xproto.SetupInfo{
+			Status:               1,
+			ProtocolMajorVersion: 11,
+			ResourceIdMask:       0x1fffff,
+			MinKeycode:           8,
+			MaxKeycode:           255,
+			RootsLen:             1,
+			Roots:                []xproto.ScreenInfo{{Root: 1, WidthInPixels: 640, HeightInPixels: 480}},
+		}
+		setup.Length = uint16((len(setup.Bytes()) - 8) / 4)
+		c.Write(setup.Bytes())
+		io.Copy(io.Discard, c)
+	}()
+
+	auth := filepath.Join(dir, "Xauthority")
+	if err := os.WriteFile(auth, xauthEntry(familyWild, "", "0", bytes.Repeat([]byte{0xab}, 16)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	x, err := OpenDisplay(sock+".5", auth)
+	if err == nil {
+		x.Close()
+		t.Fatal("OpenDisplay: expected an error for a missing screen")
+	}
+	if !strings.Contains(err.Error(), "no screen 5") {
+		t.Errorf("OpenDisplay error = %v, want it to mention the missing screen", err)
+	}
+}
+
+func TestWaitDisplay(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "x:0")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := WaitDisplay(ctx, sock); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitDisplay before the socket exists = %v, want deadline exceeded", err)
+	}
+
+	go func() {
+		time.Sleep(2 * displayPollInterval)
+		os.WriteFile(sock, nil, 0600)
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := WaitDisplay(ctx, sock); err != nil {
+		t.Errorf("WaitDisplay: %v", err)
+	}
+
+	if ok, err := DisplayAvailable("host:0"); !ok || (err != nil) {
+		t.Errorf("DisplayAvailable(host:0) = %v, %v; want true", ok, err)
+	}
+}
@@ -11,127 +11,6 @@ import (
 	"github.com/jezek/xgb/xproto"
 )
 
-func TestKeysymByName_commonPTT(t *testing.T) {
-	// Values from X11/keysymdef.h — must match what libxdo/xkb accepted.
-	cases := map[string]uint32{
-		"Alt_L":            0xffe9,
-		"Alt_R":            0xffea,
-		"Control_L":        0xffe3,
-		"Control_R":        0xffe4,
-		"Shift_L":          0xffe1,
-		"Shift_R":          0xffe2,
-		"Super_L":          0xffeb,
-		"Super_R":          0xffec,
-		"Meta_L":           0xffe7,
-		"Meta_R":           0xffe8,
-		"space":            0x0020,
-		"Return":           0xff0d,
-		"Tab":              0xff09,
-		"Escape":           0xff1b,
-		"Caps_Lock":        0xffe5,
-		"F1":               0xffbe,
-		"F13":              0xffca,
-		"a":                0x0061,
-		"A":                0x0041,
-		"ISO_Level3_Shift": 0xfe03,
-	}
-	for name, want := range cases {
-		got, ok := KeysymByName(name)
-		if !ok {
-			t.Errorf("KeysymByName(%q) missing", name)
-			continue
-		}
-		if got != want {
-			t.Errorf("KeysymByName(%q) = 0x%x, want 0x%x", name, got, want)
-		}
-	}
-}
-
-func TestKeysymByName_prefixes(t *testing.T) {
-	base, ok := KeysymByName("Alt_L")
-	if !ok {
-		t.Fatal("Alt_L missing")
-	}
-	for _, name := range []string{"XK_Alt_L", "XKB_KEY_Alt_L", "XF86XK_AudioMute"} {
-		got, ok := KeysymByName(name)
-		if name == "XF86XK_AudioMute" {
-			want, wantOK := KeysymByName("AudioMute")
-			if !ok || !wantOK || got != want {
-				t.Errorf("KeysymByName(%q) = (%x, %v), want AudioMute (%x, %v)", name, got, ok, want, wantOK)
-			}
-			continue
-		}
-		if !ok || got != base {
-			t.Errorf("KeysymByName(%q) = (%x, %v), want (%x, true)", name, got, ok, base)
-		}
-	}
-}
-
-func TestStripKeysymPrefix_longestOnly(t *testing.T) {
-	// Single longest-match strip: do not strip XK_ after XKB_KEY_.
-	if got := stripKeysymPrefix("XKB_KEY_Alt_L"); got != "Alt_L" {
-		t.Fatalf("XKB_KEY_Alt_L -> %q, want Alt_L", got)
-	}
-	if got := stripKeysymPrefix("XK_Alt_L"); got != "Alt_L" {
-		t.Fatalf("XK_Alt_L -> %q, want Alt_L", got)
-	}
-	if got := stripKeysymPrefix("XF86XK_AudioMute"); got != "AudioMute" {
-		t.Fatalf("XF86XK_AudioMute -> %q, want AudioMute", got)
-	}
-	// Nested/pathological: only one prefix, so XKB_KEY_XK_Foo becomes XK_Foo
-	// (not Foo). Lookup would then fail unless XK_Foo exists as a name.
-	if got := stripKeysymPrefix("XKB_KEY_XK_Alt_L"); got != "XK_Alt_L" {
-		t.Fatalf("XKB_KEY_XK_Alt_L -> %q, want XK_Alt_L (single strip)", got)
-	}
-	if got := stripKeysymPrefix("Alt_L"); got != "Alt_L" {
-		t.Fatalf("Alt_L -> %q, want unchanged", got)
-	}
-}
-
-func TestKeysymByName_exactOnly(t *testing.T) {
-	// Case-sensitive: multi-segment names must match exactly.
-	if _, ok := KeysymByName("alt_l"); ok {
-		t.Error("alt_l must not resolve (exact names only)")
-	}
-	if _, ok := KeysymByName("ALT_L"); ok {
-		t.Error("ALT_L must not resolve (exact names only)")
-	}
-	if _, ok := KeysymByName("Alt_l"); ok {
-		t.Error("Alt_l must not resolve (exact names only)")
-	}
-	// Canonical form still works.
-	if _, ok := KeysymByName("Alt_L"); !ok {
-		t.Error("Alt_L must resolve")
-	}
-}
-
-func TestKeysymByName_unknown(t *testing.T) {
-	if _, ok := KeysymByName("NotARealKeysym_XYZ"); ok {
-		t.Fatal("expected unknown keysym to fail")
-	}
-	if _, ok := KeysymByName(""); ok {
-		t.Fatal("expected empty name to fail")
-	}
-}
-
-func TestSplitKeysequence(t *testing.T) {
-	cases := []struct {
-		in   string
-		want []string
-	}{
-		{"Alt_L", []string{"Alt_L"}},
-		{"Control_L+Alt_L", []string{"Control_L", "Alt_L"}},
-		{"  Shift_L + a ", []string{"Shift_L", "a"}},
-		{"", nil},
-	}
-	for _, tc := range cases {
-		got := splitKeysequence(tc.in)
-		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
-			t.Errorf("splitKeysequence(%q) = %v, want %v", tc.in, got, tc.want)
-		}
-	}
-}
-
 func TestValidButton(t *testing.T) {
 	if err := ValidButton(0); err == nil {
 		t.Error("button 0 should be invalid")
@@ -1186,3 +1065,77 @@ func TestScratch_ensureTwoPassRebindAfterReapply(t *testing.T) {
 		t.Fatalf("base[11] = 0x%x, want b left untouched", x.baseKeysym(11))
 	}
 }
+
+func TestScratch_bindsUnicodeKeysym(t *testing.T) {
+	x := &Xdo{
+		min:               8,
+		max:               9,
+		keysymsPerKeycode: 1,
+		keyMap:            []xproto.Keysym{0x61, 0},
+	}
+	kcs, err := x.Keycodes("U+1F399")
+	if err != nil {
+		t.Fatalf("Keycodes(U+1F399): %v", err)
+	}
+	if len(kcs) != 1 || kcs[0] != 9 {
+		t.Fatalf("Keycodes(U+1F399) = %v, want [9]", kcs)
+	}
+	if got := x.keyMap[1]; got != 0x0101f399 {
+		t.Errorf("keycode 9 keysym = 0x%x, want 0x101f399", got)
+	}
+
+	// The literal character resolves to the same scratch slot.
+	kcs, err = x.Keycodes("🎙")
+	if err != nil {
+		t.Fatalf("Keycodes(🎙): %v", err)
+	}
+	if len(kcs) != 1 || kcs[0] != 9 {
+		t.Fatalf("Keycodes(🎙) = %v, want [9]", kcs)
+	}
+}
+
+func TestReachable(t *testing.T) {
+	x := &Xdo{
+		min:               8,
+		max:               9,
+		keysymsPerKeycode: 2,
+		keyMap:            []xproto.Keysym{0x61, 0x41, 0, 0},
+	}
+	cases := []struct {
+		sym  uint32
+		want Reachability
+	}{
+		{0x61, BaseLevel},
+		{0x41, NeedsModifiers},
+		{0xffea, Unmapped},
+	}
+	for _, tc := range cases {
+		got, err := x.Reachable(tc.sym)
+		if err != nil {
+			t.Fatalf("Reachable(0x%x): %v", tc.sym, err)
+		}
+		if got != tc.want {
+			t.Errorf("Reachable(0x%x) = %v, want %v", tc.sym, got, tc.want)
+		}
+	}
+	if len(x.scratches) != 0 {
+		t.Errorf("Reachable installed scratch bindings: %v", x.scratches)
+	}
+}
+
+func TestKeycodes_suggestsOnUnknown(t *testing.T) {
+	x := &Xdo{
+		min:               8,
+		max:               8,
+		keysymsPerKeycode: 1,
+		keyMap:            []xproto.Keysym{0xffe9},
+	}
+	_, err := x.Keycodes("alt_l")
+	if (err == nil) || !strings.Contains(err.Error(), `did you mean "Alt_L"?`) {
+		t.Errorf("Keycodes(alt_l) error = %v, want a suggestion of Alt_L", err)
+	}
+	_, err = x.BindKeys("Atl_L")
+	if (err == nil) || !strings.Contains(err.Error(), `did you mean "Alt_L"?`) {
+		t.Errorf("BindKeys(Atl_L) error = %v, want a suggestion of Alt_L", err)
+	}
+}
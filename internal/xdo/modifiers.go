@@ -0,0 +1,267 @@
+package xdo
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"deedles.dev/ptt-fix/internal/keysym"
+	"github.com/jezek/xgb/xproto"
+)
+
+// keyPress is a single key of a binding together with the keyboard state it
+// needs to produce the intended keysym.
+type keyPress struct {
+	keycode xproto.Keycode
+	mods    uint8 // modifiers that must be active
+	mask    uint8 // modifiers that affect the key's level
+	group   int   // group to latch, or -1 to leave it alone
+}
+
+// SetModifierSynthesis enables or disables modifier synthesis for bindings
+// returned by [Xdo.BindKeys]. It is off by default.
+//
+// With synthesis enabled, a keysym that is only reachable on a non-base level
+// or in another group is looked up in the XKB keyboard description. Down then
+// presses the modifiers that select its level (for example Shift or AltGr),
+// releases any held modifiers that would select a different level, and
+// latches the group if needed before pressing the key itself. Up releases the
+// key and the synthesized modifiers, then presses the modifier keys that were
+// released again so the user's held modifiers are restored. Locked modifiers
+// such as Caps Lock are left alone.
+//
+// Synthesis requires the XKB extension; resolution of non-base keysyms fails
+// if the server does not support it.
+func (x *Xdo) SetModifierSynthesis(enabled bool) {
+	if x == nil {
+		return
+	}
+	x.synthesize = enabled
+
+	// The modifier mapping is only loaded while synthesis is enabled, so a
+	// cached map loaded before this lacks it.
+	if enabled && (x.watch != nil) {
+		x.watch.dirty.Or(dirtyMap)
+	}
+}
+
+// resolvePresses resolves keys like [Xdo.Keycodes], but also returns the
+// modifiers and group each key needs. Keys with a base mapping need no
+// modifiers. Others are looked up in the XKB map, preferring st's group.
+func (x *Xdo) resolvePresses(keys string, st xkbState) ([]keyPress, error) {
+	parts := keysym.Split(keys)
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("empty key sequence")
+	}
+
+	out := make([]keyPress, 0, len(parts))
+	for _, part := range parts {
+		sym, ok := keysym.Lookup(part)
+		if !ok {
+			return nil, keysym.UnknownError(part)
+		}
+		p, err := x.resolvePress(xproto.Keysym(sym), st)
+		if err != nil {
+			return nil, fmt.Errorf("keysym %q (0x%x): %w", part, sym, err)
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+func (x *Xdo) resolvePress(sym xproto.Keysym, st xkbState) (keyPress, error) {
+	kc, err := x.keycodeForKeysym(sym)
+	if !errors.Is(err, errNeedsModifiers) {
+		return keyPress{keycode: kc, group: -1}, err
+	}
+	if x.xkb == nil {
+		return keyPress{}, fmt.Errorf("%w, and modifier synthesis needs the XKB extension", errNeedsModifiers)
+	}
+
+	l, ok := x.xkb.find(sym, int(st.group))
+	if !ok {
+		return keyPress{}, fmt.Errorf("%w, but no modifier combination reaches it", errNeedsModifiers)
+	}
+	for bit := range 8 {
+		if (l.mods&(1<<bit) != 0) && (x.modifierKeycode(bit) == 0) {
+			return keyPress{}, fmt.Errorf("no key is mapped to modifier %d", bit)
+		}
+	}
+	return keyPress{keycode: l.keycode, mods: l.mods, mask: l.mask, group: l.group}, nil
+}
+
+// modifierKeycode returns a keycode that sets the real modifier bit, or 0.
+func (x *Xdo) modifierKeycode(bit int) xproto.Keycode {
+	for _, kc := range x.modKeycodes[bit] {
+		if kc != 0 {
+			return kc
+		}
+	}
+	return 0
+}
+
+// synthDown presses keys with whatever modifier and group changes they need.
+// It returns the keycodes pressed for the keys themselves, the modifier keys
+// it pressed and the modifier keys it released, in order. If anything fails,
+// everything done so far is undone (best-effort) before returning the error.
+func (x *Xdo) synthDown(keys string) (held, pressed, released []byte, err error) {
+	if err := x.ready(); err != nil {
+		return nil, nil, nil, err
+	}
+	st, keymap, err := x.keyboardState()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	presses, err := x.resolvePresses(keys, st)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	defer func() {
+		if err != nil {
+			// Best-effort; preserve the original error.
+			x.synthUp(held, pressed, released)
+			held, pressed, released = nil, nil, nil
+		}
+	}()
+
+	mods := st.mods
+	for _, p := range presses {
+		if p.group >= 0 {
+			latch := int16(p.group) - st.baseGroup - int16(st.lockedGroup)
+			if err := x.latchGroup(latch); err != nil {
+				return held, pressed, released, err
+			}
+		}
+
+		clear := mods & p.mask &^ p.mods
+		for _, kc := range x.heldModifierKeys(clear, keymap, pressed) {
+			if err := x.fakeInput(xproto.KeyRelease, kc); err != nil {
+				return held, pressed, released, err
+			}
+			if i := slices.Index(pressed, kc); i >= 0 {
+				pressed = slices.Delete(pressed, i, i+1)
+				continue
+			}
+			released = append(released, kc)
+		}
+
+		add := p.mods &^ mods
+		for bit := range 8 {
+			if add&(1<<bit) == 0 {
+				continue
+			}
+			kc := byte(x.modifierKeycode(bit))
+			if err := x.fakeInput(xproto.KeyPress, kc); err != nil {
+				return held, pressed, released, err
+			}
+			pressed = append(pressed, kc)
+		}
+		mods = (mods | add) &^ clear
+
+		if err := x.fakeInput(xproto.KeyPress, byte(p.keycode)); err != nil {
+			return held, pressed, released, err
+		}
+		held = append(held, byte(p.keycode))
+	}
+	return held, pressed, released, nil
+}
+
+// synthUp undoes synthDown: the keys are released in reverse, then the
+// synthesized modifiers, and then the user's modifier keys are pressed again.
+// All steps are attempted and the first error is returned.
+func (x *Xdo) synthUp(held, pressed, released []byte) error {
+	return errors.Join(
+		x.KeyUp(held),
+		x.KeyUp(pressed),
+		x.KeyDown(released),
+	)
+}
+
+// heldModifierKeys returns the keycodes for mods that are down according to
+// keymap (a QueryKeymap bit vector) or were pressed by us.
+func (x *Xdo) heldModifierKeys(mods uint8, keymap []byte, pressed []byte) []byte {
+	var out []byte
+	for bit := range 8 {
+		if mods&(1<<bit) == 0 {
+			continue
+		}
+		for _, kc := range x.modKeycodes[bit] {
+			if (kc == 0) || slices.Contains(out, byte(kc)) {
+				continue
+			}
+			down := (int(kc/8) < len(keymap)) && (keymap[kc/8]&(1<<(kc%8)) != 0)
+			if down || slices.Contains(pressed, byte(kc)) {
+				out = append(out, byte(kc))
+			}
+		}
+	}
+	return out
+}
+
+// keyboardState returns the XKB state and the QueryKeymap bit vector of
+// currently pressed keys.
+func (x *Xdo) keyboardState() (xkbState, []byte, error) {
+	if x.queryState != nil {
+		return x.queryState()
+	}
+	if (x.conn == nil) || (x.xkb == nil) {
+		// Without XKB only base keysyms resolve, which need no state.
+		return xkbState{}, nil, nil
+	}
+	st, err := xkbGetStateReply(x.conn)
+	if err != nil {
+		return xkbState{}, nil, err
+	}
+	keymap, err := xproto.QueryKeymap(x.conn).Reply()
+	if err != nil {
+		return xkbState{}, nil, fmt.Errorf("query keymap: %w", err)
+	}
+	return st, keymap.Keys, nil
+}
+
+func (x *Xdo) latchGroup(latch int16) error {
+	if x.latch != nil {
+		return x.latch(latch)
+	}
+	if x.conn == nil {
+		return fmt.Errorf("xdo connection closed")
+	}
+	return xkbLatchGroup(x.conn, latch)
+}
+
+// refreshXkb reloads the XKB map and the active group. If either request
+// fails, resolution falls back to the core keyboard map until the next reload.
+func (x *Xdo) refreshXkb() {
+	m, err := xkbGetMapReply(x.conn)
+	if err != nil {
+		x.xkb = nil
+		return
+	}
+	st, err := xkbGetStateReply(x.conn)
+	if err != nil {
+		x.xkb = nil
+		return
+	}
+	x.xkb = m
+	x.group = int(st.group)
+}
+
+// refreshModifierMapping reloads the keycodes of each real modifier, used by
+// modifier synthesis to press and release them.
+func (x *Xdo) refreshModifierMapping() error {
+	mods, err := xproto.GetModifierMapping(x.conn).Reply()
+	if err != nil {
+		return fmt.Errorf("get modifier mapping: %w", err)
+	}
+
+	per := int(mods.KeycodesPerModifier)
+	for bit := range x.modKeycodes {
+		if (bit+1)*per > len(mods.Keycodes) {
+			x.modKeycodes[bit] = nil
+			continue
+		}
+		x.modKeycodes[bit] = slices.Clone(mods.Keycodes[bit*per : (bit+1)*per])
+	}
+	return nil
+}
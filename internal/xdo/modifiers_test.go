@@ -0,0 +1,265 @@
+package xdo
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jezek/xgb/xproto"
+)
+
+const (
+	symQ          = xproto.Keysym(0x71)
+	symUpperQ     = xproto.Keysym(0x51)
+	symAt         = xproto.Keysym(0x40)
+	symShiftL     = xproto.Keysym(0xffe1)
+	symLevel3     = xproto.Keysym(0xfe03)
+	symLowerA     = xproto.Keysym(0x61)
+	symCyrillicA  = xproto.Keysym(0x6c1)
+	modShift      = 1 << 0
+	modMod5       = 1 << 7
+	kcQ           = 8
+	kcShift       = 9
+	kcLevel3      = 10
+	kcA           = 11
+	typeOneLevel  = 0
+	typeFourLevel = 1
+)
+
+var errTestInput = errors.New("injected input failure")
+
+type inputEvent struct {
+	evType byte
+	detail byte
+}
+
+// newSynthXdo builds a German-style synthetic map: keycode 8 types q, Q and @
+// (AltGr) on a four-level type; keycode 11 types a in group 1 and Cyrillic a
+// in group 2. Shift_L and ISO_Level3_Shift sit on keycodes 9 and 10.
+func newSynthXdo(events *[]inputEvent) *Xdo {
+	return &Xdo{
+		min:               8,
+		max:               11,
+		keysymsPerKeycode: 4,
+		keyMap: []xproto.Keysym{
+			symQ, symUpperQ, symAt, 0,
+			symShiftL, 0, 0, 0,
+			symLevel3, 0, 0, 0,
+			symLowerA, 0, symCyrillicA, 0,
+		},
+		synthesize: true,
+		xkb: &xkbMap{
+			min: 8,
+			max: 11,
+			types: []xkbKeyType{
+				typeOneLevel: {levels: 1},
+				typeFourLevel: {
+					mask:   modShift | modMod5,
+					levels: 4,
+					entries: []xkbTypeEntry{
+						{active: true, mods: modShift, level: 1},
+						{active: true, mods: modMod5, level: 2},
+						{active: true, mods: modShift | modMod5, level: 3},
+					},
+				},
+			},
+			keys: []xkbKeySyms{
+				{types: [4]byte{typeFourLevel}, groupInfo: 1, width: 4, syms: []xproto.Keysym{symQ, symUpperQ, symAt, 0}},
+				{types: [4]byte{typeOneLevel}, groupInfo: 1, width: 1, syms: []xproto.Keysym{symShiftL}},
+				{types: [4]byte{typeOneLevel}, groupInfo: 1, width: 1, syms: []xproto.Keysym{symLevel3}},
+				{types: [4]byte{typeOneLevel, typeOneLevel}, groupInfo: 2, width: 1, syms: []xproto.Keysym{symLowerA, symCyrillicA}},
+			},
+		},
+		modKeycodes: [8][]xproto.Keycode{
+			0: {kcShift, 0},
+			7: {kcLevel3, 0},
+		},
+		queryState: func() (xkbState, []byte, error) {
+			return xkbState{}, make([]byte, 32), nil
+		},
+		input: func(evType, detail byte) error {
+			*events = append(*events, inputEvent{evType, detail})
+			return nil
+		},
+	}
+}
+
+func checkEvents(t *testing.T, got, want []inputEvent) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("events = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestSynth_pressesLevelModifier(t *testing.T) {
+	var events []inputEvent
+	x := newSynthXdo(&events)
+
+	b, err := x.BindKeys("at")
+	if err != nil {
+		t.Fatalf("BindKeys(at): %v", err)
+	}
+	if err := b.Down(); err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+	if err := b.Up(); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	checkEvents(t, events, []inputEvent{
+		{xproto.KeyPress, kcLevel3},
+		{xproto.KeyPress, kcQ},
+		{xproto.KeyRelease, kcQ},
+		{xproto.KeyRelease, kcLevel3},
+	})
+}
+
+func TestSynth_restoresHeldModifiers(t *testing.T) {
+	var events []inputEvent
+	x := newSynthXdo(&events)
+	x.queryState = func() (xkbState, []byte, error) {
+		keymap := make([]byte, 32)
+		keymap[kcShift/8] |= 1 << (kcShift % 8)
+		return xkbState{mods: modShift}, keymap, nil
+	}
+
+	b, err := x.BindKeys("at")
+	if err != nil {
+		t.Fatalf("BindKeys(at): %v", err)
+	}
+	if err := b.Down(); err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+	if err := b.Up(); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	// Shift would select level 4, so it is lifted for the press and put back
+	// afterwards.
+	checkEvents(t, events, []inputEvent{
+		{xproto.KeyRelease, kcShift},
+		{xproto.KeyPress, kcLevel3},
+		{xproto.KeyPress, kcQ},
+		{xproto.KeyRelease, kcQ},
+		{xproto.KeyRelease, kcLevel3},
+		{xproto.KeyPress, kcShift},
+	})
+}
+
+func TestSynth_keepsNeededModifiers(t *testing.T) {
+	var events []inputEvent
+	x := newSynthXdo(&events)
+	x.queryState = func() (xkbState, []byte, error) {
+		keymap := make([]byte, 32)
+		keymap[kcShift/8] |= 1 << (kcShift % 8)
+		return xkbState{mods: modShift}, keymap, nil
+	}
+
+	b, err := x.BindKeys("Q")
+	if err != nil {
+		t.Fatalf("BindKeys(Q): %v", err)
+	}
+	if err := b.Down(); err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+	if err := b.Up(); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	checkEvents(t, events, []inputEvent{
+		{xproto.KeyPress, kcQ},
+		{xproto.KeyRelease, kcQ},
+	})
+}
+
+func TestSynth_latchesGroup(t *testing.T) {
+	var events []inputEvent
+	x := newSynthXdo(&events)
+	var latches []int16
+	x.latch = func(latch int16) error {
+		latches = append(latches, latch)
+		return nil
+	}
+
+	b, err := x.BindKeys("Cyrillic_a")
+	if err != nil {
+		t.Fatalf("BindKeys(Cyrillic_a): %v", err)
+	}
+	if err := b.Down(); err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+	if err := b.Up(); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	if len(latches) != 1 || latches[0] != 1 {
+		t.Fatalf("latches = %v, want [1]", latches)
+	}
+	checkEvents(t, events, []inputEvent{
+		{xproto.KeyPress, kcA},
+		{xproto.KeyRelease, kcA},
+	})
+
+	// Already in the second group (locked): nothing to latch.
+	latches = nil
+	x.queryState = func() (xkbState, []byte, error) {
+		return xkbState{group: 1, lockedGroup: 1}, make([]byte, 32), nil
+	}
+	if err := b.Down(); err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+	if len(latches) != 0 {
+		t.Fatalf("latches = %v, want none", latches)
+	}
+}
+
+func TestSynth_disabledRejectsNonBase(t *testing.T) {
+	var events []inputEvent
+	x := newSynthXdo(&events)
+	x.SetModifierSynthesis(false)
+
+	_, err := x.BindKeys("at")
+	if err == nil || !strings.Contains(err.Error(), "modifiers") {
+		t.Fatalf("BindKeys(at) without synthesis = %v, want modifiers error", err)
+	}
+}
+
+func TestSynth_requiresXkb(t *testing.T) {
+	var events []inputEvent
+	x := newSynthXdo(&events)
+	x.xkb = nil
+
+	_, err := x.BindKeys("at")
+	if err == nil || !strings.Contains(err.Error(), "XKB") {
+		t.Fatalf("BindKeys(at) without XKB = %v, want XKB error", err)
+	}
+	// Base keysyms still work.
+	if _, err := x.BindKeys("q"); err != nil {
+		t.Fatalf("BindKeys(q): %v", err)
+	}
+}
+
+func TestSynth_rollsBackOnFailure(t *testing.T) {
+	var events []inputEvent
+	x := newSynthXdo(&events)
+	x.input = func(evType, detail byte) error {
+		if evType == xproto.KeyPress && detail == kcQ {
+			return errTestInput
+		}
+		events = append(events, inputEvent{evType, detail})
+		return nil
+	}
+
+	b, err := x.BindKeys("at")
+	if err != nil {
+		t.Fatalf("BindKeys(at): %v", err)
+	}
+	if err := b.Down(); err == nil {
+		t.Fatal("Down should fail")
+	}
+	checkEvents(t, events, []inputEvent{
+		{xproto.KeyPress, kcLevel3},
+		{xproto.KeyRelease, kcLevel3},
+	})
+}
@@ -0,0 +1,384 @@
+package xdo
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/xproto"
+)
+
+// ErrWindowNotFound is returned by [WindowBinding.Down] if no window matches
+// the binding's [WindowMatch].
+var ErrWindowNotFound = errors.New("no matching window")
+
+// WindowMatch selects a top-level window from the window manager's
+// _NET_CLIENT_LIST (EWMH). Exactly one field must be set. If several windows
+// match, the one that was mapped first is used.
+type WindowMatch struct {
+	// Class matches either part of WM_CLASS (instance or class name),
+	// ignoring case.
+	Class string
+	// Title matches a substring of _NET_WM_NAME, or of WM_NAME if the window
+	// has no _NET_WM_NAME, ignoring case.
+	Title string
+	// PID matches _NET_WM_PID.
+	PID uint32
+}
+
+func (m WindowMatch) valid() error {
+	n := 0
+	for _, set := range []bool{m.Class != "", m.Title != "", m.PID != 0} {
+		if set {
+			n++
+		}
+	}
+	if n != 1 {
+		return fmt.Errorf("window match must set exactly one of class, title and pid")
+	}
+	return nil
+}
+
+func (m WindowMatch) matches(w windowInfo) bool {
+	switch {
+	case m.Class != "":
+		return strings.EqualFold(w.instance, m.Class) || strings.EqualFold(w.class, m.Class)
+	case m.Title != "":
+		return strings.Contains(strings.ToLower(w.title), strings.ToLower(m.Title))
+	default:
+		return w.pid == m.PID
+	}
+}
+
+func (m WindowMatch) String() string {
+	switch {
+	case m.Class != "":
+		return fmt.Sprintf("class %q", m.Class)
+	case m.Title != "":
+		return fmt.Sprintf("title %q", m.Title)
+	default:
+		return fmt.Sprintf("pid %v", m.PID)
+	}
+}
+
+// windowInfo holds the properties of a window used for matching.
+type windowInfo struct {
+	instance, class string
+	title           string
+	pid             uint32 // 0 if unknown
+}
+
+// WindowBinding delivers key events for a keysym sequence to one window with
+// SendEvent instead of XTest, so they reach it regardless of focus.
+//
+// Events sent this way carry the send_event flag. Many applications,
+// including most games and anything that reads input through XInput2 or
+// global shortcut APIs, ignore such events or never see them, so this only
+// works with applications that accept synthetic core key events.
+type WindowBinding struct {
+	x     *Xdo
+	keys  string
+	match WindowMatch
+
+	// win is the last window that matched, checked again before use.
+	win xproto.Window
+	// held and heldWin are the keycodes and window of the last Down.
+	held    []byte
+	heldWin xproto.Window
+}
+
+// BindWindowKeys validates keys against the current map like [Xdo.BindKeys]
+// and returns a [WindowBinding] that sends them to the window selected by m.
+// The window does not need to exist yet; it is looked up on each Down.
+// Modifier synthesis does not apply to window bindings.
+func (x *Xdo) BindWindowKeys(keys string, m WindowMatch) (*WindowBinding, error) {
+	if x == nil {
+		return nil, fmt.Errorf("xdo connection closed")
+	}
+	if err := m.valid(); err != nil {
+		return nil, err
+	}
+	if _, err := x.Keycodes(keys); err != nil {
+		return nil, err
+	}
+	return &WindowBinding{x: x, keys: keys, match: m}, nil
+}
+
+// Down finds the target window and sends it key presses for the binding's
+// keys. If no window matches, the returned error wraps [ErrWindowNotFound].
+func (b *WindowBinding) Down() error {
+	if b == nil || b.x == nil {
+		return fmt.Errorf("xdo connection closed")
+	}
+	win, err := b.window()
+	if err != nil {
+		return err
+	}
+	kcs, err := b.x.Keycodes(b.keys)
+	if err != nil {
+		return err
+	}
+	for i, kc := range kcs {
+		if err := b.x.sendKey(win, true, kc); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				// Best-effort; preserve the original press error.
+				b.x.sendKey(win, false, kcs[j])
+			}
+			return err
+		}
+	}
+	b.held, b.heldWin = kcs, win
+	return nil
+}
+
+// Up sends key releases for the keycodes of the last successful Down to the
+// same window, in reverse order. It does nothing if there was none or the window is gone.
+func (b *WindowBinding) Up() error {
+	if b == nil || b.x == nil {
+		return fmt.Errorf("xdo connection closed")
+	}
+	kcs, win := b.held, b.heldWin
+	b.held, b.heldWin = nil, 0
+
+	var errs []error
+	for _, kc := range slices.Backward(kcs) {
+		err := b.x.sendKey(win, false, kc)
+		if _, ok := err.(xproto.WindowError); ok {
+			// The window was destroyed while the keys were held.
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// window returns the cached window if it still matches, or looks the target
+// up again.
+func (b *WindowBinding) window() (xproto.Window, error) {
+	if b.win != 0 {
+		info, err := b.x.windowInfo(b.win)
+		if (err == nil) && b.match.matches(info) {
+			return b.win, nil
+		}
+		b.win = 0
+	}
+
+	win, err := b.x.findWindow(b.match)
+	if err != nil {
+		return 0, err
+	}
+	b.win = win
+	return win, nil
+}
+
+// findWindow returns the first managed window that matches m.
+func (x *Xdo) findWindow(m WindowMatch) (xproto.Window, error) {
+	wins, err := x.clientList()
+	if err != nil {
+		return 0, err
+	}
+	for _, win := range wins {
+		info, err := x.windowInfo(win)
+		if err != nil {
+			// Most likely destroyed since the list was read.
+			continue
+		}
+		if m.matches(info) {
+			return win, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %v", ErrWindowNotFound, m)
+}
+
+// WindowExists reports whether any window managed by the window manager
+// matches m.
+func (x *Xdo) WindowExists(m WindowMatch) (bool, error) {
+	if x == nil {
+		return false, fmt.Errorf("xdo connection closed")
+	}
+	if err := m.valid(); err != nil {
+		return false, err
+	}
+	_, err := x.findWindow(m)
+	if errors.Is(err, ErrWindowNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ActiveWindowMatches reports whether the window that the window manager
+// reports as active (_NET_ACTIVE_WINDOW) matches m. It is false if there is
+// no active window.
+func (x *Xdo) ActiveWindowMatches(m WindowMatch) (bool, error) {
+	if x == nil {
+		return false, fmt.Errorf("xdo connection closed")
+	}
+	if err := m.valid(); err != nil {
+		return false, err
+	}
+	win, err := x.activeWindow()
+	if (err != nil) || (win == 0) {
+		return false, err
+	}
+	info, err := x.windowInfo(win)
+	if err != nil {
+		if _, ok := err.(xproto.WindowError); ok {
+			// Destroyed since it was reported.
+			return false, nil
+		}
+		return false, err
+	}
+	return m.matches(info), nil
+}
+
+// activeWindow returns _NET_ACTIVE_WINDOW, or 0 if there is none.
+func (x *Xdo) activeWindow() (xproto.Window, error) {
+	if x.queryActive != nil {
+		return x.queryActive()
+	}
+	if x.conn == nil {
+		return 0, fmt.Errorf("xdo connection closed")
+	}
+	atom, err := x.atom("_NET_ACTIVE_WINDOW")
+	if err != nil {
+		return 0, err
+	}
+	reply, err := xproto.GetProperty(x.conn, false, x.root(), atom, xproto.AtomWindow, 0, 1).Reply()
+	if err != nil {
+		return 0, fmt.Errorf("get _NET_ACTIVE_WINDOW: %w", err)
+	}
+	if (reply.Format != 32) || (len(reply.Value) < 4) {
+		return 0, nil
+	}
+	return xproto.Window(xgb.Get32(reply.Value)), nil
+}
+
+// clientList returns the managed top-level windows in mapping order.
+func (x *Xdo) clientList() ([]xproto.Window, error) {
+	if x.listClients != nil {
+		return x.listClients()
+	}
+	if x.conn == nil {
+		return nil, fmt.Errorf("xdo connection closed")
+	}
+	atom, err := x.atom("_NET_CLIENT_LIST")
+	if err != nil {
+		return nil, err
+	}
+	reply, err := xproto.GetProperty(x.conn, false, x.root(), atom, xproto.AtomWindow, 0, 1<<16).Reply()
+	if err != nil {
+		return nil, fmt.Errorf("get _NET_CLIENT_LIST: %w", err)
+	}
+	if reply.Format != 32 {
+		return nil, fmt.Errorf("window manager does not provide _NET_CLIENT_LIST")
+	}
+
+	wins := make([]xproto.Window, 0, reply.ValueLen)
+	for i := 0; i+4 <= len(reply.Value); i += 4 {
+		wins = append(wins, xproto.Window(xgb.Get32(reply.Value[i:])))
+	}
+	return wins, nil
+}
+
+// windowInfo reads the properties of win used for matching. The requests
+// are pipelined, so this costs a single round trip.
+func (x *Xdo) windowInfo(win xproto.Window) (windowInfo, error) {
+	if x.queryWindow != nil {
+		return x.queryWindow(win)
+	}
+	if x.conn == nil {
+		return windowInfo{}, fmt.Errorf("xdo connection closed")
+	}
+	netName, err := x.atom("_NET_WM_NAME")
+	if err != nil {
+		return windowInfo{}, err
+	}
+	netPID, err := x.atom("_NET_WM_PID")
+	if err != nil {
+		return windowInfo{}, err
+	}
+
+	const max = 1024 // in 32-bit units
+	class := xproto.GetProperty(x.conn, false, win, xproto.AtomWmClass, xproto.AtomString, 0, max)
+	name := xproto.GetProperty(x.conn, false, win, netName, xproto.GetPropertyTypeAny, 0, max)
+	wmName := xproto.GetProperty(x.conn, false, win, xproto.AtomWmName, xproto.GetPropertyTypeAny, 0, max)
+	pid := xproto.GetProperty(x.conn, false, win, netPID, xproto.AtomCardinal, 0, 1)
+
+	var info windowInfo
+	r, err := class.Reply()
+	if err != nil {
+		return windowInfo{}, fmt.Errorf("get WM_CLASS: %w", err)
+	}
+	parts := strings.Split(string(r.Value), "\x00")
+	if len(parts) >= 2 {
+		info.instance, info.class = parts[0], parts[1]
+	}
+
+	if r, err = name.Reply(); err != nil {
+		return windowInfo{}, fmt.Errorf("get _NET_WM_NAME: %w", err)
+	}
+	info.title = string(r.Value)
+	if r, err = wmName.Reply(); err != nil {
+		return windowInfo{}, fmt.Errorf("get WM_NAME: %w", err)
+	}
+	if info.title == "" {
+		info.title = string(r.Value)
+	}
+
+	if r, err = pid.Reply(); err != nil {
+		return windowInfo{}, fmt.Errorf("get _NET_WM_PID: %w", err)
+	}
+	if (r.Format == 32) && (len(r.Value) >= 4) {
+		info.pid = xgb.Get32(r.Value)
+	}
+	return info, nil
+}
+
+// sendKey sends a single synthetic key press or release to win.
+func (x *Xdo) sendKey(win xproto.Window, press bool, keycode byte) error {
+	if x.send != nil {
+		return x.send(win, press, keycode)
+	}
+	if x.conn == nil {
+		return fmt.Errorf("xdo connection closed")
+	}
+
+	ev := xproto.KeyPressEvent{
+		Detail:     xproto.Keycode(keycode),
+		Time:       xproto.TimeCurrentTime,
+		Root:       x.root(),
+		Event:      win,
+		RootX:      1,
+		RootY:      1,
+		EventX:     1,
+		EventY:     1,
+		SameScreen: true,
+	}
+	buf, mask := ev.Bytes(), uint32(xproto.EventMaskKeyPress)
+	if !press {
+		buf, mask = xproto.KeyReleaseEvent(ev).Bytes(), xproto.EventMaskKeyRelease
+	}
+	return xproto.SendEventChecked(x.conn, true, win, mask, string(buf)).Check()
+}
+
+func (x *Xdo) root() xproto.Window {
+	return xproto.Setup(x.conn).DefaultScreen(x.conn).Root
+}
+
+// atom interns name, caching the result for the life of the connection.
+func (x *Xdo) atom(name string) (xproto.Atom, error) {
+	if a, ok := x.atoms[name]; ok {
+		return a, nil
+	}
+	reply, err := xproto.InternAtom(x.conn, false, uint16(len(name)), name).Reply()
+	if err != nil {
+		return 0, fmt.Errorf("intern atom %v: %w", name, err)
+	}
+	if x.atoms == nil {
+		x.atoms = make(map[string]xproto.Atom)
+	}
+	x.atoms[name] = reply.Atom
+	return reply.Atom, nil
+}
@@ -0,0 +1,210 @@
+package xdo
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/jezek/xgb/xproto"
+)
+
+type sentKey struct {
+	win     xproto.Window
+	press   bool
+	keycode byte
+}
+
+// newWindowXdo returns a synthetic *Xdo with 'a' on keycode 8 and the given
+// windows, recording sent keys in *sent.
+func newWindowXdo(windows map[xproto.Window]windowInfo, order []xproto.Window, sent *[]sentKey) *Xdo {
+	return &Xdo{
+		min:               8,
+		max:               8,
+		keysymsPerKeycode: 1,
+		keyMap:            []xproto.Keysym{0x61},
+		listClients: func() ([]xproto.Window, error) {
+			return slices.Clone(order), nil
+		},
+		queryWindow: func(win xproto.Window) (windowInfo, error) {
+			info, ok := windows[win]
+			if !ok {
+				return windowInfo{}, xproto.WindowError{BadValue: uint32(win)}
+			}
+			return info, nil
+		},
+		send: func(win xproto.Window, press bool, keycode byte) error {
+			if _, ok := windows[win]; !ok {
+				return xproto.WindowError{BadValue: uint32(win)}
+			}
+			*sent = append(*sent, sentKey{win, press, keycode})
+			return nil
+		},
+	}
+}
+
+func TestWindowMatch(t *testing.T) {
+	info := windowInfo{instance: "mumble", class: "Mumble", title: "Mumble -- Server", pid: 42}
+	cases := []struct {
+		m    WindowMatch
+		want bool
+	}{
+		{WindowMatch{Class: "mumble"}, true},
+		{WindowMatch{Class: "MUMBLE"}, true},
+		{WindowMatch{Class: "mum"}, false},
+		{WindowMatch{Title: "server"}, true},
+		{WindowMatch{Title: "discord"}, false},
+		{WindowMatch{PID: 42}, true},
+		{WindowMatch{PID: 43}, false},
+	}
+	for _, tc := range cases {
+		if got := tc.m.matches(info); got != tc.want {
+			t.Errorf("%v matches = %v, want %v", tc.m, got, tc.want)
+		}
+	}
+
+	for _, m := range []WindowMatch{{}, {Class: "a", PID: 1}} {
+		if err := m.valid(); err == nil {
+			t.Errorf("%+v: expected error", m)
+		}
+	}
+}
+
+func TestWindowBinding_sendsToMatch(t *testing.T) {
+	windows := map[xproto.Window]windowInfo{
+		1: {instance: "game", class: "Game"},
+		2: {instance: "mumble", class: "Mumble"},
+	}
+	var sent []sentKey
+	x := newWindowXdo(windows, []xproto.Window{1, 2}, &sent)
+
+	b, err := x.BindWindowKeys("a", WindowMatch{Class: "mumble"})
+	if err != nil {
+		t.Fatalf("BindWindowKeys: %v", err)
+	}
+	if err := b.Down(); err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+	if err := b.Up(); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	want := []sentKey{{2, true, 8}, {2, false, 8}}
+	if !slices.Equal(sent, want) {
+		t.Errorf("sent = %v, want %v", sent, want)
+	}
+}
+
+func TestWindowBinding_releasesInReverse(t *testing.T) {
+	windows := map[xproto.Window]windowInfo{1: {instance: "mumble", class: "Mumble"}}
+	var sent []sentKey
+	x := newWindowXdo(windows, []xproto.Window{1}, &sent)
+	// Control_L on keycode 8 and 'a' on 9.
+	x.max = 9
+	x.keyMap = []xproto.Keysym{0xffe3, 0x61}
+
+	b, err := x.BindWindowKeys("Control_L+a", WindowMatch{Class: "mumble"})
+	if err != nil {
+		t.Fatalf("BindWindowKeys: %v", err)
+	}
+	if err := b.Down(); err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+	if err := b.Up(); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	want := []sentKey{{1, true, 8}, {1, true, 9}, {1, false, 9}, {1, false, 8}}
+	if !slices.Equal(sent, want) {
+		t.Errorf("sent = %v, want %v", sent, want)
+	}
+}
+
+func TestWindowBinding_refindsReplacedWindow(t *testing.T) {
+	windows := map[xproto.Window]windowInfo{
+		2: {instance: "mumble", class: "Mumble"},
+	}
+	order := []xproto.Window{2}
+	var sent []sentKey
+	x := newWindowXdo(windows, order, &sent)
+	x.listClients = func() ([]xproto.Window, error) { return slices.Clone(order), nil }
+
+	b, err := x.BindWindowKeys("a", WindowMatch{Class: "Mumble"})
+	if err != nil {
+		t.Fatalf("BindWindowKeys: %v", err)
+	}
+	if err := b.Down(); err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+
+	// The application restarts while the key is held: the release for the
+	// old window is dropped, and the next press finds the new one.
+	delete(windows, 2)
+	windows[3] = windowInfo{instance: "mumble", class: "Mumble"}
+	order = []xproto.Window{3}
+	if err := b.Up(); err != nil {
+		t.Fatalf("Up after window was destroyed: %v", err)
+	}
+	if err := b.Down(); err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+
+	want := []sentKey{{2, true, 8}, {3, true, 8}}
+	if !slices.Equal(sent, want) {
+		t.Errorf("sent = %v, want %v", sent, want)
+	}
+}
+
+func TestWindowBinding_notFound(t *testing.T) {
+	var sent []sentKey
+	x := newWindowXdo(map[xproto.Window]windowInfo{1: {class: "Game"}}, []xproto.Window{1}, &sent)
+
+	b, err := x.BindWindowKeys("a", WindowMatch{PID: 7})
+	if err != nil {
+		t.Fatalf("BindWindowKeys: %v", err)
+	}
+	if err := b.Down(); !errors.Is(err, ErrWindowNotFound) {
+		t.Errorf("Down error = %v, want ErrWindowNotFound", err)
+	}
+	if err := b.Up(); err != nil {
+		t.Errorf("Up without Down: %v", err)
+	}
+	if len(sent) != 0 {
+		t.Errorf("sent = %v, want nothing", sent)
+	}
+}
+
+func TestWindowQueries(t *testing.T) {
+	windows := map[xproto.Window]windowInfo{
+		1: {instance: "game", class: "Game"},
+		2: {instance: "mumble", class: "Mumble"},
+	}
+	var sent []sentKey
+	x := newWindowXdo(windows, []xproto.Window{1, 2}, &sent)
+	active := xproto.Window(1)
+	x.queryActive = func() (xproto.Window, error) { return active, nil }
+
+	check := func(name string, f func(WindowMatch) (bool, error), m WindowMatch, want bool) {
+		t.Helper()
+		got, err := f(m)
+		if err != nil {
+			t.Fatalf("%v(%v): %v", name, m, err)
+		}
+		if got != want {
+			t.Errorf("%v(%v) = %v, want %v", name, m, got, want)
+		}
+	}
+
+	check("WindowExists", x.WindowExists, WindowMatch{Class: "mumble"}, true)
+	check("WindowExists", x.WindowExists, WindowMatch{Class: "discord"}, false)
+	check("ActiveWindowMatches", x.ActiveWindowMatches, WindowMatch{Class: "mumble"}, false)
+	check("ActiveWindowMatches", x.ActiveWindowMatches, WindowMatch{Class: "game"}, true)
+
+	active = 2
+	check("ActiveWindowMatches", x.ActiveWindowMatches, WindowMatch{Class: "mumble"}, true)
+	active = 0
+	check("ActiveWindowMatches", x.ActiveWindowMatches, WindowMatch{Class: "mumble"}, false)
+	active = 3 // destroyed after being reported
+	check("ActiveWindowMatches", x.ActiveWindowMatches, WindowMatch{Class: "mumble"}, false)
+
+	if _, err := x.WindowExists(WindowMatch{}); err == nil {
+		t.Error("WindowExists with empty match: expected error")
+	}
+}
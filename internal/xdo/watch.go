@@ -0,0 +1,128 @@
+package xdo
+
+import (
+	"sync/atomic"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/xproto"
+)
+
+// XKB event types (the second byte of every XKB event).
+const (
+	xkbNewKeyboardNotify = 0
+	xkbMapNotify         = 1
+	xkbStateNotify       = 2
+)
+
+// XKB event masks, as used by SelectEvents.
+const (
+	xkbEventNewKeyboardNotify = 1 << xkbNewKeyboardNotify
+	xkbEventMapNotify         = 1 << xkbMapNotify
+	xkbEventStateNotify       = 1 << xkbStateNotify
+)
+
+// xkbStatePartGroupState selects StateNotify events for changes of the
+// effective group only, so modifier presses do not wake the watcher.
+const xkbStatePartGroupState = 1 << 4
+
+// Bits of keymapWatch.dirty.
+const (
+	dirtyMap   = 1 << iota // keyboard mapping changed; reload everything
+	dirtyGroup             // only the active XKB group changed
+)
+
+// keymapWatch is shared between an [Xdo] and the goroutine that reads events
+// from its connection. The goroutine only ever sets flags and the latest
+// group; the Xdo owner picks them up on its next resolution, so the cached
+// keyboard map is only touched by the owner. It must not retain the *Xdo (see
+// connCleanup).
+type keymapWatch struct {
+	dirty atomic.Uint32
+	group atomic.Int32
+}
+
+// watchKeymap starts reading events from c. MappingNotify is delivered to
+// every client without selecting it; XKB notifications must have been
+// selected with xkbSelectKeymapEvents. The goroutine exits when c is closed.
+func watchKeymap(c *xgb.Conn) *keymapWatch {
+	w := &keymapWatch{}
+	go func() {
+		for {
+			ev, err := c.WaitForEvent()
+			if (ev == nil) && (err == nil) {
+				return
+			}
+			if ev != nil {
+				w.handle(ev)
+			}
+		}
+	}()
+	return w
+}
+
+// handle records the effect of a single X event.
+func (w *keymapWatch) handle(ev xgb.Event) {
+	switch ev := ev.(type) {
+	case xproto.MappingNotifyEvent:
+		if ev.Request != xproto.MappingPointer {
+			w.dirty.Or(dirtyMap)
+		}
+
+	case xkbEvent:
+		switch ev.xkbType() {
+		case xkbNewKeyboardNotify, xkbMapNotify:
+			w.dirty.Or(dirtyMap)
+		case xkbStateNotify:
+			if g, ok := ev.group(); ok {
+				w.group.Store(int32(g))
+				w.dirty.Or(dirtyGroup)
+			}
+		}
+	}
+}
+
+// xkbSelectKeymapEvents selects NewKeyboardNotify, MapNotify and group-only
+// StateNotify events on the core keyboard.
+func xkbSelectKeymapEvents(c *xgb.Conn) error {
+	const (
+		affect = xkbEventNewKeyboardNotify | xkbEventMapNotify | xkbEventStateNotify
+		all    = xkbEventNewKeyboardNotify | xkbEventMapNotify
+	)
+	buf := make([]byte, 16)
+	xgb.Put16(buf[0:], xkbUseCoreKbd)
+	xgb.Put16(buf[2:], affect)                       // affectWhich
+	xgb.Put16(buf[4:], 0)                            // clear
+	xgb.Put16(buf[6:], all)                          // selectAll
+	xgb.Put16(buf[8:], xkbMapKeyTypes|xkbMapKeySyms) // affectMap
+	xgb.Put16(buf[10:], xkbMapKeyTypes|xkbMapKeySyms)
+	// Details for StateNotify, the only event not covered by selectAll.
+	xgb.Put16(buf[12:], xkbStatePartGroupState) // affectState
+	xgb.Put16(buf[14:], xkbStatePartGroupState) // stateDetails
+	return xkbRequest(c, xkbSelectEvents, buf, false).Check()
+}
+
+// xkbEvent is any XKB event. They all share one event code and are told
+// apart by their second byte.
+type xkbEvent []byte
+
+func newXkbEvent(buf []byte) xgb.Event {
+	return xkbEvent(buf)
+}
+
+func (ev xkbEvent) Bytes() []byte  { return ev }
+func (ev xkbEvent) String() string { return "XkbEvent" }
+
+func (ev xkbEvent) xkbType() int {
+	if len(ev) < 2 {
+		return -1
+	}
+	return int(ev[1])
+}
+
+// group returns the effective group of a StateNotify event.
+func (ev xkbEvent) group() (int, bool) {
+	if (ev.xkbType() != xkbStateNotify) || (len(ev) < 14) {
+		return 0, false
+	}
+	return int(ev[13]), true
+}
@@ -0,0 +1,119 @@
+package xdo
+
+import (
+	"os"
+	"testing"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/xproto"
+)
+
+func stateNotify(group byte) xkbEvent {
+	ev := make(xkbEvent, 32)
+	ev[1] = xkbStateNotify
+	ev[13] = group
+	return ev
+}
+
+func TestKeymapWatch_handle(t *testing.T) {
+	cases := []struct {
+		name  string
+		ev    xgb.Event
+		dirty uint32
+		group int32
+	}{
+		{"keyboard mapping", xproto.MappingNotifyEvent{Request: xproto.MappingKeyboard}, dirtyMap, 0},
+		{"modifier mapping", xproto.MappingNotifyEvent{Request: xproto.MappingModifier}, dirtyMap, 0},
+		{"pointer mapping", xproto.MappingNotifyEvent{Request: xproto.MappingPointer}, 0, 0},
+		{"new keyboard", xkbEvent{0, xkbNewKeyboardNotify}, dirtyMap, 0},
+		{"map", xkbEvent{0, xkbMapNotify}, dirtyMap, 0},
+		{"state", stateNotify(2), dirtyGroup, 2},
+		{"short state", xkbEvent{0, xkbStateNotify}, 0, 0},
+		{"unrelated", xproto.KeyPressEvent{}, 0, 0},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var w keymapWatch
+			w.handle(tc.ev)
+			if got := w.dirty.Load(); got != tc.dirty {
+				t.Errorf("dirty = %#x, want %#x", got, tc.dirty)
+			}
+			if got := w.group.Load(); got != tc.group {
+				t.Errorf("group = %d, want %d", got, tc.group)
+			}
+		})
+	}
+}
+
+func TestRefresh_cached(t *testing.T) {
+	// The connection is never used: neither a clean nor a group-only refresh
+	// talks to the server.
+	x := &Xdo{conn: new(xgb.Conn), watch: new(keymapWatch), group: 1}
+
+	if err := x.refresh(); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if x.group != 1 {
+		t.Errorf("group = %d after clean refresh, want 1", x.group)
+	}
+
+	x.watch.handle(stateNotify(0))
+	if err := x.refresh(); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if x.group != 0 {
+		t.Errorf("group = %d after StateNotify, want 0", x.group)
+	}
+	if d := x.watch.dirty.Load(); d != 0 {
+		t.Errorf("dirty = %#x after refresh, want 0", d)
+	}
+}
+
+func TestSetModifierSynthesis_reload(t *testing.T) {
+	// As with OpenDisplay, the map was cached before synthesis was
+	// enabled, so without its modifier mapping.
+	x := &Xdo{conn: new(xgb.Conn), watch: new(keymapWatch)}
+
+	x.SetModifierSynthesis(false)
+	if d := x.watch.dirty.Load(); d != 0 {
+		t.Errorf("dirty = %#x after disabling synthesis, want 0", d)
+	}
+
+	x.SetModifierSynthesis(true)
+	if d := x.watch.dirty.Load(); d&dirtyMap == 0 {
+		t.Errorf("dirty = %#x after enabling synthesis, want the map to be reloaded", d)
+	}
+}
+
+// Live X benchmark comparing resolution with the cached map against a full
+// reload on every call. Only runs when DISPLAY is set.
+func BenchmarkKeycodes(b *testing.B) {
+	if os.Getenv("DISPLAY") == "" {
+		b.Skip("DISPLAY not set")
+	}
+
+	x, err := Open()
+	if err != nil {
+		b.Skipf("cannot open X display: %v", err)
+	}
+	defer x.Close()
+	if x.watch == nil {
+		b.Skip("keyboard notifications unavailable")
+	}
+
+	b.Run("cached", func(b *testing.B) {
+		for b.Loop() {
+			if _, err := x.Keycodes("Alt_L"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("reload", func(b *testing.B) {
+		for b.Loop() {
+			x.watch.dirty.Or(dirtyMap)
+			if _, err := x.Keycodes("Alt_L"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -1,24 +1,27 @@
 // Package xdo synthesizes keyboard and mouse input through the X protocol
 // (XTest), so X11/XWayland clients can receive push-to-talk events.
 //
-// This package is pure Go (no cgo). Keysym names in config are resolved with a
-// generated client-side name table (see keysyms.go and go:generate); the X
-// protocol does not provide name→keysym lookup.
+// This package is pure Go (no cgo). Keysym names are resolved with the
+// client-side name table of package keysym, as the X protocol does not provide
+// name→keysym lookup. Characters that no layout contains are typed through a
+// scratch binding (see below), like any other unmapped keysym.
 //
 // An [Xdo] value is not safe for concurrent use by multiple goroutines.
 //
-// # Keysym names
-//
-// Names match the usual X11 / xkbcommon macros with optional prefixes stripped
-// (XKB_KEY_, XK_, XF86XK_). Lookup is exact after that strip — no case folding.
-// Names are case-sensitive.
-//
 // # Keycode resolution
 //
-// Keycodes are taken only from the base column (index 0) of the server keyboard
-// map for each keycode. This package does not synthesize Shift/AltGr or other
-// modifiers. A keysym that appears only on a non-base level is rejected with an
-// error rather than injected as a bare keycode that would type the wrong symbol.
+// When the server supports the XKB extension, keycodes are resolved against
+// the XKB keyboard description for the active group (layout): a keysym
+// resolves to a keycode that produces it on the first level of that group, so
+// multi-layout setups pick the key that types the symbol in the layout that is
+// currently in use. Without XKB, the core keyboard map is used instead and
+// keycodes are taken only from its base column (index 0).
+//
+// By default this package does not synthesize Shift/AltGr or other modifiers.
+// A keysym that appears only on a non-base level or in another group is
+// rejected with an error rather than injected as a bare keycode that would
+// type the wrong symbol. Bindings can opt in to modifier synthesis via the XKB
+// extension instead; see [Xdo.SetModifierSynthesis].
 //
 // If a keysym has no base mapping at all, [Xdo] may install a process-lifetime
 // scratch binding: it finds a fully empty keycode (all columns NoSymbol), maps
@@ -33,31 +36,37 @@
 // client disconnect, so relying on GC alone is insufficient unless the GC
 // cleanup runs (it best-effort restores then closes). Prefer an explicit Close.
 //
-// [Xdo.Keycodes] reloads the server keyboard map on every call (when connected
-// to a live display), so layout and remapping changes are visible without
-// reconnecting. Installed scratch bindings are re-detected after each reload;
-// if a scratch cannot be re-bound, the reload fails closed (error returned).
+// The server keyboard map is cached and reloaded by [Xdo.Keycodes] only after
+// the server reports a change (MappingNotify, or the XKB NewKeyboardNotify and
+// MapNotify events), so layout and remapping changes are visible without
+// reconnecting. Switching the active XKB group only updates the group. If the
+// XKB events cannot be selected, the map is reloaded on every call instead.
+// Installed scratch bindings are re-detected after each reload; if a scratch
+// cannot be re-bound, the reload fails closed (error returned).
 // Prefer [Xdo.BindKeys] for hold-style injection: it re-resolves on Down but
 // releases the same keycodes on Up if the map changed mid-hold.
+//
+// [Xdo.BindWindowKeys] delivers keys to one window with SendEvent instead of
+// XTest, regardless of focus. Applications are free to ignore such synthetic
+// events, and many do.
 package xdo
 
 import (
+	"errors"
 	"fmt"
 	"maps"
 	"runtime"
 	"slices"
-	"strings"
 
+	"deedles.dev/ptt-fix/internal/keysym"
 	"github.com/jezek/xgb"
 	"github.com/jezek/xgb/xproto"
 	"github.com/jezek/xgb/xtest"
 )
 
-//go:generate go run ./gen_keysyms.go -o keysyms.go
-
-// keysymPrefixes are optional header-style prefixes, longest first, so at most
-// one prefix is stripped (e.g. XKB_KEY_ before XK_).
-var keysymPrefixes = []string{"XKB_KEY_", "XF86XK_", "XK_"}
+// errNeedsModifiers is returned (wrapped) when a keysym is mapped, but not
+// where it can be typed without modifiers in the active group.
+var errNeedsModifiers = errors.New("only available with modifiers")
 
 // scratchBinding records a process-lifetime keyboard-map slot installed for an
 // unmapped keysym, so it can be restored on Close (or GC cleanup).
@@ -125,12 +134,58 @@ type Xdo struct {
 	// without a live display. When both changeMapping and conn are nil, mapping
 	// changes update only the local keyMap cache.
 	changeMapping func(keycode xproto.Keycode, keysyms []xproto.Keysym) error
+
+	// hasXkb reports whether the XKB extension was initialized on conn. If
+	// so, xkb and group are reloaded along with keyMap and take precedence
+	// for resolution. A nil xkb falls back to the core keyMap alone.
+	hasXkb bool
+	xkb    *xkbMap
+	group  int // active XKB group (0-based)
+
+	// watch, if non-nil, reports server keyboard changes so refresh can skip
+	// reloading an unchanged map. It is set by [Open].
+	watch *keymapWatch
+
+	// synthesize enables modifier synthesis in KeyBinding (see
+	// [Xdo.SetModifierSynthesis]). modKeycodes is only loaded while it is set.
+	synthesize  bool
+	modKeycodes [8][]xproto.Keycode
+
+	// queryState and latch, if non-nil, replace the XKB state query and group
+	// latch used by modifier synthesis. Used by tests without a live display.
+	queryState func() (xkbState, []byte, error)
+	latch      func(latch int16) error
+
+	// atoms caches interned atoms used by window bindings.
+	atoms map[string]xproto.Atom
+
+	// listClients, queryWindow, queryActive and send, if non-nil, replace
+	// the window lookups and SendEvent used by [WindowBinding] and the
+	// window queries. Used by tests without a live display.
+	listClients func() ([]xproto.Window, error)
+	queryWindow func(win xproto.Window) (windowInfo, error)
+	queryActive func() (xproto.Window, error)
+	send        func(win xproto.Window, press bool, keycode byte) error
 }
 
 // Open connects to the default X display ($DISPLAY), initializes the XTest
 // extension, and loads the server keyboard map for [Xdo.Keycodes] lookups.
+// It is equivalent to OpenDisplay("", "").
 func Open() (*Xdo, error) {
-	conn, err := xgb.NewConn()
+	return OpenDisplay("", "")
+}
+
+// OpenDisplay is like [Open], but connects to the named display (such as
+// ":1" or "host:0.0"), or $DISPLAY if name is empty. If xauthority is not
+// empty, the display's cookie is read from that Xauthority file. Otherwise
+// $XAUTHORITY, ~/.Xauthority and the files GNOME and KDE create for
+// XWayland in $XDG_RUNTIME_DIR are tried in turn. See also [WaitDisplay].
+func OpenDisplay(name, xauthority string) (*Xdo, error) {
+	d, err := parseDisplay(name)
+	if err != nil {
+		return nil, fmt.Errorf("connect to X display: %w", err)
+	}
+	conn, err := dialDisplay(d, xauthority)
 	if err != nil {
 		return nil, fmt.Errorf("connect to X display: %w", err)
 	}
@@ -145,6 +200,13 @@ func Open() (*Xdo, error) {
 		scratches: make(map[xproto.Keysym]scratchBinding),
 	}
 	x := &Xdo{conn: conn, cleanupState: cc, scratches: cc.scratches}
+	// XKB is optional; without it only base-level keysyms resolve.
+	x.hasXkb = xkbInit(conn) == nil
+	// Without the XKB notifications a group switch would go unnoticed, so
+	// only cache the map if they could be selected.
+	if !x.hasXkb || (xkbSelectKeymapEvents(conn) == nil) {
+		x.watch = watchKeymap(conn)
+	}
 	if err := x.refreshKeyboardMap(); err != nil {
 		conn.Close()
 		return nil, err
@@ -175,38 +237,85 @@ func (x *Xdo) Close() {
 	}
 }
 
-// KeysymByName looks up an X11/xkb-style keysym name (e.g. "Alt_L") without
-// needing a display connection. Names are exact (case-sensitive) after optional
-// prefix stripping (see package docs).
-func KeysymByName(name string) (uint32, bool) {
-	return lookupKeysym(name)
+// Reachability describes how a keysym can be typed on the current keymap.
+type Reachability uint8
+
+const (
+	// Unmapped keysyms are not on the keymap at all. Injecting them needs a
+	// scratch binding.
+	Unmapped Reachability = iota
+	// BaseLevel keysyms are typed by a key without modifiers in the active
+	// group.
+	BaseLevel
+	// NeedsModifiers keysyms are on the keymap, but only on another level or
+	// in another group. Injecting them needs modifier synthesis.
+	NeedsModifiers
+)
+
+func (r Reachability) String() string {
+	switch r {
+	case Unmapped:
+		return "unmapped"
+	case BaseLevel:
+		return "base"
+	case NeedsModifiers:
+		return "modifiers"
+	default:
+		return fmt.Sprintf("Reachability(%d)", uint8(r))
+	}
+}
+
+// Reachable reports how sym can be typed on the current keymap, following
+// the same rules as [Xdo.Keycodes] but without installing a scratch binding.
+func (x *Xdo) Reachable(sym uint32) (Reachability, error) {
+	if x == nil {
+		return Unmapped, fmt.Errorf("xdo connection closed")
+	}
+	if err := x.refresh(); err != nil {
+		return Unmapped, err
+	}
+
+	ks := xproto.Keysym(sym)
+	if x.xkb != nil {
+		if _, ok := x.xkb.baseKeycode(ks, x.group); ok {
+			return BaseLevel, nil
+		}
+		if x.xkb.contains(ks) {
+			return NeedsModifiers, nil
+		}
+	}
+	if _, ok := x.findBaseKeycode(ks); ok {
+		return BaseLevel, nil
+	}
+	if x.keysymOnNonBase(ks) {
+		return NeedsModifiers, nil
+	}
+	return Unmapped, nil
 }
 
 // Keycodes resolves a keysym name (or libxdo-style sequence of names joined
 // by '+') to one or more X keycodes using the current server keyboard map.
 // When a live connection is present, the map is reloaded from the server first
-// so layout changes are observed. Only base column mappings are used (see
-// package docs).
+// if it changed since the last call. Only keycodes that produce the keysym
+// without modifiers in the active group are used (see package docs).
 func (x *Xdo) Keycodes(keys string) ([]byte, error) {
 	if x == nil {
 		return nil, fmt.Errorf("xdo connection closed")
 	}
-	if x.conn != nil {
-		if err := x.refreshKeyboardMap(); err != nil {
-			return nil, err
-		}
+	if err := x.refresh(); err != nil {
+		return nil, err
 	}
 
-	parts := splitKeysequence(keys)
+	parts := keysym.Split(keys)
 	if len(parts) == 0 {
 		return nil, fmt.Errorf("empty key sequence")
 	}
 
 	out := make([]byte, 0, len(parts))
 	for _, part := range parts {
-		sym, ok := lookupKeysym(part)
+		sym, ok := keysym.Lookup(part)
 		if !ok {
-			return nil, fmt.Errorf("unknown keysym %q", part)
+			return nil, keysym.UnknownError(part)
 		}
 		kc, err := x.keycodeForKeysym(xproto.Keysym(sym))
 		if err != nil {
@@ -225,6 +334,12 @@ type KeyBinding struct {
 	x    *Xdo
 	keys string
 	held []byte
+
+	// With modifier synthesis, the modifier keys Down pressed and the user's
+	// modifier keys it released, to be undone by Up.
+	synth    bool
+	pressed  []byte
+	released []byte
 }
 
 // BindKeys validates keys against the current map and returns a [KeyBinding].
@@ -232,17 +347,43 @@ func (x *Xdo) BindKeys(keys string) (*KeyBinding, error) {
 	if x == nil {
 		return nil, fmt.Errorf("xdo connection closed")
 	}
+	if x.synthesize {
+		if err := x.refresh(); err != nil {
+			return nil, err
+		}
+		st, _, err := x.keyboardState()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := x.resolvePresses(keys, st); err != nil {
+			return nil, err
+		}
+		return &KeyBinding{x: x, keys: keys}, nil
+	}
 	if _, err := x.Keycodes(keys); err != nil {
 		return nil, err
 	}
 	return &KeyBinding{x: x, keys: keys}, nil
 }
 
-// Down resolves the binding's key names to keycodes and sends presses.
+// Down resolves the binding's key names to keycodes and sends presses. With
+// modifier synthesis enabled, the modifiers and group each key needs are set
+// up around it (see [Xdo.SetModifierSynthesis]).
 func (b *KeyBinding) Down() error {
 	if b == nil || b.x == nil {
 		return fmt.Errorf("xdo connection closed")
 	}
+	if b.x.synthesize {
+		if err := b.x.refresh(); err != nil {
+			return err
+		}
+		held, pressed, released, err := b.x.synthDown(b.keys)
+		if err != nil {
+			return err
+		}
+		b.held, b.pressed, b.released, b.synth = held, pressed, released, true
+		return nil
+	}
 	kcs, err := b.x.Keycodes(b.keys)
 	if err != nil {
 		return err
@@ -261,6 +402,11 @@ func (b *KeyBinding) Up() error {
 	if b == nil || b.x == nil {
 		return fmt.Errorf("xdo connection closed")
 	}
+	if b.synth {
+		err := b.x.synthUp(b.held, b.pressed, b.released)
+		b.held, b.pressed, b.released, b.synth = nil, nil, nil, false
+		return err
+	}
 	kcs := b.held
 	if kcs == nil {
 		var err error
@@ -274,11 +420,41 @@ func (b *KeyBinding) Up() error {
 	return err
 }
 
+// refresh reloads the keyboard map when connected to a live display. With a
+// watcher, only changes reported since the last refresh are picked up.
+func (x *Xdo) refresh() error {
+	if x.conn == nil {
+		return nil
+	}
+	if x.watch == nil {
+		return x.refreshKeyboardMap()
+	}
+
+	// Clear the flags before reloading so that changes arriving during the
+	// reload are picked up next time.
+	dirty := x.watch.dirty.Swap(0)
+	switch {
+	case dirty&dirtyMap != 0:
+		if err := x.refreshKeyboardMap(); err != nil {
+			x.watch.dirty.Or(dirtyMap)
+			return err
+		}
+		if x.hasXkb && (x.xkb == nil) {
+			// refreshXkb failed; retry rather than stay on the core map.
+			x.watch.dirty.Or(dirtyMap)
+		}
+	case dirty&dirtyGroup != 0:
+		x.group = int(x.watch.group.Load())
+	}
+	return nil
+}
+
 // refreshKeyboardMap loads min/max keycodes and the full keysym table from the
-// connected X server. Returns an error if conn is nil (synthetic *Xdo values
-// without a connection do not call this; [Xdo.Keycodes] skips the reload when
-// conn is nil). After reloading, process-lifetime scratch bindings are
-// re-detected and re-applied if needed; failure to re-bind fails closed.
+// connected X server, along with the XKB map and active group if available.
+// Returns an error if conn is nil; synthetic *Xdo values without a connection
+// do not call this, and [Xdo.Keycodes] skips the reload when conn is nil.
+// After reloading, process-lifetime scratch bindings are re-detected and
+// re-applied if needed; failure to re-bind fails closed.
 func (x *Xdo) refreshKeyboardMap() error {
 	if x.conn == nil {
 		return fmt.Errorf("xdo connection closed")
@@ -299,6 +475,14 @@ func (x *Xdo) refreshKeyboardMap() error {
 	x.max = max
 	x.keysymsPerKeycode = reply.KeysymsPerKeycode
 	x.keyMap = reply.Keysyms
+	if x.hasXkb {
+		x.refreshXkb()
+	}
+	if x.synthesize {
+		if err := x.refreshModifierMapping(); err != nil {
+			return err
+		}
+	}
 	return x.ensureScratches()
 }
 
@@ -383,20 +567,33 @@ func (x *Xdo) fakeInput(evType byte, detail byte) error {
 	return xtest.FakeInputChecked(x.conn, evType, detail, 0, 0, 0, 0, 0).Check()
 }
 
-// keycodeForKeysym finds a keycode whose base-column (index 0) keysym equals
-// sym. If none exists, it may install a process-lifetime scratch binding on a
-// fully empty keycode (see package docs). If the keysym only appears on a
-// non-base level, an error is returned without scratch binding.
+// keycodeForKeysym finds a keycode that types sym without modifiers: on the
+// first level of the active group in the XKB map if there is one, otherwise in
+// the base column (index 0) of the core map. If none exists, it may install a
+// process-lifetime scratch binding on a fully empty keycode (see package docs).
+// If the keysym only appears on a non-base level or in another group, an error
+// wrapping errNeedsModifiers is returned without scratch binding.
 func (x *Xdo) keycodeForKeysym(sym xproto.Keysym) (xproto.Keycode, error) {
 	per := int(x.keysymsPerKeycode)
 	if per == 0 {
 		return 0, fmt.Errorf("keyboard map has no keysyms per keycode")
 	}
+	if x.xkb != nil {
+		if kc, ok := x.xkb.baseKeycode(sym, x.group); ok {
+			return kc, nil
+		}
+		if x.xkb.contains(sym) {
+			return 0, fmt.Errorf("%w in the active group (base-level keysyms only unless modifier synthesis is enabled)", errNeedsModifiers)
+		}
+		// Not in the XKB map at all: it may be a scratch binding that the
+		// (possibly stale) XKB map does not show yet, which the core map
+		// tracks locally.
+	}
 	if kc, ok := x.findBaseKeycode(sym); ok {
 		return kc, nil
 	}
 	if x.keysymOnNonBase(sym) {
-		return 0, fmt.Errorf("only available with modifiers (base-level keysyms only; no auto Shift/AltGr)")
+		return 0, fmt.Errorf("%w (base-level keysyms only unless modifier synthesis is enabled)", errNeedsModifiers)
 	}
 	// Recorded scratch but base lookup missed it (stale local map). Re-ensure
 	// then resolve again; ensure may re-apply, move, or drop the scratch if a
@@ -686,42 +883,3 @@ func (x *Xdo) restoreScratches() {
 	// Clear in place so Open's shared cleanupState map stays the same reference.
 	clear(x.scratches)
 }
-
-func lookupKeysym(name string) (uint32, bool) {
-	name = strings.TrimSpace(name)
-	if name == "" {
-		return 0, false
-	}
-	name = stripKeysymPrefix(name)
-	v, ok := keysyms[name]
-	return v, ok
-}
-
-// stripKeysymPrefix removes at most one known header-style prefix, matching the
-// longest applicable prefix first.
-func stripKeysymPrefix(name string) string {
-	for _, p := range keysymPrefixes {
-		if strings.HasPrefix(name, p) {
-			return name[len(p):]
-		}
-	}
-	return name
-}
-
-// splitKeysequence splits libxdo/xdotool-style sequences ("Control_L+Alt_L").
-// A single bare name has one part.
-func splitKeysequence(keys string) []string {
-	keys = strings.TrimSpace(keys)
-	if keys == "" {
-		return nil
-	}
-	parts := strings.Split(keys, "+")
-	out := make([]string, 0, len(parts))
-	for _, p := range parts {
-		p = strings.TrimSpace(p)
-		if p != "" {
-			out = append(out, p)
-		}
-	}
-	return out
-}
@@ -0,0 +1,446 @@
+package xdo
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"slices"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/xproto"
+)
+
+// jezek/xgb does not ship bindings for the XKEYBOARD extension, so the few
+// requests this package needs are encoded by hand below, following the XKB
+// protocol specification. Replies are parsed defensively: a short or
+// inconsistent reply is an error, never a panic.
+
+const xkbExtName = "XKEYBOARD"
+
+// XKB minor opcodes.
+const (
+	xkbUseExtension   = 0
+	xkbSelectEvents   = 1
+	xkbGetState       = 4
+	xkbLatchLockState = 5
+	xkbGetMap         = 8
+)
+
+// xkbUseCoreKbd is the XKB device spec for the core keyboard.
+const xkbUseCoreKbd = 0x100
+
+// XKB map parts, as used by GetMap.
+const (
+	xkbMapKeyTypes = 1 << 0
+	xkbMapKeySyms  = 1 << 1
+)
+
+// xkbInit queries and enables the XKEYBOARD extension on c. It mirrors the
+// Init functions of the generated xgb extension packages.
+func xkbInit(c *xgb.Conn) error {
+	reply, err := xproto.QueryExtension(c, uint16(len(xkbExtName)), xkbExtName).Reply()
+	if err != nil {
+		return err
+	}
+	if !reply.Present {
+		return errors.New("no extension named XKEYBOARD could be found on the server")
+	}
+
+	c.ExtLock.Lock()
+	c.Extensions[xkbExtName] = reply.MajorOpcode
+	c.ExtLock.Unlock()
+	// XKB defines a single error (Keyboard) and a single event code shared
+	// by all of its events.
+	xgb.NewErrorFuncs[int(reply.FirstError)] = newXkbError
+	xgb.NewEventFuncs[int(reply.FirstEvent)] = newXkbEvent
+
+	buf := make([]byte, 4)
+	xgb.Put16(buf[0:], 1) // wanted major
+	xgb.Put16(buf[2:], 0) // wanted minor
+	rep, err := xkbRequest(c, xkbUseExtension, buf, true).Reply()
+	if err != nil {
+		return fmt.Errorf("use extension: %w", err)
+	}
+	if len(rep) < 12 {
+		return errors.New("short UseExtension reply")
+	}
+	if rep[1] == 0 {
+		return fmt.Errorf("server XKB version %d.%d not compatible with 1.0", xgb.Get16(rep[8:]), xgb.Get16(rep[10:]))
+	}
+	return nil
+}
+
+// xkbRequest sends an XKB request with the given minor opcode and body.
+// The body is padded to a multiple of four bytes. The returned cookie is
+// always checked.
+func xkbRequest(c *xgb.Conn, minor byte, body []byte, reply bool) *xgb.Cookie {
+	size := 4 + len(body) + xgb.Pad(len(body))
+	buf := make([]byte, size)
+	c.ExtLock.RLock()
+	buf[0] = c.Extensions[xkbExtName]
+	c.ExtLock.RUnlock()
+	buf[1] = minor
+	xgb.Put16(buf[2:], uint16(size/4))
+	copy(buf[4:], body)
+
+	cookie := c.NewCookie(true, reply)
+	c.NewRequest(buf, cookie)
+	return cookie
+}
+
+// xkbError is the XKB Keyboard error.
+type xkbError struct {
+	sequence uint16
+	value    uint32
+	minor    uint16
+	major    byte
+}
+
+func newXkbError(buf []byte) xgb.Error {
+	return xkbError{
+		sequence: xgb.Get16(buf[2:]),
+		value:    xgb.Get32(buf[4:]),
+		minor:    xgb.Get16(buf[8:]),
+		major:    buf[10],
+	}
+}
+
+func (e xkbError) SequenceId() uint16 { return e.sequence }
+func (e xkbError) BadId() uint32      { return e.value }
+
+func (e xkbError) Error() string {
+	return fmt.Sprintf("XKB keyboard error (value 0x%x, request %d.%d)", e.value, e.major, e.minor)
+}
+
+// xkbState is the subset of the XKB keyboard state needed to work out which
+// level and group a key press will produce.
+type xkbState struct {
+	mods        uint8 // effective modifiers
+	group       uint8 // effective group
+	baseGroup   int16
+	lockedGroup uint8
+}
+
+func xkbGetStateReply(c *xgb.Conn) (xkbState, error) {
+	buf := make([]byte, 4)
+	xgb.Put16(buf, xkbUseCoreKbd)
+	rep, err := xkbRequest(c, xkbGetState, buf, true).Reply()
+	if err != nil {
+		return xkbState{}, fmt.Errorf("get XKB state: %w", err)
+	}
+	return parseXkbState(rep)
+}
+
+func parseXkbState(rep []byte) (xkbState, error) {
+	if len(rep) < 18 {
+		return xkbState{}, errors.New("short XKB GetState reply")
+	}
+	return xkbState{
+		mods:        rep[8],
+		group:       rep[12],
+		lockedGroup: rep[13],
+		baseGroup:   int16(xgb.Get16(rep[14:])),
+	}, nil
+}
+
+// xkbLatchGroup latches group for the next key press. The latched group is
+// relative: the effective group becomes base + latched + locked.
+func xkbLatchGroup(c *xgb.Conn, latch int16) error {
+	buf := make([]byte, 12)
+	xgb.Put16(buf[0:], xkbUseCoreKbd)
+	// affectModLocks, modLocks, lockGroup, groupLock, affectModLatches,
+	// modLatches and one unused byte are all left zero.
+	buf[9] = 1 // latchGroup
+	xgb.Put16(buf[10:], uint16(latch))
+	if err := xkbRequest(c, xkbLatchLockState, buf, false).Check(); err != nil {
+		return fmt.Errorf("latch XKB group: %w", err)
+	}
+	return nil
+}
+
+// xkbMap is the part of an XKB keyboard description needed to find the
+// group, level and modifiers that produce a keysym.
+type xkbMap struct {
+	min   xproto.Keycode
+	max   xproto.Keycode
+	types []xkbKeyType
+	// keys is indexed by keycode-min.
+	keys []xkbKeySyms
+}
+
+// xkbKeyType maps modifier combinations to shift levels.
+type xkbKeyType struct {
+	mask    uint8 // modifiers that matter for this type
+	levels  byte
+	entries []xkbTypeEntry
+}
+
+type xkbTypeEntry struct {
+	active bool
+	mods   uint8 // effective (real) modifier mask
+	level  byte
+}
+
+// xkbKeySyms are the keysyms of a single keycode, laid out group-major:
+// syms[group*width+level].
+type xkbKeySyms struct {
+	types     [4]byte // key type index per group
+	groupInfo byte    // low nibble: number of groups
+	width     byte
+	syms      []xproto.Keysym
+}
+
+func (k xkbKeySyms) groups() int {
+	return int(k.groupInfo & 0x0f)
+}
+
+// sym returns the keysym at group and level, or NoSymbol.
+func (k xkbKeySyms) sym(group, level int) xproto.Keysym {
+	i := group*int(k.width) + level
+	if (group >= k.groups()) || (level >= int(k.width)) || (i >= len(k.syms)) {
+		return 0
+	}
+	return k.syms[i]
+}
+
+func xkbGetMapReply(c *xgb.Conn) (*xkbMap, error) {
+	buf := make([]byte, 24)
+	xgb.Put16(buf[0:], xkbUseCoreKbd)
+	xgb.Put16(buf[2:], xkbMapKeyTypes|xkbMapKeySyms) // full
+	// partial and all first/count pairs are zero: "full" fetches everything.
+	rep, err := xkbRequest(c, xkbGetMap, buf, true).Reply()
+	if err != nil {
+		return nil, fmt.Errorf("get XKB map: %w", err)
+	}
+	return parseXkbMap(rep)
+}
+
+// parseXkbMap decodes a GetMap reply containing the KeyTypes and KeySyms
+// components.
+func parseXkbMap(rep []byte) (*xkbMap, error) {
+	const header = 40
+	if len(rep) < header {
+		return nil, errors.New("short XKB GetMap reply")
+	}
+	m := &xkbMap{
+		min: xproto.Keycode(rep[10]),
+		max: xproto.Keycode(rep[11]),
+	}
+	present := xgb.Get16(rep[12:])
+	firstType, nTypes := int(rep[14]), int(rep[15])
+	firstKeySym, nKeySyms := xproto.Keycode(rep[17]), int(rep[20])
+	if m.max < m.min {
+		return nil, fmt.Errorf("invalid XKB keycode range [%d, %d]", m.min, m.max)
+	}
+
+	r := xkbReader{buf: rep, off: header}
+	if present&xkbMapKeyTypes != 0 {
+		m.types = make([]xkbKeyType, firstType+nTypes)
+		for i := range nTypes {
+			t, err := r.keyType()
+			if err != nil {
+				return nil, fmt.Errorf("key type %d: %w", firstType+i, err)
+			}
+			m.types[firstType+i] = t
+		}
+	}
+
+	m.keys = make([]xkbKeySyms, int(m.max-m.min)+1)
+	if present&xkbMapKeySyms != 0 {
+		if (nKeySyms > 0) && ((firstKeySym < m.min) || (int(firstKeySym-m.min)+nKeySyms > len(m.keys))) {
+			return nil, fmt.Errorf("XKB key syms [%d, +%d) outside keycode range", firstKeySym, nKeySyms)
+		}
+		for i := range nKeySyms {
+			k, err := r.keySyms()
+			if err != nil {
+				return nil, fmt.Errorf("key syms for keycode %d: %w", int(firstKeySym)+i, err)
+			}
+			m.keys[int(firstKeySym-m.min)+i] = k
+		}
+	}
+	return m, nil
+}
+
+// xkbReader is a bounds-checked cursor over an XKB reply.
+type xkbReader struct {
+	buf []byte
+	off int
+}
+
+func (r *xkbReader) next(n int) ([]byte, error) {
+	if r.off+n > len(r.buf) {
+		return nil, errors.New("truncated reply")
+	}
+	b := r.buf[r.off : r.off+n]
+	r.off += n
+	return b, nil
+}
+
+func (r *xkbReader) keyType() (xkbKeyType, error) {
+	b, err := r.next(8)
+	if err != nil {
+		return xkbKeyType{}, err
+	}
+	t := xkbKeyType{mask: b[0], levels: b[4]}
+	n, preserve := int(b[5]), b[6] != 0
+
+	t.entries = make([]xkbTypeEntry, n)
+	for i := range t.entries {
+		e, err := r.next(8)
+		if err != nil {
+			return xkbKeyType{}, err
+		}
+		t.entries[i] = xkbTypeEntry{active: e[0] != 0, mods: e[1], level: e[2]}
+	}
+	if preserve {
+		if _, err := r.next(4 * n); err != nil {
+			return xkbKeyType{}, err
+		}
+	}
+	return t, nil
+}
+
+func (r *xkbReader) keySyms() (xkbKeySyms, error) {
+	b, err := r.next(8)
+	if err != nil {
+		return xkbKeySyms{}, err
+	}
+	k := xkbKeySyms{groupInfo: b[4], width: b[5]}
+	copy(k.types[:], b[:4])
+	n := int(xgb.Get16(b[6:]))
+
+	sb, err := r.next(4 * n)
+	if err != nil {
+		return xkbKeySyms{}, err
+	}
+	k.syms = make([]xproto.Keysym, n)
+	for i := range k.syms {
+		k.syms[i] = xproto.Keysym(xgb.Get32(sb[4*i:]))
+	}
+	return k, nil
+}
+
+// xkbLevel is a place in the XKB map where a keysym can be produced.
+type xkbLevel struct {
+	keycode xproto.Keycode
+	group   int // group to latch, or -1 if reachable in the active group
+	level   int
+	mods    uint8 // modifiers needed to reach level
+	mask    uint8 // modifiers that affect the key's level at all
+}
+
+// effectiveGroup maps the keyboard's active group onto one of k's groups,
+// following the key's out-of-range group handling.
+func (k xkbKeySyms) effectiveGroup(group int) (int, bool) {
+	n := k.groups()
+	switch {
+	case n == 0:
+		return 0, false
+	case group < n:
+		return group, true
+	}
+
+	switch k.groupInfo & 0xc0 {
+	case 0x40: // ClampIntoRange
+		return n - 1, true
+	case 0x80: // RedirectIntoRange
+		if r := int(k.groupInfo>>4) & 3; r < n {
+			return r, true
+		}
+		return 0, true
+	default: // WrapIntoRange
+		return group % n, true
+	}
+}
+
+// baseKeycode returns a keycode that produces sym at level 1 of the active
+// group, which is what pressing it without modifiers types.
+func (m *xkbMap) baseKeycode(sym xproto.Keysym, group int) (xproto.Keycode, bool) {
+	if sym == 0 {
+		return 0, false
+	}
+	for i, k := range m.keys {
+		g, ok := k.effectiveGroup(group)
+		if ok && (k.sym(g, 0) == sym) {
+			return m.min + xproto.Keycode(i), true
+		}
+	}
+	return 0, false
+}
+
+// contains reports whether sym appears anywhere in the map.
+func (m *xkbMap) contains(sym xproto.Keysym) bool {
+	if sym == 0 {
+		return false
+	}
+	for _, k := range m.keys {
+		if slices.Contains(k.syms, sym) {
+			return true
+		}
+	}
+	return false
+}
+
+// find returns where sym can be typed. It prefers places reachable in the
+// active group, then lower levels, then lower keycodes. Levels that no
+// modifier combination reaches are skipped.
+func (m *xkbMap) find(sym xproto.Keysym, group int) (xkbLevel, bool) {
+	var best xkbLevel
+	found := false
+	better := func(c xkbLevel) bool {
+		if !found {
+			return true
+		}
+		if (c.group < 0) != (best.group < 0) {
+			return c.group < 0
+		}
+		return c.level < best.level
+	}
+
+	for i, k := range m.keys {
+		active, activeOK := k.effectiveGroup(group)
+		for g := range k.groups() {
+			for l := range int(k.width) {
+				if (sym == 0) || (k.sym(g, l) != sym) {
+					continue
+				}
+				mods, mask, ok := m.levelMods(k, g, l)
+				if !ok {
+					continue
+				}
+				c := xkbLevel{keycode: m.min + xproto.Keycode(i), group: g, level: l, mods: mods, mask: mask}
+				if activeOK && (g == active) {
+					c.group = -1
+				}
+				if better(c) {
+					best, found = c, true
+				}
+			}
+		}
+	}
+	return best, found
+}
+
+// levelMods returns the smallest modifier combination that selects level
+// of the key type used by k in group, along with the type's modifier mask.
+func (m *xkbMap) levelMods(k xkbKeySyms, group, level int) (mods, mask uint8, ok bool) {
+	ti := int(k.types[group&3])
+	if ti >= len(m.types) {
+		return 0, 0, level == 0
+	}
+	t := m.types[ti]
+	if level == 0 {
+		return 0, t.mask, true
+	}
+
+	found := false
+	for _, e := range t.entries {
+		if !e.active || (int(e.level) != level) {
+			continue
+		}
+		if !found || (bits.OnesCount8(e.mods) < bits.OnesCount8(mods)) {
+			mods, found = e.mods, true
+		}
+	}
+	return mods, t.mask, found
+}
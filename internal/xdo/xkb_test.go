@@ -0,0 +1,243 @@
+package xdo
+
+import (
+	"encoding/binary"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/xproto"
+)
+
+// getMapReply encodes a GetMap reply with the KeyTypes and KeySyms
+// components, as a server would send it (xgb always uses little-endian).
+func getMapReply(m *xkbMap) []byte {
+	buf := make([]byte, 40)
+	buf[0] = 1
+	buf[10], buf[11] = byte(m.min), byte(m.max)
+	xgb.Put16(buf[12:], xkbMapKeyTypes|xkbMapKeySyms)
+	buf[15] = byte(len(m.types))
+	buf[16] = byte(len(m.types))
+	buf[17] = byte(m.min)
+	buf[20] = byte(len(m.keys))
+
+	for _, t := range m.types {
+		buf = append(buf, t.mask, t.mask, 0, 0, t.levels, byte(len(t.entries)), 0, 0)
+		for _, e := range t.entries {
+			active := byte(0)
+			if e.active {
+				active = 1
+			}
+			buf = append(buf, active, e.mods, e.level, e.mods, 0, 0, 0, 0)
+		}
+	}
+	for _, k := range m.keys {
+		buf = append(buf, k.types[0], k.types[1], k.types[2], k.types[3], k.groupInfo, k.width)
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(len(k.syms)))
+		for _, s := range k.syms {
+			buf = binary.LittleEndian.AppendUint32(buf, uint32(s))
+		}
+	}
+	xgb.Put32(buf[4:], uint32((len(buf)-32)/4))
+	return buf
+}
+
+func TestParseXkbMap_roundTrip(t *testing.T) {
+	var events []inputEvent
+	want := newSynthXdo(&events).xkb
+
+	got, err := parseXkbMap(getMapReply(want))
+	if err != nil {
+		t.Fatalf("parseXkbMap: %v", err)
+	}
+	if got.min != want.min || got.max != want.max {
+		t.Fatalf("range = [%d, %d], want [%d, %d]", got.min, got.max, want.min, want.max)
+	}
+	if len(got.types) != len(want.types) {
+		t.Fatalf("types = %d, want %d", len(got.types), len(want.types))
+	}
+	for i := range want.types {
+		g, w := got.types[i], want.types[i]
+		if g.mask != w.mask || g.levels != w.levels || !slices.Equal(g.entries, w.entries) {
+			t.Errorf("type %d = %+v, want %+v", i, g, w)
+		}
+	}
+	if len(got.keys) != len(want.keys) {
+		t.Fatalf("keys = %d, want %d", len(got.keys), len(want.keys))
+	}
+	for i := range want.keys {
+		g, w := got.keys[i], want.keys[i]
+		if g.types != w.types || g.groupInfo != w.groupInfo || g.width != w.width || !slices.Equal(g.syms, w.syms) {
+			t.Errorf("key %d = %+v, want %+v", i, g, w)
+		}
+	}
+
+	l, ok := got.find(symAt, 0)
+	if !ok || l.keycode != kcQ || l.level != 2 || l.mods != modMod5 {
+		t.Fatalf("find(at) = %+v, %v", l, ok)
+	}
+}
+
+func TestParseXkbMap_truncated(t *testing.T) {
+	var events []inputEvent
+	rep := getMapReply(newSynthXdo(&events).xkb)
+	for _, n := range []int{0, 39, 40, 47, len(rep) - 1} {
+		if _, err := parseXkbMap(rep[:n]); err == nil {
+			t.Errorf("parseXkbMap(%d of %d bytes): expected error", n, len(rep))
+		}
+	}
+}
+
+func TestXkbMapFind_prefersGroupThenLevel(t *testing.T) {
+	m := &xkbMap{
+		min:   8,
+		max:   10,
+		types: []xkbKeyType{{levels: 1}, {mask: modShift, levels: 2, entries: []xkbTypeEntry{{active: true, mods: modShift, level: 1}}}},
+		keys: []xkbKeySyms{
+			{types: [4]byte{1, 1}, groupInfo: 2, width: 2, syms: []xproto.Keysym{0, symAt, symAt, 0}},
+			{types: [4]byte{0}, groupInfo: 1, width: 1, syms: []xproto.Keysym{symQ}},
+			{types: [4]byte{0, 0}, groupInfo: 2, width: 1, syms: []xproto.Keysym{symLowerA, symCyrillicA}},
+		},
+	}
+
+	cases := []struct {
+		sym   xproto.Keysym
+		group int
+		want  xkbLevel
+	}{
+		{symAt, 0, xkbLevel{keycode: 8, group: -1, level: 1, mods: modShift, mask: modShift}},
+		{symAt, 1, xkbLevel{keycode: 8, group: -1, level: 0, mask: modShift}},
+		// Single-group keys wrap into every group.
+		{symQ, 1, xkbLevel{keycode: 9, group: -1}},
+		// Only in another group: that group must be latched.
+		{symCyrillicA, 0, xkbLevel{keycode: 10, group: 1}},
+	}
+	for _, tc := range cases {
+		l, ok := m.find(tc.sym, tc.group)
+		if !ok || l != tc.want {
+			t.Errorf("find(0x%x, %d) = %+v, %v; want %+v", tc.sym, tc.group, l, ok, tc.want)
+		}
+	}
+	if _, ok := m.find(symUpperQ, 0); ok {
+		t.Error("find(Q) should fail")
+	}
+}
+
+func TestXkbKeySyms_effectiveGroup(t *testing.T) {
+	cases := []struct {
+		groupInfo byte
+		group     int
+		want      int
+		ok        bool
+	}{
+		{0x00, 0, 0, false},
+		{0x02, 1, 1, true},
+		{0x02, 3, 1, true},            // wrap
+		{0x03, 3, 0, true},            // wrap
+		{0x42, 3, 1, true},            // clamp
+		{0x80 | 0x10 | 2, 3, 1, true}, // redirect to group 2 (1-based)
+		{0x80 | 0x30 | 2, 3, 0, true}, // redirect out of range
+	}
+	for _, tc := range cases {
+		got, ok := (xkbKeySyms{groupInfo: tc.groupInfo}).effectiveGroup(tc.group)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("effectiveGroup(info 0x%x, %d) = %d, %v; want %d, %v", tc.groupInfo, tc.group, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestKeycodes_xkbActiveGroup(t *testing.T) {
+	// Two layouts: keycode 8 types a in group 1 and Cyrillic a in group 2;
+	// keycode 9 types q in both. The core map only knows about group 1 in its
+	// base column, which is what used to be resolved.
+	x := &Xdo{
+		min:               8,
+		max:               10,
+		keysymsPerKeycode: 2,
+		keyMap: []xproto.Keysym{
+			symLowerA, symCyrillicA,
+			symQ, 0,
+			0, 0,
+		},
+		xkb: &xkbMap{
+			min:   8,
+			max:   10,
+			types: []xkbKeyType{{levels: 1}},
+			keys: []xkbKeySyms{
+				{groupInfo: 2, width: 1, syms: []xproto.Keysym{symLowerA, symCyrillicA}},
+				{groupInfo: 1, width: 1, syms: []xproto.Keysym{symQ}},
+				{},
+			},
+		},
+	}
+
+	kcs, err := x.Keycodes("a")
+	if err != nil || len(kcs) != 1 || kcs[0] != 8 {
+		t.Fatalf("group 1 Keycodes(a) = %v, %v; want [8]", kcs, err)
+	}
+	if _, err := x.Keycodes("Cyrillic_a"); !errors.Is(err, errNeedsModifiers) {
+		t.Fatalf("group 1 Keycodes(Cyrillic_a) = %v, want errNeedsModifiers", err)
+	}
+
+	x.group = 1
+	kcs, err = x.Keycodes("Cyrillic_a")
+	if err != nil || len(kcs) != 1 || kcs[0] != 8 {
+		t.Fatalf("group 2 Keycodes(Cyrillic_a) = %v, %v; want [8]", kcs, err)
+	}
+	if _, err := x.Keycodes("a"); !errors.Is(err, errNeedsModifiers) {
+		t.Fatalf("group 2 Keycodes(a) = %v, want errNeedsModifiers", err)
+	}
+	kcs, err = x.Keycodes("q")
+	if err != nil || len(kcs) != 1 || kcs[0] != 9 {
+		t.Fatalf("group 2 Keycodes(q) = %v, %v; want [9]", kcs, err)
+	}
+
+	// Without XKB, resolution falls back to the core base column.
+	x.xkb = nil
+	kcs, err = x.Keycodes("a")
+	if err != nil || len(kcs) != 1 || kcs[0] != 8 {
+		t.Fatalf("core Keycodes(a) = %v, %v; want [8]", kcs, err)
+	}
+}
+
+func TestScratch_withXkbMap(t *testing.T) {
+	// A keysym missing from both maps is still scratch-bound through the core
+	// map, and keeps resolving before the XKB map is reloaded.
+	x := &Xdo{
+		min:               8,
+		max:               9,
+		keysymsPerKeycode: 1,
+		keyMap:            []xproto.Keysym{symQ, 0},
+		xkb: &xkbMap{
+			min:   8,
+			max:   9,
+			types: []xkbKeyType{{levels: 1}},
+			keys:  []xkbKeySyms{{groupInfo: 1, width: 1, syms: []xproto.Keysym{symQ}}, {}},
+		},
+	}
+	for range 2 {
+		kcs, err := x.Keycodes("at")
+		if err != nil || len(kcs) != 1 || kcs[0] != 9 {
+			t.Fatalf("Keycodes(at) = %v, %v; want scratch [9]", kcs, err)
+		}
+	}
+}
+
+func TestParseXkbState(t *testing.T) {
+	rep := make([]byte, 32)
+	rep[8] = modShift | modMod5
+	rep[12] = 1
+	rep[13] = 1
+	xgb.Put16(rep[14:], 0)
+	st, err := parseXkbState(rep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st != (xkbState{mods: modShift | modMod5, group: 1, lockedGroup: 1}) {
+		t.Fatalf("state = %+v", st)
+	}
+	if _, err := parseXkbState(rep[:10]); err == nil {
+		t.Fatal("expected error for short reply")
+	}
+}
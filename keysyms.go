@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"text/tabwriter"
+
+	"deedles.dev/ptt-fix/internal/keysym"
+	"deedles.dev/ptt-fix/internal/xdo"
+)
+
+// runKeysyms implements the keysyms subcommand, which searches the known
+// keysym names and shows whether each one can be typed on the live keymap.
+func runKeysyms(ctx context.Context, args []string) error {
+	logger := Logger(ctx)
+
+	fset := flag.NewFlagSet("keysyms", flag.ExitOnError)
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "Usage: %s keysyms [flags] [query]\n\n", os.Args[0])
+		fmt.Fprintln(fset.Output(), "Lists keysym names containing query, ignoring case, or all of them.")
+		fset.PrintDefaults()
+	}
+	prefix := fset.Bool("prefix", false, "only print names starting with query, case-sensitively, for shell completion")
+	fset.Parse(args)
+	if fset.NArg() > 1 {
+		fset.Usage()
+		return fmt.Errorf("too many arguments")
+	}
+	query := fset.Arg(0)
+
+	if *prefix {
+		for _, name := range keysym.WithPrefix(query) {
+			fmt.Println(name)
+		}
+		return nil
+	}
+
+	names := keysym.Search(query)
+	if sym, ok := keysym.Lookup(query); ok {
+		// Also show what an exact query resolves to, such as a single
+		// character or U+XXXX, even if no name contains it.
+		if name, ok := keysym.Name(sym); ok && !slices.Contains(names, name) {
+			names = slices.Insert(names, 0, name)
+		}
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("no keysyms match %q", query)
+	}
+
+	do, err := xdo.Open()
+	if err != nil {
+		logger.Warn("cannot open display, not showing reachability", errKey, err)
+		do = nil
+	}
+	defer do.Close()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintln(w, "NAME\tVALUE\tKEYMAP\t")
+	for _, name := range names {
+		sym, _ := keysym.Lookup(name)
+
+		reach := "?"
+		if do != nil {
+			r, err := do.Reachable(sym)
+			if err != nil {
+				return fmt.Errorf("check %v: %w", name, err)
+			}
+			reach = r.String()
+		}
+
+		var note string
+		if canonical, ok := keysym.Name(sym); ok && (canonical != name) {
+			note = "alias of " + canonical
+		}
+		fmt.Fprintf(w, "%v\t0x%x\t%v\t%v\n", name, sym, reach, note)
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"deedles.dev/ptt-fix/internal/config"
+	"deedles.dev/ptt-fix/internal/evdev"
+)
+
+// sysfsLEDDir is where the kernel lists LEDs.
+const sysfsLEDDir = "/sys/class/leds"
+
+// feedbackSender wraps a sender that only tells the user what is going on,
+// such as an LED. Its errors are logged instead of returned, so that they
+// never cause the outputs before it to be released again.
+type feedbackSender struct {
+	logger *slog.Logger
+	s      sender
+}
+
+func (s feedbackSender) Up() error {
+	if err := s.s.Up(); err != nil {
+		s.logger.Warn("feedback failed", "event", "up", errKey, err)
+	}
+	return nil
+}
+
+func (s feedbackSender) Down() error {
+	if err := s.s.Down(); err != nil {
+		s.logger.Warn("feedback failed", "event", "down", errKey, err)
+	}
+	return nil
+}
+
+// NoteEvent implements eventNoter by passing ev on if the wrapped sender
+// wants it.
+func (s feedbackSender) NoteEvent(ev event) {
+	if n, ok := s.s.(eventNoter); ok {
+		n.NoteEvent(ev)
+	}
+}
+
+func (s feedbackSender) Close() error {
+	return closeSender(s.s)
+}
+
+// newLED returns a sender that lights led while pressed. Evdev LEDs without
+// a pattern are looked for on devices.
+func newLED(logger *slog.Logger, led config.LED, devices []string) (sender, error) {
+	switch led.Type {
+	case "evdev":
+		if led.Pattern != "" {
+			m, err := filepath.Glob(led.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("find led devices: %w", err)
+			}
+			devices = m
+		}
+		return newEvdevLED(logger, led.Name, devices)
+	case "sysfs":
+		return newSysfsLED(filepath.Join(sysfsLEDDir, led.Name))
+	default:
+		return nil, fmt.Errorf("invalid led type: %q", led.Type)
+	}
+}
+
+// evdevLED lights an LED on input devices, such as Scroll Lock on a
+// keyboard, by writing EV_LED events to them. Releasing restores the
+// state the LED had when it was opened.
+type evdevLED struct {
+	code uint16
+	devs []*evdev.Device
+	was  []bool
+}
+
+// newEvdevLED opens every device in paths that has the named LED.
+func newEvdevLED(logger *slog.Logger, name string, paths []string) (*evdevLED, error) {
+	code, ok := evdev.LEDByName(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown led %q", name)
+	}
+
+	// Several paths, such as those in by-id and by-path, often lead to
+	// the same device.
+	var seen []string
+	s := evdevLED{code: code}
+	for _, path := range paths {
+		real, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			real = path
+		}
+		if slices.Contains(seen, real) {
+			continue
+		}
+		seen = append(seen, real)
+
+		d, err := evdev.OpenReadWrite(path)
+		if err != nil {
+			logger.Debug("cannot open device for led", "device", path, errKey, err)
+			continue
+		}
+		if !d.HasEventCode(evdev.EvLed, code) {
+			d.Close()
+			continue
+		}
+		was, err := d.LED(code)
+		if err != nil {
+			logger.Warn("cannot get led state", "device", path, errKey, err)
+			d.Close()
+			continue
+		}
+		logger.Info("using device for led", "device", path, "led", name)
+		s.devs = append(s.devs, d)
+		s.was = append(s.was, was)
+	}
+	if len(s.devs) == 0 {
+		return nil, fmt.Errorf("no device with led %q could be opened for writing", name)
+	}
+	return &s, nil
+}
+
+func (s *evdevLED) Up() error {
+	var errs []error
+	for i, d := range s.devs {
+		errs = append(errs, d.SetLED(s.code, s.was[i]))
+	}
+	return errors.Join(errs...)
+}
+
+func (s *evdevLED) Down() error {
+	var errs []error
+	for _, d := range s.devs {
+		errs = append(errs, d.SetLED(s.code, true))
+	}
+	return errors.Join(errs...)
+}
+
+// Close restores the LED and closes the devices.
+func (s *evdevLED) Close() error {
+	errs := []error{s.Up()}
+	for _, d := range s.devs {
+		errs = append(errs, d.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// sysfsLED lights an LED through its sysfs directory, such as the mic mute
+// LED of a laptop. Lighting it takes it away from its trigger, such as
+// audio-micmute, so releasing puts the trigger back, or the brightness if
+// there was none.
+type sysfsLED struct {
+	dir        string
+	max        string
+	brightness string
+	trigger    string
+}
+
+func newSysfsLED(dir string) (*sysfsLED, error) {
+	read := func(name string) (string, error) {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		return strings.TrimSpace(string(data)), err
+	}
+
+	var s sysfsLED
+	var err error
+	s.dir = dir
+	if s.max, err = read("max_brightness"); err != nil {
+		return nil, fmt.Errorf("read led: %w", err)
+	}
+	if s.brightness, err = read("brightness"); err != nil {
+		return nil, fmt.Errorf("read led: %w", err)
+	}
+	triggers, err := read("trigger")
+	if err != nil {
+		return nil, fmt.Errorf("read led: %w", err)
+	}
+	s.trigger = currentTrigger(triggers)
+
+	// Fail now, rather than on the first press, if it can't be written,
+	// which usually needs a udev rule.
+	if err := s.write("brightness", s.brightness); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// currentTrigger returns the trigger in brackets in the contents of an
+// LED's trigger file.
+func currentTrigger(triggers string) string {
+	for _, t := range strings.Fields(triggers) {
+		if strings.HasPrefix(t, "[") && strings.HasSuffix(t, "]") {
+			return strings.Trim(t, "[]")
+		}
+	}
+	return "none"
+}
+
+func (s *sysfsLED) write(name, val string) error {
+	err := os.WriteFile(filepath.Join(s.dir, name), []byte(val), 0)
+	if err != nil {
+		return fmt.Errorf("write led: %w", err)
+	}
+	return nil
+}
+
+func (s *sysfsLED) Up() error {
+	if s.trigger != "none" {
+		return s.write("trigger", s.trigger)
+	}
+	return s.write("brightness", s.brightness)
+}
+
+func (s *sysfsLED) Down() error {
+	return s.write("brightness", s.max)
+}
+
+// Close restores the LED.
+func (s *sysfsLED) Close() error {
+	return s.Up()
+}
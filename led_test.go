@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeSysfsLED creates an LED directory like those in /sys/class/leds.
+func fakeSysfsLED(t *testing.T, brightness, trigger string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, val := range map[string]string{
+		"max_brightness": "255\n",
+		"brightness":     brightness + "\n",
+		"trigger":        trigger + "\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(val), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func readLEDFile(t *testing.T, dir, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func TestSysfsLED(t *testing.T) {
+	tests := []struct {
+		name       string
+		brightness string
+		trigger    string
+		// restored is the file that is written on release, and what
+		// is written to it.
+		restored, val string
+	}{
+		{"trigger", "0", "none rfkill-any [audio-micmute] audio-mute", "trigger", "audio-micmute"},
+		{"noTrigger", "1", "[none] rfkill-any audio-micmute", "brightness", "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := fakeSysfsLED(t, tt.brightness, tt.trigger)
+			s, err := newSysfsLED(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := s.Down(); err != nil {
+				t.Fatal(err)
+			}
+			if got := readLEDFile(t, dir, "brightness"); got != "255" {
+				t.Errorf("brightness while pressed = %q, want 255", got)
+			}
+
+			os.WriteFile(filepath.Join(dir, tt.restored), nil, 0644)
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if got := readLEDFile(t, dir, tt.restored); got != tt.val {
+				t.Errorf("%v after Close = %q, want %q", tt.restored, got, tt.val)
+			}
+		})
+	}
+}
+
+func TestNewSysfsLED_missing(t *testing.T) {
+	if _, err := newSysfsLED(filepath.Join(t.TempDir(), "nope")); err == nil {
+		t.Fatal("newSysfsLED on a missing LED succeeded")
+	}
+}
+
+func TestFeedbackSender(t *testing.T) {
+	var log []string
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	s := multiSender{
+		orderSender{name: "sym", log: &log},
+		feedbackSender{logger: logger, s: orderSender{name: "led", log: &log, downErr: errors.New("led failed")}},
+	}
+	if err := s.Down(); err != nil {
+		t.Fatalf("Down = %v, want feedback errors to be swallowed", err)
+	}
+	if want := []string{"sym down"}; !slices.Equal(log, want) {
+		t.Errorf("log = %q, want %q", log, want)
+	}
+	if !strings.Contains(buf.String(), "led failed") {
+		t.Errorf("feedback error not logged: %q", buf.String())
+	}
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io/fs"
+	"slices"
 	"time"
 
 	"deedles.dev/ptt-fix/internal/evdev"
@@ -11,10 +13,10 @@ import (
 )
 
 type Listener struct {
-	Device  string
-	Keycode uint16
-	C       chan<- event
-	Retry   time.Duration
+	Device   string
+	Keycodes []uint16
+	C        chan<- event
+	Retry    time.Duration
 }
 
 func (lis Listener) Run(ctx context.Context) error {
@@ -67,11 +69,27 @@ func (lis *Listener) listen(ctx context.Context) (retry bool, err error) {
 		"product", d.ID.Product,
 	)
 
-	if !d.HasEventCode(evdev.EvKey, lis.Keycode) {
+	codes := slices.DeleteFunc(slices.Clone(lis.Keycodes), func(code uint16) bool {
+		return !d.HasEventCode(evdev.EvKey, code)
+	})
+	if len(codes) == 0 {
 		logger.Info("ignoring device", "reason", "incapable of sending requested key code")
 		return false, nil
 	}
 
+	notify := Notifier(ctx)
+	notify.Notify("PTT device connected", fmt.Sprintf("%v (%v)", d.Name, lis.Device))
+	defer func() {
+		if context.Cause(ctx) == nil {
+			notify.Notify("PTT device disconnected", fmt.Sprintf("%v (%v)", d.Name, lis.Device))
+		}
+	}()
+
+	// Release anything still held if the device goes away so that a
+	// chord spanning several devices is not left stuck down.
+	held := make(map[uint16]struct{})
+	defer lis.release(ctx, held)
+
 	for {
 		ev, err := d.NextEvent()
 		if err != nil {
@@ -91,7 +109,7 @@ func (lis *Listener) listen(ctx context.Context) (retry bool, err error) {
 			return true, err
 		}
 
-		if !ev.Is(evdev.EvKey, lis.Keycode) {
+		if (ev.Type != evdev.EvKey) || !slices.Contains(codes, ev.Code) {
 			continue
 		}
 
@@ -101,18 +119,39 @@ func (lis *Listener) listen(ctx context.Context) (retry bool, err error) {
 			select {
 			case <-ctx.Done():
 				return false, context.Cause(ctx)
-			case lis.C <- event{Type: eventDown, Device: lis.Device}:
+			case lis.C <- event{Type: eventDown, Device: lis.Device, Code: ev.Code, Time: eventTime(ev)}:
+				held[ev.Code] = struct{}{}
 			}
 		default:
 			select {
 			case <-ctx.Done():
 				return false, context.Cause(ctx)
-			case lis.C <- event{Type: eventUp, Device: lis.Device}:
+			case lis.C <- event{Type: eventUp, Device: lis.Device, Code: ev.Code, Time: eventTime(ev)}:
+				delete(held, ev.Code)
 			}
 		}
 	}
 }
 
+func (lis *Listener) release(ctx context.Context, held map[uint16]struct{}) {
+	for code := range held {
+		select {
+		case <-ctx.Done():
+			return
+		case lis.C <- event{Type: eventUp, Device: lis.Device, Code: code, Time: time.Now()}:
+		}
+	}
+}
+
+// eventTime returns the kernel timestamp of ev, falling back to the
+// current time if there isn't one.
+func eventTime(ev evdev.InputEvent) time.Time {
+	if ev.Time.IsZero() {
+		return time.Now()
+	}
+	return ev.Time
+}
+
 func isTemporary(err error) bool {
 	errno, ok := errors.AsType[unix.Errno](err)
 	return ok && errno.Temporary()
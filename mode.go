@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"deedles.dev/ptt-fix/internal/config"
+)
+
+// defaultTapThreshold is used for the hybrid tap length and the
+// double-tap window when the config does not give one.
+const defaultTapThreshold = 300 * time.Millisecond
+
+// mode turns trigger events into the events that are actually sent.
+// Apply reports false if nothing should be sent for ev. Modes only
+// look at the event timestamps and never read the clock themselves, so
+// they can be driven with a fake clock.
+//
+// Reset drops any latched state, such as after a forced release.
+type mode interface {
+	Apply(ev event) (event, bool)
+	Reset()
+}
+
+func newMode(m config.Mode) (mode, error) {
+	threshold := m.Threshold
+	if threshold <= 0 {
+		threshold = defaultTapThreshold
+	}
+
+	switch m.Type {
+	case "", "hold":
+		return holdMode{}, nil
+
+	case "hybrid":
+		return &hybridMode{threshold: threshold}, nil
+
+	case "doubletap":
+		return &doubleTapMode{window: threshold}, nil
+
+	default:
+		return nil, fmt.Errorf("invalid mode: %q", m.Type)
+	}
+}
+
+// holdMode transmits exactly while the trigger is held.
+type holdMode struct{}
+
+func (holdMode) Apply(ev event) (event, bool) {
+	return ev, true
+}
+
+func (holdMode) Reset() {}
+
+// hybridMode is push-to-talk for long holds and a toggle for short
+// taps. Transmission starts on every press. If the press is released
+// before threshold it latches instead of stopping, and the next
+// release, whatever its length, stops it again.
+type hybridMode struct {
+	threshold time.Duration
+
+	pressed time.Time
+	latched bool
+}
+
+func (m *hybridMode) Reset() {
+	m.latched = false
+}
+
+func (m *hybridMode) Apply(ev event) (event, bool) {
+	switch ev.Type {
+	case eventDown:
+		m.pressed = ev.Time
+		return ev, !m.latched
+
+	case eventUp:
+		if m.latched {
+			m.latched = false
+			return ev, true
+		}
+		if ev.Time.Sub(m.pressed) < m.threshold {
+			m.latched = true
+			return event{}, false
+		}
+		return ev, true
+
+	default:
+		return ev, true
+	}
+}
+
+// doubleTapMode is push-to-talk for single presses, but a second press
+// within window of the previous one latches transmission on. The next
+// press after that turns it off again and its release is swallowed.
+type doubleTapMode struct {
+	window time.Duration
+
+	pressed   time.Time
+	latched   bool
+	unlatched bool
+}
+
+func (m *doubleTapMode) Reset() {
+	m.pressed = time.Time{}
+	m.latched = false
+	m.unlatched = false
+}
+
+func (m *doubleTapMode) Apply(ev event) (event, bool) {
+	switch ev.Type {
+	case eventDown:
+		if m.latched {
+			m.latched = false
+			m.unlatched = true
+			m.pressed = time.Time{}
+			return event{Type: eventUp, Device: ev.Device, Code: ev.Code, Time: ev.Time}, true
+		}
+
+		m.latched = !m.pressed.IsZero() && (ev.Time.Sub(m.pressed) < m.window)
+		m.pressed = ev.Time
+		return ev, true
+
+	case eventUp:
+		if m.unlatched {
+			m.unlatched = false
+			return event{}, false
+		}
+		return ev, !m.latched
+
+	default:
+		return ev, true
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"deedles.dev/ptt-fix/internal/mqtt"
+)
+
+const (
+	// defaultMQTTBroker is used if the config does not set mqtt-broker.
+	defaultMQTTBroker = "localhost"
+
+	// mqttKeepAlive is the keep alive interval asked of the broker. The
+	// connection is pinged at half of it.
+	mqttKeepAlive = time.Minute
+
+	// mqttRetryMin and mqttRetryMax bound the time between attempts to
+	// reach a broker that is down. Presses and releases try again
+	// right away.
+	mqttRetryMin = time.Second
+	mqttRetryMax = time.Minute
+
+	// mqttCloseTimeout limits how long Close waits for "off" to be
+	// published.
+	mqttCloseTimeout = 10 * time.Second
+)
+
+// mqttConn is the part of *mqtt.Client that mqttSender uses.
+type mqttConn interface {
+	Publish(m mqtt.Message) error
+	Ping() error
+	Close() error
+}
+
+// mqttSender publishes a retained "on" or "off" to a topic whenever
+// transmission starts or stops, such as for an "on air" light. Publishing
+// happens on a goroutine of its own, so a slow or unreachable broker never
+// holds up the other outputs. Only the latest state is published, so
+// presses and releases that happen while the broker is unreachable are
+// collapsed into one.
+type mqttSender struct {
+	logger *slog.Logger
+	topic  string
+	dial   func() (mqttConn, error)
+
+	m       sync.Mutex
+	on      bool
+	closing bool
+
+	wake chan struct{}
+	done chan struct{}
+}
+
+// newMQTTSender returns an mqttSender that publishes to topic on broker and
+// starts its worker. The broker is told to publish "off" if the connection
+// is lost. The sender must be closed to stop the worker.
+func newMQTTSender(logger *slog.Logger, broker, topic string) (*mqttSender, error) {
+	if broker == "" {
+		broker = defaultMQTTBroker
+	}
+	addr, username, password, err := mqtt.ParseBroker(broker)
+	if err != nil {
+		return nil, fmt.Errorf("parse mqtt broker: %w", err)
+	}
+	opts := mqtt.Options{
+		ClientID:  "ptt-fix-" + rand.Text()[:8],
+		Username:  username,
+		Password:  password,
+		KeepAlive: mqttKeepAlive,
+		Will:      &mqtt.Message{Topic: topic, Payload: []byte("off"), Retain: true},
+	}
+	dial := func() (mqttConn, error) {
+		c, err := mqtt.Dial(addr, opts)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	return startMQTTSender(logger.With("broker", addr, "topic", topic), topic, dial), nil
+}
+
+func startMQTTSender(logger *slog.Logger, topic string, dial func() (mqttConn, error)) *mqttSender {
+	s := mqttSender{
+		logger: logger,
+		topic:  topic,
+		dial:   dial,
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	go s.work()
+	return &s
+}
+
+func (s *mqttSender) Up() error {
+	s.set(false)
+	return nil
+}
+
+func (s *mqttSender) Down() error {
+	s.set(true)
+	return nil
+}
+
+func (s *mqttSender) set(on bool) {
+	s.m.Lock()
+	s.on = on
+	s.m.Unlock()
+	s.notify()
+}
+
+func (s *mqttSender) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Close publishes "off", if the broker can be reached, and stops the
+// worker.
+func (s *mqttSender) Close() error {
+	s.m.Lock()
+	s.on = false
+	s.closing = true
+	s.m.Unlock()
+	s.notify()
+
+	select {
+	case <-s.done:
+		return nil
+	case <-time.After(mqttCloseTimeout):
+		return errors.New("timed out publishing final mqtt state")
+	}
+}
+
+func (s *mqttSender) work() {
+	defer close(s.done)
+
+	ping := time.NewTicker(mqttKeepAlive / 2)
+	defer ping.Stop()
+
+	var c mqttConn
+	defer func() {
+		if c != nil {
+			c.Close()
+		}
+	}()
+
+	// published is the state that the broker has, if known.
+	var published *bool
+	retry := mqttRetryMin
+	var failing, rejected bool
+	for {
+		s.m.Lock()
+		on, closing := s.on, s.closing
+		s.m.Unlock()
+
+		if c == nil {
+			var err error
+			c, err = s.dial()
+			if err != nil {
+				if closing {
+					s.logger.Warn("cannot reach mqtt broker to publish final state", errKey, err)
+					return
+				}
+				if !failing {
+					s.logger.Warn("cannot reach mqtt broker, retrying in the background", errKey, err)
+				}
+				failing = true
+				s.wait(ping, retry)
+				retry = min(2*retry, mqttRetryMax)
+				continue
+			}
+			if failing {
+				s.logger.Info("connected to mqtt broker")
+			}
+			failing = false
+			published = nil
+
+			// The state may have changed while connecting.
+			continue
+		}
+
+		if (published == nil) || (*published != on) {
+			err := c.Publish(mqtt.Message{Topic: s.topic, Payload: mqttPayload(on), Retain: true})
+			if err != nil {
+				s.logger.Warn("lost connection to mqtt broker, reconnecting", errKey, err)
+				c.Close()
+				c = nil
+
+				// Reconnect right away the first time, but don't
+				// spin if the broker keeps dropping the connection.
+				if rejected {
+					s.wait(ping, retry)
+					retry = min(2*retry, mqttRetryMax)
+				}
+				rejected = true
+				continue
+			}
+			published = &on
+			rejected = false
+			retry = mqttRetryMin
+			continue
+		}
+		if closing {
+			return
+		}
+
+		select {
+		case <-s.wake:
+		case <-ping.C:
+			if err := c.Ping(); err != nil {
+				s.logger.Warn("lost connection to mqtt broker, reconnecting", errKey, err)
+				c.Close()
+				c = nil
+			}
+		}
+	}
+}
+
+// wait waits for d to pass or for a press or release, whichever is first.
+// The ping ticker is drained so that it doesn't fire right after
+// connecting.
+func (s *mqttSender) wait(ping *time.Ticker, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.wake:
+			return
+		case <-t.C:
+			return
+		case <-ping.C:
+		}
+	}
+}
+
+func mqttPayload(on bool) []byte {
+	if on {
+		return []byte("on")
+	}
+	return []byte("off")
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"deedles.dev/ptt-fix/internal/mqtt"
+)
+
+// fakeMQTT records what mqttSender publishes. Each connection fails after
+// failAfter publishes if it is positive, and dialing blocks while block is
+// held.
+type fakeMQTT struct {
+	block sync.Mutex
+
+	m         sync.Mutex
+	failAfter int
+	dials     int
+	published []string
+}
+
+func (f *fakeMQTT) dial() (mqttConn, error) {
+	f.block.Lock()
+	defer f.block.Unlock()
+
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.dials++
+	return &fakeMQTTConn{f: f, left: f.failAfter}, nil
+}
+
+func (f *fakeMQTT) get() []string {
+	f.m.Lock()
+	defer f.m.Unlock()
+	return slices.Clone(f.published)
+}
+
+type fakeMQTTConn struct {
+	f    *fakeMQTT
+	left int
+}
+
+func (c *fakeMQTTConn) Publish(m mqtt.Message) error {
+	c.f.m.Lock()
+	defer c.f.m.Unlock()
+	if c.f.failAfter > 0 {
+		if c.left == 0 {
+			return errors.New("connection reset")
+		}
+		c.left--
+	}
+	c.f.published = append(c.f.published, m.Topic+"="+string(m.Payload))
+	return nil
+}
+
+func (c *fakeMQTTConn) Ping() error  { return nil }
+func (c *fakeMQTTConn) Close() error { return nil }
+
+// waitPublished waits until f has published want.
+func waitPublished(t *testing.T, f *fakeMQTT, want []string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !slices.Equal(f.get(), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("published = %q, want %q", f.get(), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMQTTSender(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	var f fakeMQTT
+	s := startMQTTSender(logger, "on-air", f.dial)
+
+	waitPublished(t, &f, []string{"on-air=off"})
+	s.Down()
+	waitPublished(t, &f, []string{"on-air=off", "on-air=on"})
+	s.Up()
+	waitPublished(t, &f, []string{"on-air=off", "on-air=on", "on-air=off"})
+	s.Down()
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Whether the last press made it out or not, the light must end up
+	// off.
+	got := f.get()
+	if got[len(got)-1] != "on-air=off" {
+		t.Errorf("published = %q, want off last", got)
+	}
+}
+
+func TestMQTTSender_async(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	var f fakeMQTT
+	f.block.Lock()
+	s := startMQTTSender(logger, "on-air", f.dial)
+
+	// The broker is stuck, which must not hold up presses, and only
+	// the latest state is published once it is back.
+	done := make(chan struct{})
+	go func() {
+		for range 100 {
+			s.Down()
+			s.Up()
+		}
+		s.Down()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("presses blocked on the broker")
+	}
+
+	f.block.Unlock()
+	waitPublished(t, &f, []string{"on-air=on"})
+	s.Close()
+}
+
+func TestMQTTSender_reconnect(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	f := fakeMQTT{failAfter: 1}
+	s := startMQTTSender(logger, "on-air", f.dial)
+	defer s.Close()
+
+	waitPublished(t, &f, []string{"on-air=off"})
+	s.Down()
+	waitPublished(t, &f, []string{"on-air=off", "on-air=on"})
+
+	f.m.Lock()
+	defer f.m.Unlock()
+	if f.dials < 2 {
+		t.Errorf("dialed %v times, want a reconnect", f.dials)
+	}
+}
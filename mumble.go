@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"deedles.dev/ptt-fix/internal/mumble"
+)
+
+// mumbleSender tells a running Mumble to start and stop talking through its
+// RPC socket, which needs neither X nor a shortcut configured in Mumble.
+//
+// Mumble not running is only logged, so that it doesn't stop the other
+// outputs, but Mumble rejecting a command is an error.
+type mumbleSender struct {
+	logger *slog.Logger
+	path   string
+
+	// failing is true while Mumble isn't running, so that only the first
+	// failure is logged.
+	failing bool
+}
+
+func newMumbleSender(logger *slog.Logger) (*mumbleSender, error) {
+	path, err := mumble.SocketPath()
+	if err != nil {
+		return nil, fmt.Errorf("find mumble socket: %w", err)
+	}
+	return &mumbleSender{logger: logger, path: path}, nil
+}
+
+func (s *mumbleSender) Up() error {
+	return s.send(mumble.StopTalking)
+}
+
+func (s *mumbleSender) Down() error {
+	return s.send(mumble.StartTalking)
+}
+
+func (s *mumbleSender) send(command string) error {
+	err := mumble.Send(s.path, command)
+	if errors.Is(err, mumble.ErrNotRunning) {
+		if !s.failing {
+			s.logger.Warn("cannot reach mumble, start Mumble 1.3 or later as the same user", errKey, err)
+		}
+		s.failing = true
+		return nil
+	}
+	if err == nil {
+		s.failing = false
+	}
+	return err
+}
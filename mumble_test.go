@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"deedles.dev/ptt-fix/internal/mumble"
+)
+
+func TestMumbleSender_notRunning(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+
+	var buf bytes.Buffer
+	s, err := newMumbleSender(slog.New(slog.NewTextHandler(&buf, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Down(); err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+	if err := s.Up(); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	if n := strings.Count(buf.String(), "cannot reach mumble"); n != 1 {
+		t.Fatalf("expected the failure to be logged once, got %v times in %q", n, buf.String())
+	}
+}
+
+func TestMumbleSender_rejected(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", dir)
+
+	// A Mumble that rejects everything.
+	lis, err := net.Listen("unix", filepath.Join(dir, "MumbleSocket"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			conn.Read(make([]byte, 1024))
+			conn.Write([]byte("<reply><succeeded>false</succeeded></reply>\n"))
+			conn.Close()
+		}
+	}()
+
+	s, err := newMumbleSender(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Down(); !errors.Is(err, mumble.ErrFailed) {
+		t.Fatalf("Down = %v, want ErrFailed", err)
+	}
+}
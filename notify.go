@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"deedles.dev/ptt-fix/internal/dbus"
+)
+
+const (
+	notificationsName      = "org.freedesktop.Notifications"
+	notificationsPath      = "/org/freedesktop/Notifications"
+	notificationsInterface = "org.freedesktop.Notifications"
+
+	// notifyTimeout limits how long sending a single notification may
+	// take.
+	notifyTimeout = 5 * time.Second
+
+	// notifyQueue is how many notifications may wait to be sent before
+	// more are dropped.
+	notifyQueue = 16
+)
+
+type notification struct {
+	summary string
+	body    string
+	urgent  bool
+}
+
+// notifier shows desktop notifications through org.freedesktop.Notifications.
+// They are sent on a goroutine of its own, so that a slow or missing
+// notification daemon never holds up anything else. A nil *notifier drops
+// everything, so that callers needn't check whether notifications are
+// enabled.
+type notifier struct {
+	logger *slog.Logger
+	dial   func() (*dbus.Conn, error)
+
+	q    chan notification
+	done chan struct{}
+}
+
+type notifierCtx struct{}
+
+// WithNotifier returns a context that carries n.
+func WithNotifier(ctx context.Context, n *notifier) context.Context {
+	return context.WithValue(ctx, notifierCtx{}, n)
+}
+
+// Notifier returns the notifier in ctx, or nil if there isn't one.
+func Notifier(ctx context.Context) *notifier {
+	n, _ := ctx.Value(notifierCtx{}).(*notifier)
+	return n
+}
+
+// newNotifier starts a notifier that connects to the session bus when it
+// first needs to. It must be closed to stop it.
+func newNotifier(logger *slog.Logger) *notifier {
+	return startNotifier(logger, dbus.SessionBus)
+}
+
+func startNotifier(logger *slog.Logger, dial func() (*dbus.Conn, error)) *notifier {
+	n := notifier{
+		logger: logger,
+		dial:   dial,
+		q:      make(chan notification, notifyQueue),
+		done:   make(chan struct{}),
+	}
+	go n.work()
+	return &n
+}
+
+// Notify queues a notification. It never blocks.
+func (n *notifier) Notify(summary, body string) {
+	n.send(notification{summary: summary, body: body})
+}
+
+// Alert queues a notification about a failure, which stays until it is
+// dismissed. It never blocks.
+func (n *notifier) Alert(summary, body string) {
+	n.send(notification{summary: summary, body: body, urgent: true})
+}
+
+func (n *notifier) send(msg notification) {
+	if n == nil {
+		return
+	}
+	select {
+	case n.q <- msg:
+	default:
+		n.logger.Debug("notification queue full, dropping", "summary", msg.summary)
+	}
+}
+
+// Close sends the notifications that are still queued, giving up after a
+// while, and stops the notifier.
+func (n *notifier) Close() error {
+	if n == nil {
+		return nil
+	}
+	close(n.q)
+
+	select {
+	case <-n.done:
+		return nil
+	case <-time.After(notifyTimeout):
+		return errors.New("timed out sending notifications")
+	}
+}
+
+func (n *notifier) work() {
+	defer close(n.done)
+
+	var conn *dbus.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+
+	var failing bool
+	for msg := range n.q {
+		// Notifications aren't worth retrying, so just try to
+		// connect again for the next one if this one fails.
+		if conn == nil {
+			c, err := n.dial()
+			if err != nil {
+				if !failing {
+					n.logger.Warn("cannot connect to session bus for notifications", errKey, err)
+				}
+				failing = true
+				continue
+			}
+			conn = c
+		}
+
+		err := n.show(conn, msg)
+		if err != nil {
+			if !failing {
+				n.logger.Warn("cannot show notification", errKey, err)
+			}
+			failing = true
+
+			var derr *dbus.Error
+			if !errors.As(err, &derr) {
+				conn.Close()
+				conn = nil
+			}
+			continue
+		}
+		failing = false
+	}
+}
+
+func (n *notifier) show(conn *dbus.Conn, msg notification) error {
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+
+	// Urgency is 0 for low, 1 for normal and 2 for critical.
+	urgency := byte(1)
+	if msg.urgent {
+		urgency = 2
+	}
+	hints := map[string]dbus.Variant{
+		"urgency":       dbus.MakeVariant(urgency),
+		"desktop-entry": dbus.MakeVariant("ptt-fix"),
+	}
+
+	_, err := conn.Call(
+		ctx,
+		notificationsName,
+		notificationsPath,
+		notificationsInterface,
+		"Notify",
+		"susssasa{sv}i",
+		"ptt-fix",
+		uint32(0),
+		"audio-input-microphone",
+		msg.summary,
+		msg.body,
+		[]string{},
+		hints,
+		int32(-1),
+	)
+	return err
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	"deedles.dev/ptt-fix/internal/dbus"
+)
+
+func TestNotifier_nil(t *testing.T) {
+	n := Notifier(context.Background())
+	if n != nil {
+		t.Fatalf("Notifier without one in the context = %v, want nil", n)
+	}
+	n.Notify("summary", "body")
+	n.Alert("summary", "body")
+	if err := n.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNotifier_noBus(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	var dials int
+	n := startNotifier(logger, func() (*dbus.Conn, error) {
+		dials++
+		return nil, errors.New("no bus")
+	})
+	ctx := WithNotifier(context.Background(), n)
+	if Notifier(ctx) != n {
+		t.Fatal("Notifier did not return the notifier in the context")
+	}
+
+	// Far more than fit in the queue, none of which may block.
+	for range 10 * notifyQueue {
+		n.Notify("summary", "body")
+	}
+	if err := n.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if dials == 0 {
+		t.Error("never tried to connect")
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"deedles.dev/ptt-fix/internal/obs"
+)
+
+// defaultOBSAddress is used if the config does not set obs-address.
+const defaultOBSAddress = "localhost:" + obs.DefaultPort
+
+// obsSender unmutes an OBS input while pressed and keeps it muted
+// otherwise, so that a stream only hears the microphone while talking.
+//
+// OBS not running, or going away, is only logged, so that it doesn't stop
+// the other outputs, and connecting is tried again on the next press or
+// release. Only errors from OBS itself, such as a wrong input name or
+// password, are returned.
+type obsSender struct {
+	logger   *slog.Logger
+	addr     string
+	password string
+	input    string
+
+	c *obs.Client
+
+	// failing is true while OBS can't be reached, so that only the first
+	// failure is logged.
+	failing bool
+}
+
+// newOBSSender connects to OBS and mutes input. OBS is often started after
+// ptt-fix, so failing to connect is only logged.
+func newOBSSender(logger *slog.Logger, addr, password, input string) *obsSender {
+	if addr == "" {
+		addr = defaultOBSAddress
+	}
+	s := obsSender{
+		logger:   logger.With("input", input),
+		addr:     addr,
+		password: password,
+		input:    input,
+	}
+	if err := s.setMute(true); err != nil {
+		s.logger.Warn("cannot mute obs input", errKey, err)
+	}
+	return &s
+}
+
+func (s *obsSender) Up() error {
+	return s.setMute(true)
+}
+
+func (s *obsSender) Down() error {
+	return s.setMute(false)
+}
+
+// Close mutes the input, in case it was left unmuted, and disconnects.
+func (s *obsSender) Close() error {
+	if s.c == nil {
+		return nil
+	}
+	err := s.c.SetInputMute(s.input, true)
+	return errors.Join(err, s.c.Close())
+}
+
+// setMute mutes or unmutes the input.
+func (s *obsSender) setMute(mute bool) error {
+	err := s.trySetMute(mute)
+	var oerr *obs.Error
+	switch {
+	case err == nil:
+		if s.failing {
+			s.logger.Info("reconnected to obs")
+		}
+		s.failing = false
+		return nil
+	case errors.Is(err, obs.ErrNotFound):
+		return fmt.Errorf("%w (check the name in the Audio Mixer)", err)
+	case errors.Is(err, obs.ErrAuth):
+		return fmt.Errorf("%w (check obs-password against Tools, WebSocket Server Settings)", err)
+	case errors.As(err, &oerr):
+		return err
+	}
+
+	if !s.failing {
+		s.logger.Warn("cannot reach obs, will try again on the next press or release", errKey, err)
+	}
+	s.failing = true
+	return nil
+}
+
+// trySetMute mutes or unmutes the input. If there is no connection or it
+// has been lost, such as because OBS was restarted, it connects and tries
+// once more.
+func (s *obsSender) trySetMute(mute bool) error {
+	if s.c != nil {
+		err := s.c.SetInputMute(s.input, mute)
+		var oerr *obs.Error
+		if (err == nil) || errors.As(err, &oerr) {
+			return err
+		}
+		s.logger.Warn("lost connection to obs, reconnecting", errKey, err)
+		s.c.Close()
+		s.c = nil
+	}
+
+	c, err := obs.Dial(s.addr, s.password)
+	if err != nil {
+		return err
+	}
+	s.c = c
+	return c.SetInputMute(s.input, mute)
+}
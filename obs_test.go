@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestOBSSender_notRunning(t *testing.T) {
+	// Nothing listens on a port that was just closed, as if OBS weren't
+	// running.
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	var other stubSender
+	s := multiSender{newOBSSender(logger, addr, "", "Mic/Aux"), &other}
+	defer s.Close()
+
+	if err := applyEvent(logger, s, event{Type: eventDown, Device: "pedal"}); err != nil {
+		t.Fatalf("press: %v", err)
+	}
+	if err := applyEvent(logger, s, event{Type: eventUp, Device: "pedal"}); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	if (other.downs != 1) || (other.ups != 1) {
+		t.Fatalf("other sender got %v presses and %v releases, want 1 each", other.downs, other.ups)
+	}
+	if n := strings.Count(buf.String(), "cannot reach obs"); n != 1 {
+		t.Fatalf("expected the failure to be logged once, got %v times in %q", n, buf.String())
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"deedles.dev/ptt-fix/internal/config"
+	"deedles.dev/ptt-fix/internal/dbus"
+	"deedles.dev/ptt-fix/internal/portal"
+)
+
+// portalTimeout limits how long the portal may take to handle an event.
+const portalTimeout = 5 * time.Second
+
+// portalSession is the remote desktop portal session that all portal syms
+// share, so that the user is only asked once.
+type portalSession struct {
+	conn    *dbus.Conn
+	session *portal.Session
+	once    sync.Once
+}
+
+// startPortal starts a remote desktop session for the device types that
+// the portal syms in syms need. A restore token saved by an earlier run is
+// used to skip the permission dialog, and the new one is saved in its place.
+func startPortal(ctx context.Context, logger *slog.Logger, syms []config.Sym) (*portalSession, error) {
+	var devices portal.DeviceType
+	for _, sym := range syms {
+		if sym.Type != "portal" {
+			continue
+		}
+		button, _, err := parsePortalSym(sym.Val)
+		if err != nil {
+			return nil, err
+		}
+		if button {
+			devices |= portal.Pointer
+		} else {
+			devices |= portal.Keyboard
+		}
+	}
+
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return nil, err
+	}
+
+	tokenPath, err := restoreTokenPath()
+	if err != nil {
+		logger.Warn("cannot find state directory, remote desktop permission won't be remembered", errKey, err)
+	}
+	var token string
+	if tokenPath != "" {
+		data, err := os.ReadFile(tokenPath)
+		if (err != nil) && !errors.Is(err, os.ErrNotExist) {
+			logger.Warn("cannot read remote desktop restore token", errKey, err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token == "" {
+		logger.Info("asking for remote desktop permission, please confirm the dialog")
+	}
+
+	s, err := portal.Start(ctx, conn, devices, token)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("start remote desktop session: %w", err)
+	}
+	if s.Devices()&devices != devices {
+		logger.Warn("remote desktop session does not allow every device type that is needed", "allowed", s.Devices(), "needed", devices)
+	}
+
+	if t := s.RestoreToken(); (t != "") && (tokenPath != "") {
+		err := os.MkdirAll(filepath.Dir(tokenPath), 0700)
+		if err == nil {
+			err = os.WriteFile(tokenPath, []byte(t+"\n"), 0600)
+		}
+		if err != nil {
+			logger.Warn("cannot save remote desktop restore token", errKey, err)
+		}
+	}
+
+	return &portalSession{conn: conn, session: s}, nil
+}
+
+// restoreTokenPath returns where the portal's restore token is kept.
+func restoreTokenPath() (string, error) {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "ptt-fix", "portal-restore-token"), nil
+}
+
+// close ends the session and disconnects. It is safe to call more than once.
+func (p *portalSession) close() error {
+	var err error
+	p.once.Do(func() {
+		err = errors.Join(p.session.Close(), p.conn.Close())
+	})
+	return err
+}
+
+// parsePortalSym parses the value of a portal sym, which is either
+// "key <code>" or "button <code>" with an evdev code.
+func parsePortalSym(val string) (button bool, code int32, err error) {
+	kind, num, _ := strings.Cut(val, " ")
+	switch kind {
+	case "key":
+	case "button":
+		button = true
+	default:
+		return false, 0, fmt.Errorf("invalid portal sym %q, want key or button", val)
+	}
+	v, err := strconv.ParseUint(strings.TrimSpace(num), 0, 16)
+	if err != nil {
+		return false, 0, fmt.Errorf("invalid portal %v code: %w", kind, err)
+	}
+	return button, int32(v), nil
+}
+
+// portalSender presses a key or button through the remote desktop portal,
+// which reaches native Wayland windows as well as XWayland ones.
+type portalSender struct {
+	session *portalSession
+	button  bool
+	code    int32
+}
+
+func newPortalSender(session *portalSession, val string) (*portalSender, error) {
+	button, code, err := parsePortalSym(val)
+	if err != nil {
+		return nil, err
+	}
+	return &portalSender{session: session, button: button, code: code}, nil
+}
+
+func (s *portalSender) Up() error {
+	return s.notify(false)
+}
+
+func (s *portalSender) Down() error {
+	return s.notify(true)
+}
+
+func (s *portalSender) notify(pressed bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), portalTimeout)
+	defer cancel()
+
+	if s.button {
+		return s.session.session.Button(ctx, s.code, pressed)
+	}
+	return s.session.session.Key(ctx, s.code, pressed)
+}
+
+// Close ends the shared session.
+func (s *portalSender) Close() error {
+	return s.session.close()
+}
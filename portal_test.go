@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestParsePortalSym(t *testing.T) {
+	tests := []struct {
+		val    string
+		button bool
+		code   int32
+	}{
+		{"key 56", false, 56},
+		{"button 0x110", true, 0x110},
+		{"key  29", false, 29},
+	}
+	for _, tt := range tests {
+		button, code, err := parsePortalSym(tt.val)
+		if err != nil {
+			t.Errorf("parsePortalSym(%q): %v", tt.val, err)
+			continue
+		}
+		if (button != tt.button) || (code != tt.code) {
+			t.Errorf("parsePortalSym(%q) = %v, %v, want %v, %v", tt.val, button, code, tt.button, tt.code)
+		}
+	}
+
+	for _, val := range []string{"key", "mouse 1", "button -1", "key 0x10000"} {
+		if _, _, err := parsePortalSym(val); err == nil {
+			t.Errorf("parsePortalSym(%q): expected error", val)
+		}
+	}
+}
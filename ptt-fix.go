@@ -12,6 +12,7 @@ import (
 	"path/filepath"
 	"runtime/pprof"
 	"strings"
+	"time"
 
 	"deedles.dev/ptt-fix/internal/config"
 	"golang.org/x/sync/errgroup"
@@ -20,6 +21,8 @@ import (
 type event struct {
 	Type   eventType
 	Device string
+	Code   uint16
+	Time   time.Time
 }
 
 type eventType uint8
@@ -58,6 +61,10 @@ func run(ctx context.Context) error {
 	configPath := flag.String("config", defaultConfigPath, "config file to use for either reading or writing")
 	flag.Parse()
 
+	if flag.Arg(0) == "keysyms" {
+		return runKeysyms(ctx, flag.Args()[1:])
+	}
+
 	if *createConfig {
 		err := os.MkdirAll(filepath.Dir(*configPath), 0700)
 		if err != nil {
@@ -98,17 +105,26 @@ func run(ctx context.Context) error {
 	}
 	logger.Info("loaded config", logPath...)
 
+	var notify *notifier
+	if c.Notify {
+		notify = newNotifier(logger)
+		defer notify.Close()
+		ctx = WithNotifier(ctx, notify)
+	}
+
 	eg, ctx := errgroup.WithContext(ctx)
 
+	codes := keycodes(c.Keys)
+
 	var liseg errgroup.Group
 	ev := make(chan event)
 	for _, dev := range c.Devices {
 		liseg.Go(func() error {
 			return Listener{
-				Device:  dev,
-				Keycode: uint16(c.Key),
-				C:       ev,
-				Retry:   c.Retry,
+				Device:   dev,
+				Keycodes: codes,
+				C:        ev,
+				Retry:    c.Retry,
 			}.Run(ctx)
 		})
 	}
@@ -121,17 +137,30 @@ func run(ctx context.Context) error {
 		return errors.New("no devices available")
 	})
 	eg.Go(func() error {
-		return handle(ctx, c.Sym, ev)
+		return handle(ctx, c, ev)
 	})
 
 	err = eg.Wait()
 	if (err != nil) && !errors.Is(err, context.Canceled) {
+		// Nothing reconnects by itself. Failing to send, such as
+		// because the X server went away, ends ptt-fix here, and it is
+		// up to whatever started it to start it again, so say that
+		// push-to-talk is gone until then.
+		notify.Alert("Push-to-talk stopped", err.Error())
 		return err
 	}
 
 	return nil
 }
 
+func keycodes(keys []uint) []uint16 {
+	codes := make([]uint16, 0, len(keys))
+	for _, key := range keys {
+		codes = append(codes, uint16(key))
+	}
+	return codes
+}
+
 func profile() func() {
 	path, ok := os.LookupEnv("PPROF")
 	if !ok {
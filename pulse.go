@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"deedles.dev/ptt-fix/internal/pulse"
+)
+
+// pulseSender unmutes a PulseAudio (or pipewire-pulse) source while pressed
+// and keeps it muted otherwise, so that the microphone is only live while
+// talking, whatever the application listening to it.
+type pulseSender struct {
+	logger *slog.Logger
+	source string
+
+	c *pulse.Client
+}
+
+// newPulseSender connects to the default pulse server and mutes source, which
+// may be [pulse.DefaultSource].
+func newPulseSender(logger *slog.Logger, source string) (*pulseSender, error) {
+	s := pulseSender{
+		logger: logger.With("source", source),
+		source: source,
+	}
+	if err := s.setMute(true); err != nil {
+		if s.c != nil {
+			s.c.Close()
+		}
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (s *pulseSender) Up() error {
+	return s.setMute(true)
+}
+
+func (s *pulseSender) Down() error {
+	return s.setMute(false)
+}
+
+// Close mutes the source, in case it was left unmuted, and disconnects.
+func (s *pulseSender) Close() error {
+	if s.c == nil {
+		return nil
+	}
+	err := s.c.SetSourceMute(s.source, true)
+	return errors.Join(err, s.c.Close())
+}
+
+// setMute mutes or unmutes the source.
+func (s *pulseSender) setMute(mute bool) error {
+	err := s.trySetMute(mute)
+	if errors.Is(err, pulse.ErrNoEntity) {
+		return fmt.Errorf("%w (see `pactl list short sources` for source names)", err)
+	}
+	return err
+}
+
+// trySetMute mutes or unmutes the source. If the connection has been lost,
+// such as because the server was restarted, it reconnects and tries once
+// more.
+func (s *pulseSender) trySetMute(mute bool) error {
+	if s.c != nil {
+		err := s.c.SetSourceMute(s.source, mute)
+		var perr *pulse.Error
+		if (err == nil) || errors.As(err, &perr) {
+			return err
+		}
+		s.logger.Warn("lost connection to pulse server, reconnecting", errKey, err)
+		s.c.Close()
+		s.c = nil
+	}
+
+	c, err := pulse.Dial("")
+	if err != nil {
+		return err
+	}
+	s.c = c
+	return c.SetSourceMute(s.source, mute)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"deedles.dev/ptt-fix/internal/evdev"
+)
+
+// rumbleMagnitude is the strength of both motors while rumbling.
+const rumbleMagnitude = 0xc000
+
+// rumbleSender briefly rumbles the device that caused each press and
+// release, such as a gamepad, so that the user can feel that transmission
+// started or stopped. Devices are opened for writing the first time that
+// they are needed, and devices without a rumble motor are left alone.
+type rumbleSender struct {
+	logger *slog.Logger
+	length time.Duration
+
+	device string
+	devs   map[string]*rumbleDevice
+}
+
+// rumbleDevice is a device opened by rumbleSender. d is nil if the device
+// can't rumble.
+type rumbleDevice struct {
+	d      *evdev.Device
+	effect int16
+}
+
+func newRumbleSender(logger *slog.Logger, length time.Duration) *rumbleSender {
+	return &rumbleSender{
+		logger: logger,
+		length: length,
+		devs:   make(map[string]*rumbleDevice),
+	}
+}
+
+// NoteEvent implements eventNoter by remembering which device to rumble.
+func (s *rumbleSender) NoteEvent(ev event) {
+	s.device = ev.Device
+}
+
+func (s *rumbleSender) Up() error {
+	return s.play()
+}
+
+func (s *rumbleSender) Down() error {
+	return s.play()
+}
+
+func (s *rumbleSender) play() error {
+	if s.device == "" {
+		return nil
+	}
+
+	dev, err := s.open(s.device)
+	if err != nil {
+		return err
+	}
+	if dev.d == nil {
+		return nil
+	}
+
+	if err := dev.d.PlayEffect(dev.effect); err != nil {
+		// The device may have been unplugged, so open it again next
+		// time.
+		dev.d.Close()
+		delete(s.devs, s.device)
+		return fmt.Errorf("rumble %v: %w", s.device, err)
+	}
+	return nil
+}
+
+// open returns the already opened device at path or opens it and uploads
+// the effect to it.
+func (s *rumbleSender) open(path string) (*rumbleDevice, error) {
+	if dev, ok := s.devs[path]; ok {
+		return dev, nil
+	}
+
+	d, err := evdev.OpenReadWrite(path)
+	if err != nil {
+		return nil, fmt.Errorf("open device for rumble: %w", err)
+	}
+	if !d.CanRumble() {
+		s.logger.Debug("device cannot rumble", "device", path)
+		d.Close()
+		s.devs[path] = &rumbleDevice{}
+		return s.devs[path], nil
+	}
+
+	effect, err := d.UploadRumble(rumbleMagnitude, rumbleMagnitude, s.length)
+	if err != nil {
+		d.Close()
+		return nil, fmt.Errorf("rumble %v: %w", path, err)
+	}
+	s.logger.Info("using device for rumble", "device", path)
+	s.devs[path] = &rumbleDevice{d: d, effect: effect}
+	return s.devs[path], nil
+}
+
+// Close removes the effects and closes the devices.
+func (s *rumbleSender) Close() error {
+	var errs []error
+	for _, dev := range s.devs {
+		if dev.d == nil {
+			continue
+		}
+		errs = append(errs, dev.d.RemoveEffect(dev.effect), dev.d.Close())
+	}
+	clear(s.devs)
+	return errors.Join(errs...)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRumbleSender(t *testing.T) {
+	s := newRumbleSender(slog.New(slog.NewTextHandler(io.Discard, nil)), 100*time.Millisecond)
+	defer s.Close()
+
+	// Nothing has been pressed yet, so there is nothing to rumble.
+	if err := s.Down(); err != nil {
+		t.Fatalf("Down without an event = %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "event0")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	s.NoteEvent(event{Type: eventDown, Device: path})
+	if err := s.Down(); err == nil {
+		t.Fatal("Down on a file that isn't a device succeeded")
+	}
+	if _, ok := s.devs[path]; ok {
+		t.Error("failed device was remembered, so it won't be tried again")
+	}
+}
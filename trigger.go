@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// trigger is the state machine between the listeners and the sender.
+// It debounces raw key events, combines them into a chord, runs the
+// result through the configured mode, and keeps track of whether the
+// sender is currently active so that it can enforce the max-hold limit.
+type trigger struct {
+	logger   *slog.Logger
+	sender   sender
+	debounce *debouncer
+	chord    *chord
+	mode     mode
+	maxHold  time.Duration
+	notify   *notifier
+
+	active bool
+	device string
+	since  time.Time
+
+	// stuck holds devices whose press was cut off by max-hold. Their
+	// events are ignored until they send a release.
+	stuck map[string]struct{}
+}
+
+// Handle feeds a single raw event from a listener through the trigger.
+func (t *trigger) Handle(ev event) error {
+	if t.debounce != nil {
+		var ok bool
+		ev, ok = t.debounce.Update(ev)
+		if !ok {
+			return nil
+		}
+	}
+	return t.handle(ev)
+}
+
+func (t *trigger) handle(ev event) error {
+	if _, ok := t.stuck[ev.Device]; ok {
+		if ev.Type == eventUp {
+			delete(t.stuck, ev.Device)
+			t.logger.Info("device released after max hold", "device", ev.Device)
+		}
+		return nil
+	}
+
+	ev, ok := t.chord.Update(ev)
+	if !ok {
+		return nil
+	}
+	ev, ok = t.mode.Apply(ev)
+	if !ok {
+		return nil
+	}
+	return t.apply(ev)
+}
+
+// Deadline returns the next time at which Expire needs to be called,
+// either to flush a debounced key or because the current activation
+// runs into the max-hold limit.
+func (t *trigger) Deadline() (time.Time, bool) {
+	deadline, ok := t.holdDeadline()
+	if t.debounce != nil {
+		d, dok := t.debounce.Deadline()
+		if dok && (!ok || d.Before(deadline)) {
+			deadline, ok = d, true
+		}
+	}
+	return deadline, ok
+}
+
+func (t *trigger) holdDeadline() (time.Time, bool) {
+	if !t.active || (t.maxHold <= 0) {
+		return time.Time{}, false
+	}
+	return t.since.Add(t.maxHold), true
+}
+
+// Expire handles everything that is due at now. Debounced keys that
+// have settled are passed on. If the activation has lasted past the
+// max-hold limit, the sender is forced up and every device that is
+// still holding part of the chord is forgotten by it and then ignored
+// until it sends a release, so a jammed pedal cannot restart
+// transmission on its own.
+func (t *trigger) Expire(now time.Time) error {
+	if t.debounce != nil {
+		for _, ev := range t.debounce.Flush(now) {
+			if err := t.handle(ev); err != nil {
+				return err
+			}
+		}
+	}
+
+	deadline, ok := t.holdDeadline()
+	if !ok || now.Before(deadline) {
+		return nil
+	}
+
+	t.logger.Warn(
+		"max hold exceeded, forcing release",
+		"device", t.device,
+		"held", now.Sub(t.since),
+		"limit", t.maxHold,
+	)
+	for _, dev := range t.chord.Devices() {
+		if t.stuck == nil {
+			t.stuck = make(map[string]struct{})
+		}
+		t.stuck[dev] = struct{}{}
+		t.chord.Forget(dev)
+		t.logger.Warn("ignoring device until released", "device", dev)
+	}
+	t.mode.Reset()
+	t.notify.Alert(
+		"Max hold reached",
+		fmt.Sprintf("Transmission was stopped after %v. Release the key to use it again.", t.maxHold),
+	)
+	return t.apply(event{Type: eventUp, Device: t.device, Time: now})
+}
+
+// apply sends ev unless the sender is already in the requested state,
+// which can happen after a forced release.
+func (t *trigger) apply(ev event) error {
+	switch {
+	case (ev.Type == eventDown) && t.active:
+		return nil
+	case (ev.Type == eventUp) && !t.active:
+		return nil
+	}
+
+	if err := applyEvent(t.logger, t.sender, ev); err != nil {
+		return err
+	}
+	t.active = ev.Type == eventDown
+	t.device = ev.Device
+	t.since = ev.Time
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"deedles.dev/ptt-fix/internal/keysym"
+	"deedles.dev/ptt-fix/internal/wayland"
+)
+
+// waylandSender presses keys through a virtual keyboard of the Wayland
+// compositor, which reaches native Wayland windows without X. Its keymap
+// only has the keys of its own sym, so the user's layout doesn't matter.
+//
+// Holding a modifier key doesn't make the compositor treat its modifier as
+// held, so that is told to it separately after each modifier key is
+// pressed or released.
+type waylandSender struct {
+	k *wayland.Keyboard
+
+	// masks holds the modifier that each key holds, if any.
+	masks []uint32
+}
+
+// newWaylandSender creates a virtual keyboard for the key sequence keys.
+func newWaylandSender(keys string) (*waylandSender, error) {
+	keymap, masks, err := waylandKeymap(keys)
+	if err != nil {
+		return nil, err
+	}
+	k, err := wayland.NewKeyboard(keymap)
+	if err != nil {
+		if errors.Is(err, wayland.ErrUnsupported) {
+			return nil, fmt.Errorf("%w (GNOME and KDE don't have it, try a portal sym instead)", err)
+		}
+		return nil, fmt.Errorf("create wayland virtual keyboard: %w", err)
+	}
+	return &waylandSender{k: k, masks: masks}, nil
+}
+
+// waylandKeymap returns a keymap for the keysyms in keys and the modifier
+// that each of them holds.
+func waylandKeymap(keys string) (string, []uint32, error) {
+	syms, err := keysym.Parse(keys)
+	if err != nil {
+		return "", nil, fmt.Errorf("resolve keysym %q: %w", keys, err)
+	}
+	names := make([]string, 0, len(syms))
+	masks := make([]uint32, 0, len(syms))
+	for _, sym := range syms {
+		// XKB doesn't know the U+XXXX form, but takes any keysym as
+		// a number.
+		name, ok := keysym.Name(sym)
+		if !ok || strings.HasPrefix(name, "U+") {
+			name = fmt.Sprintf("0x%08x", sym)
+		}
+		names = append(names, name)
+		masks = append(masks, wayland.ModifierMask(name))
+	}
+	keymap, err := wayland.Keymap(names)
+	if err != nil {
+		return "", nil, err
+	}
+	return keymap, masks, nil
+}
+
+// Down presses the keys in order, releasing them again if one fails.
+func (s *waylandSender) Down() error {
+	for i := range s.masks {
+		if err := s.press(i); err != nil {
+			s.release(i)
+			return err
+		}
+	}
+	return nil
+}
+
+// press presses key i while the ones before it are held.
+func (s *waylandSender) press(i int) error {
+	if err := s.k.Key(waylandKeycode(i), true); err != nil {
+		return err
+	}
+	if s.masks[i] == 0 {
+		return nil
+	}
+	if err := s.k.Modifiers(s.modifiers(i + 1)); err != nil {
+		s.k.Key(waylandKeycode(i), false)
+		return err
+	}
+	return nil
+}
+
+// Up releases the keys in reverse order.
+func (s *waylandSender) Up() error {
+	return s.release(len(s.masks))
+}
+
+// release releases the first n keys in reverse order.
+func (s *waylandSender) release(n int) error {
+	var errs []error
+	for i := n - 1; i >= 0; i-- {
+		errs = append(errs, s.k.Key(waylandKeycode(i), false))
+		if s.masks[i] != 0 {
+			errs = append(errs, s.k.Modifiers(s.modifiers(i)))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// modifiers returns the modifiers held while the first n keys are.
+func (s *waylandSender) modifiers(n int) uint32 {
+	var mods uint32
+	for _, m := range s.masks[:n] {
+		mods |= m
+	}
+	return mods
+}
+
+func (s *waylandSender) Close() error {
+	return s.k.Close()
+}
+
+func waylandKeycode(i int) uint32 {
+	return uint32(wayland.KeymapBase + i)
+}
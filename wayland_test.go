@@ -0,0 +1,38 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestWaylandKeymap(t *testing.T) {
+	tests := []struct {
+		keys  string
+		masks []uint32
+		want  []string
+	}{
+		{"Alt_L", []uint32{1 << 3}, []string{"key <K0> { [ Alt_L ] };", "modifier_map Mod1 { <K0> };"}},
+		{"Control_L+F13", []uint32{1 << 2, 0}, []string{"key <K0> { [ Control_L ] };", "key <K1> { [ F13 ] };"}},
+		{"U+1F399", []uint32{0}, []string{"key <K0> { [ 0x0101f399 ] };"}},
+	}
+	for _, tt := range tests {
+		keymap, masks, err := waylandKeymap(tt.keys)
+		if err != nil {
+			t.Errorf("waylandKeymap(%q): %v", tt.keys, err)
+			continue
+		}
+		if !slices.Equal(masks, tt.masks) {
+			t.Errorf("waylandKeymap(%q) has modifiers %v, want %v", tt.keys, masks, tt.masks)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(keymap, want) {
+				t.Errorf("waylandKeymap(%q) does not contain %q:\n%v", tt.keys, want, keymap)
+			}
+		}
+	}
+
+	if _, _, err := waylandKeymap("alt_l"); err == nil {
+		t.Error("waylandKeymap with an unknown name succeeded")
+	}
+}